@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 
+	"hpk/internal/compute/metrics"
 	"hpk/internal/provider"
 
 	"errors"
@@ -27,30 +28,42 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/node/api"
 )
 
-func StartAPIServer(c Opts, virtualk8s *provider.VirtualK8S) {
+// StartAPIServer serves the kubelet API, including the admission webhooks of the given nodes, over TLS.
+func StartAPIServer(c Opts, virtualk8s *provider.VirtualK8S, nodeNames []string) error {
 	mux := http.NewServeMux()
 
 	mux.Handle("/hello", http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		writer.Write([]byte("Hi there! I 'm HPK-Kubelet. My job is to run your Kubernetes stuff on Slurm.\n"))
 	}))
 
+	mux.Handle("/metrics", metrics.Handler())
+
 	/*---------------------------------------------------
 	 * Add handlers for Logs and Statistics
 	 *---------------------------------------------------*/
 	api.AttachPodRoutes(api.PodHandlerConfig{
-		RunInContainer:   virtualk8s.RunInContainer,
-		GetContainerLogs: virtualk8s.GetContainerLogs,
-		GetPods:          virtualk8s.GetPods,
-		PortForward:      virtualk8s.PortForward,
-		// AttachToContainer: ,
+		RunInContainer:    virtualk8s.RunInContainer,
+		AttachToContainer: virtualk8s.AttachToContainer,
+		GetContainerLogs:  virtualk8s.GetContainerLogs,
+		GetPods:           virtualk8s.GetPods,
+		PortForward:       virtualk8s.PortForward,
+		GetStatsSummary:   virtualk8s.GetStatsSummary,
 		// GetPodsFromKubernetes: func(context.Context) ([]*corev1.Pod, error) {
 		//	return k8sclientset.CoreV1().Pods(c.KubeNamespace).List(ctx, labels.Everything())
 		// },
-		// GetStatsSummary:       virtualk8s.GetStatsSummary,
 		// StreamIdleTimeout:     0,
 		// StreamCreationTimeout: 0,
 	}, mux, true)
 
+	/*---------------------------------------------------
+	 * Add handlers for Admission Webhooks
+	 *---------------------------------------------------*/
+	if !c.DisableAdmissionWebhooks {
+		if err := AttachAdmissionRoutes(mux, nodeNames); err != nil {
+			return err
+		}
+	}
+
 	/*---------------------------------------------------
 	 * Start the Webhook on the background
 	 *---------------------------------------------------*/
@@ -74,4 +87,24 @@ func StartAPIServer(c Opts, virtualk8s *provider.VirtualK8S) {
 		"cert", c.K8sAPICertFilepath,
 		"key", c.K8sAPIKeyFilepath,
 	)
+
+	/*---------------------------------------------------
+	 * Start the plain-HTTP Metrics server on the background
+	 *---------------------------------------------------*/
+	if c.MetricsPort > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metrics.Handler())
+
+		metricsAddr := fmt.Sprintf(":%d", c.MetricsPort)
+
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, metricsMux); err != nil && !errors.Is(err, context.Canceled) {
+				logrus.Fatal("Metrics Server has failed. Err:", err)
+			}
+		}()
+
+		DefaultLogger.Info("Metrics server is ready", "Address", metricsAddr)
+	}
+
+	return nil
 }
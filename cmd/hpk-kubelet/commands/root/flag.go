@@ -19,6 +19,7 @@ import (
 	"time"
 
 	"hpk/internal/compute"
+	"hpk/internal/provider"
 
 	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
@@ -39,7 +40,8 @@ type Opts struct {
 	// KubeletPorts determines the port to listen for requests from the Kubernetes API server.
 	KubeletPort int32
 
-	// MetricsPort int32
+	// MetricsPort determines the port to serve Prometheus metrics over plain HTTP. Zero disables it.
+	MetricsPort int32
 
 	K8sAPICertFilepath string
 	K8sAPIKeyFilepath  string
@@ -72,6 +74,30 @@ type Opts struct {
 
 	// PauseImage is the image used for the pause container
 	PauseImage string
+
+	// NodeStatusUpdateInterval is how often the status of the node is refreshed
+	NodeStatusUpdateInterval time.Duration
+
+	// DiskPressureThreshold is the fraction of free space in the working directory below which the node reports DiskPressure
+	DiskPressureThreshold float64
+
+	// VirtualNodes selects whether to register a single node, or one node per Slurm partition (and feature set)
+	VirtualNodes string
+
+	// PartitionTaints taints every partition node with its partition
+	PartitionTaints bool
+
+	// SlurmPolicy is the path to the allow-list against which the Slurm options of the pods are validated
+	SlurmPolicy string
+
+	// DisableAdmissionWebhooks disables the serving and the registration of the admission webhooks
+	DisableAdmissionWebhooks bool
+
+	// WebhookCABundle is the path to the CA that signed the certificate of the kubelet, for the API server to trust the webhooks
+	WebhookCABundle string
+
+	// PVCStorageClasses are the storage classes whose PVCs are bound to the virtual node by the PVC webhook
+	PVCStorageClasses []string
 }
 
 const (
@@ -84,7 +110,7 @@ func installFlags(flags *pflag.FlagSet, c *Opts) {
 	flags.StringVar(&c.KubeletAddress, "kubelet-addr", os.Getenv(EnvKubeletAddress), "which address to tell API server to use")
 	flags.Int32Var(&c.KubeletPort, "kubelet-port", 10250, "port to listen for incoming requests from API server")
 
-	// flags.Int32Var(&c.MetricsPort, "metrics-port", 10255, "address to listen for metrics/stats requests")
+	flags.Int32Var(&c.MetricsPort, "metrics-port", 0, "port to serve /metrics over plain HTTP (0 disables it; /metrics is always served on the kubelet port)")
 
 	flags.StringVar(&c.K8sAPICertFilepath, "certificate", os.Getenv(EnvAPICertLocation), "location for certificate to the API server")
 	flags.StringVar(&c.K8sAPIKeyFilepath, "key", os.Getenv(EnvAPIKeyLocation), "location for key for the API server")
@@ -97,6 +123,10 @@ func installFlags(flags *pflag.FlagSet, c *Opts) {
 	flags.StringVar(&c.DefaultHostEnvironment.WorkingDirectory, "working-dir", GetUserHomeDir(), "sets up the HPK's working directory")
 
 	flags.BoolVar(&c.DefaultHostEnvironment.EnableCgroupV2, "enable-cgroupv2", false, "Enable support for cgroupv2.")
+	flags.StringVar(&c.DefaultHostEnvironment.ContainerLogMaxSize, "container-log-max-size", "10Mi", "maximum size of a container log file before it is rotated")
+	flags.IntVar(&c.DefaultHostEnvironment.ContainerLogMaxFiles, "container-log-max-files", 5, "maximum number of log files that can be present for a container")
+	flags.BoolVar(&c.DefaultHostEnvironment.ServiceProxy, "service-proxy", false, "ClusterIPs are reachable from the compute nodes. Otherwise, service env vars use DNS names and target ports")
+
 	flags.DurationVar(&c.FSPollingInterval, "poll", 5*time.Second, "if greater than 0, it will use a poll based approach to watch for file system changes")
 
 	flags.IntVar(&c.PodSyncWorkers, "pod-sync-workers", 1, `set the number of pod synchronization workers`)
@@ -112,4 +142,16 @@ func installFlags(flags *pflag.FlagSet, c *Opts) {
 	flags.BoolVar(&c.RunSlurm, "run-slurm", true, "run jobs under SLURM or Apptainer")
 	flags.BoolVar(&c.UseTmp, "use-tmp", false, "symlink the pods' volume directories under tmp")
 	flags.StringVar(&c.PauseImage, "pause-image", "docker.io/chazapis/hpk-pause:latest", "image for the pause container")
+
+	flags.DurationVar(&c.NodeStatusUpdateInterval, "node-status-update-frequency", 10*time.Second, "how often the status of the node (capacity, readiness, disk pressure) is refreshed")
+	flags.Float64Var(&c.DiskPressureThreshold, "disk-pressure-threshold", 0.1, "fraction of free space in the working directory below which the node reports DiskPressure")
+
+	flags.StringVar(&c.VirtualNodes, "virtual-nodes", VirtualNodesCluster, "register one node for the cluster ('cluster'), per Slurm partition ('partition'), or per partition and feature set ('partition-features')")
+	flags.BoolVar(&c.PartitionTaints, "partition-taints", false, "taint every partition node with "+provider.PartitionLabel+"=<partition>:NoSchedule")
+
+	flags.StringVar(&c.SlurmPolicy, "slurm-policy", "", "YAML file with the allowed Slurm options of the pods (accounts, qos, partitions, limits, priority classes, custom flags)")
+
+	flags.BoolVar(&c.DisableAdmissionWebhooks, "disable-admission-webhooks", false, "do not serve and register the admission webhooks for pods and PVCs")
+	flags.StringVar(&c.WebhookCABundle, "webhook-ca-bundle", "", "CA bundle with which the API server verifies the webhooks (default is the certificate itself)")
+	flags.StringSliceVar(&c.PVCStorageClasses, "pvc-storage-classes", nil, "storage classes whose new PVCs are bound to the virtual node (PVCs labeled "+provider.BindPVCLabel+"=true are always bound)")
 }
@@ -17,32 +17,25 @@ package root
 import (
 	"context"
 
+	"hpk/internal/compute/podhandler"
+
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	kubeinformers "k8s.io/client-go/informers"
 	corev1 "k8s.io/client-go/informers/core/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 )
 
 func AddInformers(ctx context.Context, c Opts, k8sclientset *kubernetes.Clientset) (
-	corev1.PodInformer,
 	corev1.SecretInformer,
 	corev1.ConfigMapInformer,
 	corev1.ServiceInformer,
 	corev1.PersistentVolumeClaimInformer,
 	error,
 ) {
-	// Create a shared informer factory for Kubernetes Pods assigned to this Node.
-	podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
-		k8sclientset,
-		c.InformerResyncPeriod,
-		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
-			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", c.NodeName).String()
-		}),
-	)
-	podInformer := podInformerFactory.Core().V1().Pods()
-
-	// Create another shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
+	// Create a shared informer factory for Kubernetes secrets and configmaps (not subject to any selectors).
 	informerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(k8sclientset, c.InformerResyncPeriod)
 	secretInformer := informerFactory.Core().V1().Secrets()
 	configMapInformer := informerFactory.Core().V1().ConfigMaps()
@@ -50,7 +43,6 @@ func AddInformers(ctx context.Context, c Opts, k8sclientset *kubernetes.Clientse
 	serviceAccountInformer := informerFactory.Core().V1().ServiceAccounts()
 	pvcInformer := informerFactory.Core().V1().PersistentVolumeClaims()
 
-	podInformer.Lister()
 	secretInformer.Lister()
 	configMapInformer.Lister()
 	serviceInformer.Lister()
@@ -58,11 +50,73 @@ func AddInformers(ctx context.Context, c Opts, k8sclientset *kubernetes.Clientse
 	pvcInformer.Lister()
 
 	// Finally, start the informers.
+	informerFactory.Start(ctx.Done())
+	informerFactory.WaitForCacheSync(ctx.Done())
+
+	return secretInformer, configMapInformer, serviceInformer, pvcInformer, nil
+}
+
+// AddPodInformer starts an informer for the Kubernetes Pods that are assigned to the given node.
+// Every virtual node has its own informer, since the pod controller of a node must not see the pods of the others.
+func AddPodInformer(ctx context.Context, c Opts, k8sclientset *kubernetes.Clientset, nodeName string) corev1.PodInformer {
+	podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(
+		k8sclientset,
+		c.InformerResyncPeriod,
+		kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}),
+	)
+	podInformer := podInformerFactory.Core().V1().Pods()
+
+	podInformer.Lister()
+
 	podInformerFactory.Start(ctx.Done())
 	podInformerFactory.WaitForCacheSync(ctx.Done())
 
-	informerFactory.Start(ctx.Done())
-	informerFactory.WaitForCacheSync(ctx.Done())
+	return podInformer
+}
+
+// AddVolumeRefreshHandlers re-projects the configMaps and secrets into the volumes of the running pods
+// whenever they are created or changed. Creations matter for optional volumes that were missing when the
+// pod started. The objects of the initial listing are skipped, as the volumes are already set up from them.
+func AddVolumeRefreshHandlers(ctx context.Context, secretInformer corev1.SecretInformer, configMapInformer corev1.ConfigMapInformer) error {
+	if _, err := configMapInformer.Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if configMap, ok := obj.(*v1.ConfigMap); ok && !isInInitialList {
+				podhandler.RefreshConfigMapVolumes(ctx, configMap)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldConfigMap, okOld := oldObj.(*v1.ConfigMap)
+			newConfigMap, okNew := newObj.(*v1.ConfigMap)
+
+			// periodic resyncs deliver the same version.
+			if okOld && okNew && oldConfigMap.GetResourceVersion() != newConfigMap.GetResourceVersion() {
+				podhandler.RefreshConfigMapVolumes(ctx, newConfigMap)
+			}
+		},
+	}); err != nil {
+		return err
+	}
+
+	if _, err := secretInformer.Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if secret, ok := obj.(*v1.Secret); ok && !isInInitialList {
+				podhandler.RefreshSecretVolumes(ctx, secret)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSecret, okOld := oldObj.(*v1.Secret)
+			newSecret, okNew := newObj.(*v1.Secret)
+
+			// periodic resyncs deliver the same version.
+			if okOld && okNew && oldSecret.GetResourceVersion() != newSecret.GetResourceVersion() {
+				podhandler.RefreshSecretVolumes(ctx, newSecret)
+			}
+		},
+	}); err != nil {
+		return err
+	}
 
-	return podInformer, secretInformer, configMapInformer, serviceInformer, pvcInformer, nil
+	return nil
 }
@@ -0,0 +1,247 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/slurm"
+	"hpk/internal/provider"
+
+	"github.com/sirupsen/logrus"
+	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
+	"github.com/virtual-kubelet/virtual-kubelet/node"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	informerscorev1 "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+)
+
+// Modes of --virtual-nodes.
+const (
+	// VirtualNodesCluster registers a single node for the entire cluster.
+	VirtualNodesCluster = "cluster"
+
+	// VirtualNodesPartition registers one node per Slurm partition.
+	VirtualNodesPartition = "partition"
+
+	// VirtualNodesPartitionFeatures registers one node per Slurm partition and distinct set of node features.
+	VirtualNodesPartitionFeatures = "partition-features"
+)
+
+// NewVirtualNodes creates the virtual nodes that are selected by --virtual-nodes.
+//
+// In the partition modes, the nodes are named after the --nodename, followed by the partition and the features.
+// Every node is tainted with the given taints, and optionally with its partition (--partition-taints).
+func NewVirtualNodes(ctx context.Context, c Opts, virtualk8s *provider.VirtualK8S, taints []corev1.Taint) ([]*provider.VirtualNode, error) {
+	var byFeatures bool
+
+	switch c.VirtualNodes {
+	case VirtualNodesCluster:
+		return []*provider.VirtualNode{virtualk8s.NewVirtualNode(ctx, c.NodeName, slurm.Partition{}, taints)}, nil
+	case VirtualNodesPartition:
+		byFeatures = false
+	case VirtualNodesPartitionFeatures:
+		byFeatures = true
+	default:
+		return nil, errdefs.InvalidInputf("virtual nodes mode %q is not supported", c.VirtualNodes)
+	}
+
+	if !c.RunSlurm {
+		return nil, errdefs.InvalidInputf("virtual nodes mode %q requires Slurm", c.VirtualNodes)
+	}
+
+	partitions, err := slurm.Partitions(ctx, byFeatures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the partitions: %w", err)
+	}
+
+	if len(partitions) == 0 {
+		return nil, errdefs.InvalidInput("no Slurm partitions found")
+	}
+
+	owners := make(map[string]slurm.Partition, len(partitions))
+	nodes := make([]*provider.VirtualNode, 0, len(partitions))
+
+	for _, partition := range partitions {
+		nodeName := partitionNodeName(c.NodeName, partition)
+
+		if owner, exists := owners[nodeName]; exists {
+			return nil, errdefs.InvalidInputf("partitions '%s' and '%s' are both mapped to node '%s'", owner, partition, nodeName)
+		}
+
+		owners[nodeName] = partition
+
+		nodeTaints := append(make([]corev1.Taint, 0, len(taints)+1), taints...)
+
+		if c.PartitionTaints && len(validation.IsValidLabelValue(partition.Name)) == 0 {
+			nodeTaints = append(nodeTaints, corev1.Taint{
+				Key:    provider.PartitionLabel,
+				Value:  partition.Name,
+				Effect: corev1.TaintEffectNoSchedule,
+			})
+		}
+
+		nodes = append(nodes, virtualk8s.NewVirtualNode(ctx, nodeName, partition, nodeTaints))
+
+		DefaultLogger.Info("Virtual node is created", "node", nodeName, "partition", partition.String())
+	}
+
+	return nodes, nil
+}
+
+// partitionNodeName returns a valid node name (RFC 1123 subdomain) for the partition.
+func partitionNodeName(prefix string, partition slurm.Partition) string {
+	parts := append([]string{prefix, partition.Name}, partition.Features...)
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '-'
+		}
+	}, strings.ToLower(strings.Join(parts, "-")))
+
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		name = name[:validation.DNS1123SubdomainMaxLength]
+	}
+
+	return strings.Trim(name, "-.")
+}
+
+// RunVirtualNode runs the pod controller and the node controller of the virtual node, and blocks
+// until the node controller is terminated.
+func RunVirtualNode(ctx context.Context, c Opts, virtualNode *provider.VirtualNode,
+	secretInformer informerscorev1.SecretInformer,
+	configMapInformer informerscorev1.ConfigMapInformer,
+	serviceInformer informerscorev1.ServiceInformer,
+) error {
+	/*---------------------------------------------------
+	 * Create Pod Controller
+	 *---------------------------------------------------*/
+	{
+		podInformer := AddPodInformer(ctx, c, compute.K8SClientset, virtualNode.Name)
+
+		eb := record.NewBroadcaster()
+		eb.StartLogging(logrus.Infof)
+
+		pc, err := node.NewPodController(node.PodControllerConfig{
+			PodClient:                            compute.K8SClientset.CoreV1(),
+			PodInformer:                          podInformer,
+			EventRecorder:                        eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "hpk-controller", Host: virtualNode.Name}),
+			Provider:                             virtualNode,
+			ConfigMapInformer:                    configMapInformer,
+			SecretInformer:                       secretInformer,
+			ServiceInformer:                      serviceInformer,
+			SyncPodsFromKubernetesRateLimiter:    rateLimiter(),
+			DeletePodsFromKubernetesRateLimiter:  rateLimiter(),
+			SyncPodStatusFromProviderRateLimiter: rateLimiter(),
+			// the environment of containers is resolved by the provider, including the status.podIP
+			// which is only known once the pod is running on Slurm.
+			SkipDownwardAPIResolution: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Start the Pod controller.
+		go func() {
+			if err := pc.Run(ctx, c.PodSyncWorkers); err != nil && !errors.Is(err, context.Canceled) {
+				DefaultLogger.Error(err, "Pod Controller Has failed", "node", virtualNode.Name)
+				// handle error
+			}
+		}()
+
+		// If there is a startup timeout, it does two things:
+		// 1. It causes the VK to shut down if we haven't gotten into an operational state in a time period
+		// 2. It prevents node advertisement from happening until we're in an operational state
+		if c.StartupTimeout > 0 {
+			pcCtx, pcCancel := context.WithTimeout(ctx, c.StartupTimeout)
+			select {
+			case <-pcCtx.Done():
+				DefaultLogger.Info("... Aborted before initialization is complete ....", "node", virtualNode.Name)
+
+				pcCancel()
+				return pcCtx.Err()
+			case <-pc.Ready():
+			}
+			pcCancel()
+			if err := pc.Err(); err != nil {
+				return err
+			}
+		}
+
+		DefaultLogger.Info("Pod Controller is Ready", "node", virtualNode.Name)
+	}
+
+	/*---------------------------------------------------
+	 * Create Node Controller
+	 *---------------------------------------------------*/
+	{
+		// the provider refreshes the status of the node, including its readiness, on its own.
+		nc, err := node.NewNodeController(
+			virtualNode,
+			virtualNode.Node(),
+			compute.K8SClientset.CoreV1().Nodes(),
+			node.WithNodeEnableLeaseV1(compute.K8SClientset.CoordinationV1().Leases(corev1.NamespaceNodeLease), 0),
+			node.WithNodeStatusUpdateErrorHandler(func(ctx context.Context, err error) error {
+				if !k8serrors.IsNotFound(err) {
+					return err
+				}
+
+				DefaultLogger.Info("node not found", "node", virtualNode.Name)
+				newNode := virtualNode.Node()
+				newNode.ResourceVersion = ""
+
+				if _, err = compute.K8SClientset.CoreV1().Nodes().Create(ctx, newNode, metav1.CreateOptions{}); err != nil {
+					return err
+				}
+
+				DefaultLogger.Info("created new node", "node", virtualNode.Name)
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+
+		// Start the Node controller.
+		go func() {
+			if err := nc.Run(ctx); err != nil && err != context.Canceled {
+				DefaultLogger.Error(err, "NodeController has failed", "node", virtualNode.Name)
+
+				// handle error
+			}
+		}()
+
+		// Wait for node controller to become ready
+		<-nc.Ready()
+
+		DefaultLogger.Info("Node Controller is Ready", "node", virtualNode.Name)
+
+		// wait for as long the app is running
+		<-nc.Done()
+
+		return nc.Err()
+	}
+}
@@ -24,16 +24,13 @@ import (
 
 	"hpk/cmd/hpk-kubelet/commands"
 	"hpk/internal/compute"
+	"hpk/internal/compute/slurm"
 
 	"github.com/hashicorp/go-multierror"
-	"github.com/sirupsen/logrus"
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	vklog "github.com/virtual-kubelet/virtual-kubelet/log"
 	"github.com/virtual-kubelet/virtual-kubelet/log/klogv2"
 	"golang.org/x/time/rate"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
@@ -45,7 +42,6 @@ import (
 
 	"github.com/dimiro1/banner"
 	"github.com/spf13/cobra"
-	"github.com/virtual-kubelet/virtual-kubelet/node"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -156,6 +152,15 @@ func runRootCommand(ctx context.Context, c Opts) error {
 		compute.Environment.RunSlurm = c.RunSlurm
 		compute.Environment.PauseImage = c.PauseImage
 
+		if c.SlurmPolicy != "" {
+			policy, err := slurm.LoadPolicy(c.SlurmPolicy)
+			if err != nil {
+				return fmt.Errorf("failed to load the Slurm policy: %w", err)
+			}
+
+			slurm.DefaultPolicy = policy
+		}
+
 		kubemaster, err := url.Parse(restConfig.Host)
 		if err != nil {
 			return fmt.Errorf("failed to extract hostname from url '%s': %w", restConfig.Host, err)
@@ -197,6 +202,7 @@ func runRootCommand(ctx context.Context, c Opts) error {
 	 * Register the Provisioner of Virtual Nodes
 	 *---------------------------------------------------*/
 	virtualk8s, err := provider.NewVirtualK8S(provider.InitConfig{
+		NodeName:          c.NodeName,
 		InternalIP:        c.KubeletAddress,
 		DaemonPort:        c.KubeletPort,
 		BuildVersion:      commands.BuildVersion,
@@ -204,152 +210,101 @@ func runRootCommand(ctx context.Context, c Opts) error {
 		RestConfig:        restConfig,
 		UseTmp:            c.UseTmp,
 		PauseImage:        c.PauseImage,
+
+		NodeStatusUpdateInterval: c.NodeStatusUpdateInterval,
+		DiskPressureThreshold:    c.DiskPressureThreshold,
 	})
 	if err != nil {
 		return err
 	}
 
-	StartAPIServer(c, virtualk8s)
-
-	DefaultLogger.Info("Virtual Node Provisioner is ready",
-		"Address", virtualk8s.InternalIP,
-		"DaemonPort", virtualk8s.DaemonPort,
-	)
-
 	/*---------------------------------------------------
-	 * Create Informers for CRDs and Pod Controller
+	 * Create Informers for CRDs
 	 *---------------------------------------------------*/
-	{
-		podInformer, secretInformer, configMapInformer, serviceInformer, _, err := AddInformers(ctx, c, compute.K8SClientset)
-		if err != nil {
-			return fmt.Errorf("failed to add informers: %w", err)
-		}
+	secretInformer, configMapInformer, serviceInformer, _, err := AddInformers(ctx, c, compute.K8SClientset)
+	if err != nil {
+		return fmt.Errorf("failed to add informers: %w", err)
+	}
 
-		DefaultLogger.Info("Informers are ready",
-			"namespace", c.KubeNamespace,
-			"crds", []string{
-				"pods", "secrets", "configMap", "service", "serviceAccount",
-			})
-
-		eb := record.NewBroadcaster()
-		eb.StartLogging(logrus.Infof)
-
-		pc, err := node.NewPodController(node.PodControllerConfig{
-			PodClient:                            compute.K8SClientset.CoreV1(),
-			PodInformer:                          podInformer,
-			EventRecorder:                        eb.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "hpk-controller"}),
-			Provider:                             virtualk8s,
-			ConfigMapInformer:                    configMapInformer,
-			SecretInformer:                       secretInformer,
-			ServiceInformer:                      serviceInformer,
-			SyncPodsFromKubernetesRateLimiter:    rateLimiter(),
-			DeletePodsFromKubernetesRateLimiter:  rateLimiter(),
-			SyncPodStatusFromProviderRateLimiter: rateLimiter(),
+	DefaultLogger.Info("Informers are ready",
+		"namespace", c.KubeNamespace,
+		"crds", []string{
+			"secrets", "configMap", "service", "serviceAccount",
 		})
-		if err != nil {
-			return err
-		}
 
-		// Start the Pod controller.
-		go func() {
-			if err := pc.Run(ctx, c.PodSyncWorkers); err != nil && !errors.Is(err, context.Canceled) {
-				DefaultLogger.Error(err, "Pod Controller Has failed")
-				// handle error
-			}
-		}()
-
-		// If there is a startup timeout, it does two things:
-		// 1. It causes the VK to shut down if we haven't gotten into an operational state in a time period
-		// 2. It prevents node advertisement from happening until we're in an operational state
-		if c.StartupTimeout > 0 {
-			pcCtx, pcCancel := context.WithTimeout(ctx, c.StartupTimeout)
-			select {
-			case <-pcCtx.Done():
-				DefaultLogger.Info("... Aborted before initialization is complete ....")
-
-				pcCancel()
-				return pcCtx.Err()
-			case <-pc.Ready():
-			}
-			pcCancel()
-			if err := pc.Err(); err != nil {
-				return err
-			}
-		}
+	compute.ConfigMapLister = configMapInformer.Lister()
+	compute.SecretLister = secretInformer.Lister()
+	compute.ServiceLister = serviceInformer.Lister()
 
-		DefaultLogger.Info("Pod Controller is Ready")
+	if err := AddVolumeRefreshHandlers(ctx, secretInformer, configMapInformer); err != nil {
+		return fmt.Errorf("failed to add volume refresh handlers: %w", err)
 	}
 
 	/*---------------------------------------------------
-	 * Create Node Controller
+	 * Register the Virtual Nodes
 	 *---------------------------------------------------*/
-	{
-		np := node.NewNaiveNodeProvider()
-
-		var taint *corev1.Taint
-		if !c.DisableTaint {
-			taint, err = getTaint(c)
-			if err != nil {
-				return err
-			}
-		}
+	var taints []corev1.Taint
 
-		virtualNode := virtualk8s.NewVirtualNode(ctx, c.NodeName, taint)
-
-		nc, err := node.NewNodeController(
-			np,
-			virtualNode,
-			compute.K8SClientset.CoreV1().Nodes(),
-			node.WithNodeEnableLeaseV1(compute.K8SClientset.CoordinationV1().Leases(corev1.NamespaceNodeLease), 0),
-			node.WithNodeStatusUpdateErrorHandler(func(ctx context.Context, err error) error {
-				if !k8serrors.IsNotFound(err) {
-					return err
-				}
-
-				DefaultLogger.Info("node not found")
-				newNode := virtualNode.DeepCopy()
-				newNode.ResourceVersion = ""
-
-				if _, err = compute.K8SClientset.CoreV1().Nodes().Create(ctx, newNode, metav1.CreateOptions{}); err != nil {
-					return err
-				}
-
-				DefaultLogger.Info("created new node")
-				return nil
-			}),
-		)
+	if !c.DisableTaint {
+		taint, err := getTaint(c)
 		if err != nil {
 			return err
 		}
 
-		// Start the Node controller.
-		go func() {
-			if err := nc.Run(ctx); err != nil && err != context.Canceled {
-				DefaultLogger.Error(err, "NodeController has failed")
+		taints = append(taints, *taint)
+	}
 
-				// handle error
-			}
-		}()
+	virtualNodes, err := NewVirtualNodes(ctx, c, virtualk8s, taints)
+	if err != nil {
+		return fmt.Errorf("failed to create virtual nodes: %w", err)
+	}
+
+	nodeNames := make([]string, 0, len(virtualNodes))
+
+	for _, virtualNode := range virtualNodes {
+		nodeNames = append(nodeNames, virtualNode.Name)
+	}
 
-		// Wait for node controller to become ready
-		<-nc.Ready()
+	/*---------------------------------------------------
+	 * Start the API Server and the Admission Webhooks
+	 *---------------------------------------------------*/
+	if err := StartAPIServer(c, virtualk8s, nodeNames); err != nil {
+		return fmt.Errorf("failed to start the API server: %w", err)
+	}
+
+	DefaultLogger.Info("Virtual Node Provisioner is ready",
+		"Address", virtualk8s.InternalIP,
+		"DaemonPort", virtualk8s.DaemonPort,
+	)
 
-		// If we got here, set Node condition Ready.
-		setNodeReady(virtualNode)
-		if err := np.UpdateStatus(ctx, virtualNode); err != nil {
-			return fmt.Errorf("error marking the node as ready: %w", err)
+	if !c.DisableAdmissionWebhooks {
+		if err := RegisterAdmissionWebhooks(ctx, c, nodeNames); err != nil {
+			return fmt.Errorf("failed to register the admission webhooks: %w", err)
 		}
 
-		DefaultLogger.Info("Node Controller is Ready")
+		DefaultLogger.Info("Admission webhooks are registered", "nodes", nodeNames)
+	}
 
-		DefaultLogger.Info("... HPK is successfully initialized and waiting for jobs....")
+	/*---------------------------------------------------
+	 * Run the Pod and Node Controllers of every Node
+	 *---------------------------------------------------*/
+	done := make(chan error, len(virtualNodes))
+
+	for _, virtualNode := range virtualNodes {
+		go func(virtualNode *provider.VirtualNode) {
+			done <- RunVirtualNode(ctx, c, virtualNode, secretInformer, configMapInformer, serviceInformer)
+		}(virtualNode)
+	}
 
-		// wait for as long the app is running
-		<-nc.Done()
+	DefaultLogger.Info("... HPK is successfully initialized and waiting for jobs....", "nodes", len(virtualNodes))
 
-		DefaultLogger.Info("... HPK has been gracefully terminated ....")
+	// wait for as long the app is running
+	if err := <-done; err != nil {
+		return err
 	}
 
+	DefaultLogger.Info("... HPK has been gracefully terminated ....")
+
 	return nil
 }
 
@@ -361,22 +316,6 @@ func rateLimiter() workqueue.RateLimiter {
 	)
 }
 
-func setNodeReady(n *corev1.Node) {
-	for i, c := range n.Status.Conditions {
-		if c.Type != corev1.NodeReady {
-			continue
-		}
-
-		c.LastHeartbeatTime = metav1.Now()
-		c.LastTransitionTime = metav1.Now()
-		c.Reason = "KubeletReady"
-		c.Message = "HPK is successfully connected to Slurm"
-		c.Status = corev1.ConditionTrue
-		n.Status.Conditions[i] = c
-		return
-	}
-}
-
 // getTaint creates a taint using the provided key/value.
 // Taint effect is read from the environment
 // The taint key/value may be overwritten by the environment.
@@ -0,0 +1,277 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package root
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"hpk/internal/compute"
+	"hpk/internal/provider"
+
+	"github.com/sirupsen/logrus"
+	kwhhttp "github.com/slok/kubewebhook/v2/pkg/http"
+	kwhlogrus "github.com/slok/kubewebhook/v2/pkg/log/logrus"
+	kwhmutating "github.com/slok/kubewebhook/v2/pkg/webhook/mutating"
+	kwhvalidating "github.com/slok/kubewebhook/v2/pkg/webhook/validating"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// Paths of the admission webhooks on the HTTPS server of the kubelet.
+const (
+	MutatePodsPath   = "/mutate-pods"
+	MutatePVCsPath   = "/mutate-pvcs"
+	ValidatePodsPath = "/validate-pods"
+)
+
+// AdmissionWebhookTimeout bounds the time that the API server waits for the kubelet. Since the failure
+// policy is to ignore the webhooks, an unavailable kubelet delays the admission only up to this time.
+const AdmissionWebhookTimeout = 5
+
+// AttachAdmissionRoutes serves the admission webhooks. The PVCs that opt in are bound to the first of the virtual nodes.
+func AttachAdmissionRoutes(mux *http.ServeMux, nodeNames []string) error {
+	logger := kwhlogrus.NewLogrus(logrus.NewEntry(logrus.StandardLogger()))
+
+	podMutator, err := kwhmutating.NewWebhook(kwhmutating.WebhookConfig{
+		ID:      "hpk-pod-mutator",
+		Obj:     &corev1.Pod{},
+		Mutator: kwhmutating.MutatorFunc(provider.MutatePod),
+		Logger:  logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the pod mutator: %w", err)
+	}
+
+	pvcMutator, err := kwhmutating.NewWebhook(kwhmutating.WebhookConfig{
+		ID:      "hpk-pvc-mutator",
+		Obj:     &corev1.PersistentVolumeClaim{},
+		Mutator: provider.MutatePVC(nodeNames[0]),
+		Logger:  logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the pvc mutator: %w", err)
+	}
+
+	podValidator, err := kwhvalidating.NewWebhook(kwhvalidating.WebhookConfig{
+		ID:        "hpk-pod-validator",
+		Obj:       &corev1.Pod{},
+		Validator: kwhvalidating.ValidatorFunc(provider.ValidatePod),
+		Logger:    logger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the pod validator: %w", err)
+	}
+
+	mux.Handle(MutatePodsPath, kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{Webhook: podMutator, Logger: logger}))
+	mux.Handle(MutatePVCsPath, kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{Webhook: pvcMutator, Logger: logger}))
+	mux.Handle(ValidatePodsPath, kwhhttp.MustHandlerFor(kwhhttp.HandlerConfig{Webhook: podValidator, Logger: logger}))
+
+	return nil
+}
+
+// RegisterAdmissionWebhooks creates, or updates, the webhook configurations through which the API server
+// calls the kubelet. The pod webhooks are scoped to the pods that target the virtual nodes, and the PVC webhook
+// to the PVCs that opt in, either by label or by storage class.
+//
+// The webhooks are served by the kubelet itself, outside the cluster, so they are addressed by URL
+// and their failures are ignored, so that an unavailable kubelet does not block the cluster.
+func RegisterAdmissionWebhooks(ctx context.Context, c Opts, nodeNames []string) error {
+	if c.KubeletAddress == "" {
+		return fmt.Errorf("the kubelet address is required by the API server to reach the webhooks")
+	}
+
+	caBundlePath := c.WebhookCABundle
+	if caBundlePath == "" {
+		// a self-signed certificate is its own CA.
+		caBundlePath = c.K8sAPICertFilepath
+	}
+
+	caBundle, err := os.ReadFile(caBundlePath)
+	if err != nil {
+		return fmt.Errorf("failed to read the CA bundle: %w", err)
+	}
+
+	clientConfig := func(path string) admissionregistrationv1.WebhookClientConfig {
+		return admissionregistrationv1.WebhookClientConfig{
+			URL:      ptr.To(fmt.Sprintf("https://%s:%d%s", c.KubeletAddress, c.KubeletPort, path)),
+			CABundle: caBundle,
+		}
+	}
+
+	rule := func(resource string) []admissionregistrationv1.RuleWithOperations {
+		return []admissionregistrationv1.RuleWithOperations{{
+			Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.Create},
+			Rule: admissionregistrationv1.Rule{
+				APIGroups:   []string{""},
+				APIVersions: []string{"v1"},
+				Resources:   []string{resource},
+				Scope:       ptr.To(admissionregistrationv1.NamespacedScope),
+			},
+		}}
+	}
+
+	var namespaceSelector *metav1.LabelSelector
+
+	if c.KubeNamespace != corev1.NamespaceAll {
+		namespaceSelector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{corev1.LabelMetadataName: c.KubeNamespace},
+		}
+	}
+
+	podConditions := []admissionregistrationv1.MatchCondition{{
+		Name:       "targets-hpk",
+		Expression: targetsNodesExpression(nodeNames),
+	}}
+
+	pvcConditions := []admissionregistrationv1.MatchCondition{{
+		Name:       "binds-to-hpk",
+		Expression: bindsPVCExpression(c.PVCStorageClasses),
+	}}
+
+	/*---------------------------------------------------
+	 * Mutating Webhooks
+	 *---------------------------------------------------*/
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: c.NodeName},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{
+			{
+				Name:                    "pods.mutating.hpk.io",
+				ClientConfig:            clientConfig(MutatePodsPath),
+				Rules:                   rule("pods"),
+				NamespaceSelector:       namespaceSelector,
+				MatchConditions:         podConditions,
+				FailurePolicy:           ptr.To(admissionregistrationv1.Ignore),
+				SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+				TimeoutSeconds:          ptr.To(int32(AdmissionWebhookTimeout)),
+				AdmissionReviewVersions: []string{"v1"},
+			},
+			{
+				Name:                    "pvcs.mutating.hpk.io",
+				ClientConfig:            clientConfig(MutatePVCsPath),
+				Rules:                   rule("persistentvolumeclaims"),
+				NamespaceSelector:       namespaceSelector,
+				MatchConditions:         pvcConditions,
+				FailurePolicy:           ptr.To(admissionregistrationv1.Ignore),
+				SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+				TimeoutSeconds:          ptr.To(int32(AdmissionWebhookTimeout)),
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	mutatingClient := compute.K8SClientset.AdmissionregistrationV1().MutatingWebhookConfigurations()
+
+	if existing, err := mutatingClient.Get(ctx, mutating.GetName(), metav1.GetOptions{}); err == nil {
+		mutating.ResourceVersion = existing.ResourceVersion
+
+		if _, err := mutatingClient.Update(ctx, mutating, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update the mutating webhooks: %w", err)
+		}
+	} else if k8serrors.IsNotFound(err) {
+		if _, err := mutatingClient.Create(ctx, mutating, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the mutating webhooks: %w", err)
+		}
+	} else {
+		return fmt.Errorf("failed to get the mutating webhooks: %w", err)
+	}
+
+	/*---------------------------------------------------
+	 * Validating Webhooks
+	 *---------------------------------------------------*/
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: c.NodeName},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{
+				Name:                    "pods.validating.hpk.io",
+				ClientConfig:            clientConfig(ValidatePodsPath),
+				Rules:                   rule("pods"),
+				NamespaceSelector:       namespaceSelector,
+				MatchConditions:         podConditions,
+				FailurePolicy:           ptr.To(admissionregistrationv1.Ignore),
+				SideEffects:             ptr.To(admissionregistrationv1.SideEffectClassNone),
+				TimeoutSeconds:          ptr.To(int32(AdmissionWebhookTimeout)),
+				AdmissionReviewVersions: []string{"v1"},
+			},
+		},
+	}
+
+	validatingClient := compute.K8SClientset.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+
+	if existing, err := validatingClient.Get(ctx, validating.GetName(), metav1.GetOptions{}); err == nil {
+		validating.ResourceVersion = existing.ResourceVersion
+
+		if _, err := validatingClient.Update(ctx, validating, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("failed to update the validating webhooks: %w", err)
+		}
+	} else if k8serrors.IsNotFound(err) {
+		if _, err := validatingClient.Create(ctx, validating, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the validating webhooks: %w", err)
+		}
+	} else {
+		return fmt.Errorf("failed to get the validating webhooks: %w", err)
+	}
+
+	return nil
+}
+
+// targetsNodesExpression returns a CEL expression that matches the pods which are bound to the virtual nodes,
+// or select them by hostname or by partition. Tolerating the taint of hpk is not enough, as such pods may
+// still run on other nodes.
+func targetsNodesExpression(nodeNames []string) string {
+	nodes := celList(nodeNames)
+
+	conditions := []string{
+		fmt.Sprintf("(has(object.spec.nodeName) && object.spec.nodeName in %s)", nodes),
+		fmt.Sprintf("(has(object.spec.nodeSelector) && %q in object.spec.nodeSelector && object.spec.nodeSelector[%q] in %s)",
+			corev1.LabelHostname, corev1.LabelHostname, nodes),
+		fmt.Sprintf("(has(object.spec.nodeSelector) && %q in object.spec.nodeSelector)", provider.PartitionLabel),
+	}
+
+	return strings.Join(conditions, " || ")
+}
+
+// bindsPVCExpression returns a CEL expression that matches the PVCs which are labeled to be bound to hpk,
+// or request one of the given storage classes.
+func bindsPVCExpression(storageClasses []string) string {
+	conditions := []string{
+		fmt.Sprintf("(has(object.metadata.labels) && %q in object.metadata.labels && object.metadata.labels[%q] == \"true\")",
+			provider.BindPVCLabel, provider.BindPVCLabel),
+	}
+
+	if len(storageClasses) > 0 {
+		conditions = append(conditions,
+			fmt.Sprintf("(has(object.spec.storageClassName) && object.spec.storageClassName in %s)", celList(storageClasses)))
+	}
+
+	return strings.Join(conditions, " || ")
+}
+
+// celList returns the CEL literal of a list of strings.
+func celList(values []string) string {
+	quoted := make([]string, 0, len(values))
+
+	for _, value := range values {
+		quoted = append(quoted, fmt.Sprintf("%q", value))
+	}
+
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
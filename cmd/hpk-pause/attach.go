@@ -0,0 +1,411 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"hpk/internal/compute/control"
+
+	"github.com/creack/pty"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// attachClientBuffer is the number of output chunks that are queued for an attached client. Clients that fall
+// further behind are disconnected, so that they cannot stall the container or the other clients.
+const attachClientBuffer = 64
+
+// attachPoints holds the attach points of the containers, so that `kubectl attach` can find them.
+var attachPoints = attachRegistry{points: make(map[string]*attachPoint)}
+
+type attachRegistry struct {
+	mu     sync.Mutex
+	points map[string]*attachPoint
+}
+
+// get returns the attach point of the container, creating it on the first call.
+func (r *attachRegistry) get(container *v1.Container) *attachPoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	point, ok := r.points[container.Name]
+	if !ok {
+		point = &attachPoint{
+			tty:       container.TTY,
+			stdin:     container.Stdin,
+			stdinOnce: container.StdinOnce,
+			clients:   make(map[*attachClient]struct{}),
+		}
+
+		r.points[container.Name] = point
+	}
+
+	return point
+}
+
+func (r *attachRegistry) lookup(name string) *attachPoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.points[name]
+}
+
+// attachPoint multiplexes the streams of a container between its log file and the attached clients.
+// Containers with tty get a pty, and containers with stdin get a pipe, which is fed by the attached clients.
+type attachPoint struct {
+	tty       bool
+	stdin     bool
+	stdinOnce bool
+
+	mu sync.Mutex
+
+	// input is the stdin of the running instance of the container, if any.
+	input io.WriteCloser
+
+	// ptmx is the pty of the running instance of the container, if the container has a tty.
+	ptmx *os.File
+
+	// size is the last known terminal size, which is applied to new instances of the container.
+	size *pty.Winsize
+
+	clients map[*attachClient]struct{}
+}
+
+type attachClient struct {
+	stdout io.Writer
+	stderr io.Writer
+
+	// output queues the output of the container, which is written to the client by its session.
+	output chan attachOutput
+
+	// dropped is closed if the client has been disconnected for not keeping up with the output.
+	dropped  chan struct{}
+	dropOnce sync.Once
+
+	// exit delivers the exit code of the container instance to which the client is attached.
+	exit chan int
+}
+
+type attachOutput struct {
+	stream io.Writer
+	data   []byte
+}
+
+func newAttachClient() *attachClient {
+	return &attachClient{
+		output:  make(chan attachOutput, attachClientBuffer),
+		dropped: make(chan struct{}),
+		exit:    make(chan int, 1),
+	}
+}
+
+// drop disconnects the client from the output of the container.
+func (c *attachClient) drop() {
+	c.dropOnce.Do(func() { close(c.dropped) })
+}
+
+// flush writes the queued output to the client.
+func (c *attachClient) flush() {
+	for {
+		select {
+		case out := <-c.output:
+			c.write(out)
+		default:
+			return
+		}
+	}
+}
+
+func (c *attachClient) write(out attachOutput) {
+	if _, err := out.stream.Write(out.data); err != nil {
+		// the client has gone away. It is removed once its session ends.
+		log.Debug().Err(err).Msg("Failed to write to attached client")
+	}
+}
+
+// start starts the command of the container, with its output written both to the given log streams
+// and to the attached clients. The returned function must be called once the command has exited.
+func (a *attachPoint) start(cmd *exec.Cmd, stdout io.Writer, stderr io.Writer) (func(), error) {
+	/*-- Containers with tty share a single stream for input and output --*/
+	if a.tty {
+		// the container becomes the leader of a new session, so that it can be killed along with its children.
+		ptmx, err := pty.StartWithAttrs(cmd, a.size, &syscall.SysProcAttr{Setsid: true, Setctty: true})
+		if err != nil {
+			return nil, err
+		}
+
+		a.mu.Lock()
+		a.ptmx = ptmx
+		if a.stdin {
+			a.input = ptmx
+		}
+		a.mu.Unlock()
+
+		outputDone := make(chan struct{})
+
+		go func() {
+			defer close(outputDone)
+
+			// the copy ends with EIO, once the container and its children have closed the terminal.
+			_, _ = io.Copy(a.output(control.FrameStdout, stdout), ptmx)
+		}()
+
+		return func() {
+			select {
+			case <-outputDone:
+			case <-time.After(logDrainTimeout):
+			}
+
+			a.mu.Lock()
+			a.ptmx, a.input = nil, nil
+			a.mu.Unlock()
+
+			_ = ptmx.Close()
+		}, nil
+	}
+
+	/*-- Otherwise, stdin is a pipe and the output streams are multiplexed --*/
+	cmd.Stdout = a.output(control.FrameStdout, stdout)
+	cmd.Stderr = a.output(control.FrameStderr, stderr)
+	cmd.WaitDelay = logDrainTimeout
+
+	// place the container in its own process group, so that it can be killed along with its children.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var input io.WriteCloser
+
+	if a.stdin {
+		pipe, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+
+		input = pipe
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.input = input
+	a.mu.Unlock()
+
+	return func() {
+		a.mu.Lock()
+		a.input = nil
+		a.mu.Unlock()
+	}, nil
+}
+
+// output returns a writer that writes to the log stream, and queues the output to the corresponding stream of the
+// attached clients. The writes never block on the clients; those whose queue is full are disconnected.
+func (a *attachPoint) output(frameType control.FrameType, logStream io.Writer) io.Writer {
+	return writerFunc(func(p []byte) (int, error) {
+		a.mu.Lock()
+		clients := make([]*attachClient, 0, len(a.clients))
+		for client := range a.clients {
+			clients = append(clients, client)
+		}
+		a.mu.Unlock()
+
+		for _, client := range clients {
+			stream := client.stdout
+			if frameType == control.FrameStderr {
+				stream = client.stderr
+			}
+
+			if stream == nil {
+				continue
+			}
+
+			// the buffer is reused by the caller once the write returns.
+			select {
+			case client.output <- attachOutput{stream: stream, data: append([]byte(nil), p...)}:
+			default:
+				client.drop()
+			}
+		}
+
+		return logStream.Write(p)
+	})
+}
+
+// exited notifies the attached clients that the running instance of the container has exited.
+func (a *attachPoint) exited(code int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for client := range a.clients {
+		select {
+		case client.exit <- code:
+		default:
+		}
+	}
+}
+
+func (a *attachPoint) writeInput(p []byte) {
+	a.mu.Lock()
+	input := a.input
+	a.mu.Unlock()
+
+	if input != nil {
+		_, _ = input.Write(p)
+	}
+}
+
+// closeInput closes the stdin of the container, as the end of input of the first attached client for
+// stdinOnce containers. Closing the pty of tty containers would also end their output, so an EOT is sent.
+func (a *attachPoint) closeInput() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.input == nil {
+		return
+	}
+
+	if a.tty {
+		_, _ = a.input.Write([]byte{4})
+	} else {
+		_ = a.input.Close()
+	}
+
+	a.input = nil
+}
+
+func (a *attachPoint) resize(size control.TerminalSize) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.size = &pty.Winsize{Rows: size.Height, Cols: size.Width}
+
+	if a.ptmx != nil {
+		if err := pty.Setsize(a.ptmx, a.size); err != nil {
+			log.Warn().Err(err).Msg("Failed to resize terminal")
+		}
+	}
+}
+
+// attachToContainer connects the session to the streams of a running container, until either the client
+// detaches or the container exits.
+func attachToContainer(session *control.Conn, req control.Request) control.ExitStatus {
+	point := attachPoints.lookup(req.Container)
+	if point == nil {
+		return control.ExitStatus{Error: fmt.Sprintf("container %s is not running", req.Container)}
+	}
+
+	if req.Stdin && !point.stdin {
+		return control.ExitStatus{Error: fmt.Sprintf("container %s does not accept stdin", req.Container)}
+	}
+
+	client := newAttachClient()
+
+	if req.Stdout {
+		client.stdout = session.Writer(control.FrameStdout)
+	}
+
+	if req.Stderr && !point.tty {
+		client.stderr = session.Writer(control.FrameStderr)
+	}
+
+	point.mu.Lock()
+	point.clients[client] = struct{}{}
+	point.mu.Unlock()
+
+	defer func() {
+		point.mu.Lock()
+		delete(point.clients, client)
+		point.mu.Unlock()
+	}()
+
+	log.Info().Msgf("Client attached to container %s", req.Container)
+
+	/*-- Forward the input of the client, until it detaches --*/
+	detached := make(chan struct{})
+
+	go func() {
+		defer close(detached)
+
+		for {
+			frameType, payload, err := session.ReadFrame()
+			if err != nil {
+				if req.Stdin && point.stdinOnce {
+					point.closeInput()
+				}
+
+				return
+			}
+
+			switch frameType {
+			case control.FrameStdin:
+				if req.Stdin {
+					point.writeInput(payload)
+				}
+
+			case control.FrameStdinClose:
+				if req.Stdin && point.stdinOnce {
+					point.closeInput()
+				}
+
+			case control.FrameResize:
+				size, err := control.DecodeResize(payload)
+				if err != nil {
+					log.Warn().Err(err).Msg("Invalid resize frame")
+					continue
+				}
+
+				point.resize(size)
+			}
+		}
+	}()
+
+	/*-- Write the output of the container to the client, until either side goes away --*/
+	for {
+		select {
+		case out := <-client.output:
+			client.write(out)
+
+		case code := <-client.exit:
+			// the output of the instance is queued before its exit is notified.
+			client.flush()
+
+			return control.ExitStatus{Code: code}
+
+		case <-client.dropped:
+			log.Warn().Msgf("Client of container %s cannot keep up with its output and is disconnected", req.Container)
+
+			return control.ExitStatus{Error: fmt.Sprintf("client cannot keep up with the output of container %s", req.Container)}
+
+		case <-detached:
+			log.Info().Msgf("Client detached from container %s", req.Container)
+
+			return control.ExitStatus{}
+		}
+	}
+}
+
+// writerFunc adapts a function to an io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
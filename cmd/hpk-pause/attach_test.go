@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"hpk/internal/compute/control"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestAttachToContainer(t *testing.T) {
+	point := attachPoints.get(&v1.Container{Name: "attach-test", Stdin: true, StdinOnce: true})
+
+	var logOut, logErr bytes.Buffer
+
+	cmd := exec.Command("cat")
+
+	finishStreams, err := point.start(cmd, &logOut, &logErr)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	go func() {
+		_ = cmd.Wait()
+
+		finishStreams()
+		point.exited(exitCode(cmd.ProcessState))
+	}()
+
+	/*-- Serve the attach session on one end of a pipe --*/
+	serverConn, clientConn := net.Pipe()
+
+	go handleControlSession(nil, control.NewConn(serverConn))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stdout bytes.Buffer
+
+	// the end of input closes the stdin of the container, which then exits.
+	err = control.NewConn(clientConn).Run(ctx, control.Request{
+		Operation: control.OperationAttach,
+		Container: "attach-test",
+		Stdin:     true,
+		Stdout:    true,
+	}, control.Streams{
+		Stdin:  strings.NewReader("hello\n"),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatalf("attach: %v", err)
+	}
+
+	if stdout.String() != "hello\n" {
+		t.Errorf("unexpected attached output %q", stdout.String())
+	}
+
+	if logOut.String() != "hello\n" {
+		t.Errorf("unexpected logged output %q", logOut.String())
+	}
+}
+
+func TestAttachToMissingContainer(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+
+	go handleControlSession(nil, control.NewConn(serverConn))
+
+	err := control.NewConn(clientConn).Run(context.Background(), control.Request{
+		Operation: control.OperationAttach,
+		Container: "missing",
+		Stdout:    true,
+	}, control.Streams{})
+	if err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("expected error for missing container, got %v", err)
+	}
+}
+
+func TestAttachOutputDropsSlowClient(t *testing.T) {
+	point := attachPoints.get(&v1.Container{Name: "slow-test"})
+
+	// the client never drains its output, as if its connection were stalled.
+	client := newAttachClient()
+	client.stdout = &bytes.Buffer{}
+
+	point.mu.Lock()
+	point.clients[client] = struct{}{}
+	point.mu.Unlock()
+
+	var logOut bytes.Buffer
+
+	output := point.output(control.FrameStdout, &logOut)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for i := 0; i <= attachClientBuffer; i++ {
+			_, _ = output.Write([]byte("x"))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the output is blocked by the client")
+	}
+
+	select {
+	case <-client.dropped:
+	default:
+		t.Error("expected the slow client to be dropped")
+	}
+
+	if logOut.Len() != attachClientBuffer+1 {
+		t.Errorf("got %d bytes in the log, want %d", logOut.Len(), attachClientBuffer+1)
+	}
+}
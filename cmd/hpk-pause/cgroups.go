@@ -0,0 +1,139 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
+	v1 "k8s.io/api/core/v1"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// delegatedControllers returns the cgroup controllers that the pause container is allowed to manage.
+// Apptainer can only enforce resource limits through controllers that are delegated to the user.
+var delegatedControllers = sync.OnceValue(func() map[string]bool {
+	controllers := detectControllers("/proc/self/cgroup")
+
+	if len(controllers) == 0 {
+		log.Warn().Msg("cgroup delegation is not available. Resource limits of containers will not be enforced")
+	}
+
+	return controllers
+})
+
+// detectControllers parses the cgroup membership of the process, and returns the controllers
+// whose cgroup directories are writable.
+func detectControllers(procCgroupPath string) map[string]bool {
+	f, err := os.Open(procCgroupPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	controllers := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// format: hierarchy-ID:controller-list:cgroup-path
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if fields[0] == "0" && fields[1] == "" {
+			/*-- cgroup v2: the available controllers are listed in the cgroup directory --*/
+			dir := filepath.Join(cgroupRoot, fields[2])
+			if unix.Access(dir, unix.W_OK) != nil {
+				continue
+			}
+
+			available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+			if err != nil {
+				continue
+			}
+
+			for _, controller := range strings.Fields(string(available)) {
+				controllers[controller] = true
+			}
+
+			continue
+		}
+
+		/*-- cgroup v1: every controller has its own hierarchy --*/
+		for _, controller := range strings.Split(fields[1], ",") {
+			if unix.Access(filepath.Join(cgroupRoot, controller, fields[2]), unix.W_OK) == nil {
+				controllers[controller] = true
+			}
+		}
+	}
+
+	return controllers
+}
+
+// resourceArgs translates the resource requirements of a container to the cgroup flags of apptainer.
+// Limits that cannot be enforced with the given controllers are dropped with a warning.
+func resourceArgs(container *v1.Container, controllers map[string]bool) []string {
+	var args []string
+
+	dropped := func(resource string, controller string) {
+		log.Warn().Msgf("Ignore %s of container %s, as the '%s' cgroup controller is not delegated", resource, container.Name, controller)
+	}
+
+	/*---------------------------------------------------
+	 * CPU
+	 *---------------------------------------------------*/
+	if limit, ok := container.Resources.Limits[v1.ResourceCPU]; ok && !limit.IsZero() {
+		if controllers["cpu"] {
+			args = append(args, "--cpus", strconv.FormatFloat(float64(limit.MilliValue())/1000, 'f', -1, 64))
+		} else {
+			dropped("limits.cpu", "cpu")
+		}
+	}
+
+	if request, ok := container.Resources.Requests[v1.ResourceCPU]; ok && !request.IsZero() && controllers["cpu"] {
+		// the same conversion as the kubelet's MilliCPUToShares.
+		shares := max(request.MilliValue()*1024/1000, 2)
+
+		args = append(args, "--cpu-shares", strconv.FormatInt(shares, 10))
+	}
+
+	/*---------------------------------------------------
+	 * Memory
+	 *---------------------------------------------------*/
+	if limit, ok := container.Resources.Limits[v1.ResourceMemory]; ok && !limit.IsZero() {
+		if controllers["memory"] {
+			bytes := strconv.FormatInt(limit.Value(), 10)
+
+			// swap is disabled, as in the kubelet, by setting memory+swap equal to the memory limit.
+			args = append(args, "--memory", bytes, "--memory-swap", bytes)
+		} else {
+			dropped("limits.memory", "memory")
+		}
+	}
+
+	if request, ok := container.Resources.Requests[v1.ResourceMemory]; ok && !request.IsZero() && controllers["memory"] {
+		args = append(args, "--memory-reservation", strconv.FormatInt(request.Value(), 10))
+	}
+
+	return args
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestResourceArgs(t *testing.T) {
+	container := &v1.Container{
+		Name: "test-container",
+		Resources: v1.ResourceRequirements{
+			Limits: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1500m"),
+				v1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("250m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+	}
+
+	got := resourceArgs(container, map[string]bool{"cpu": true, "memory": true})
+	want := []string{
+		"--cpus", "1.5",
+		"--cpu-shares", "256",
+		"--memory", "268435456", "--memory-swap", "268435456",
+		"--memory-reservation", "134217728",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got args %v, want %v", got, want)
+	}
+
+	/*-- Without delegation, the limits are dropped --*/
+	if got := resourceArgs(container, nil); len(got) != 0 {
+		t.Errorf("expected no args without delegated controllers, got %v", got)
+	}
+
+	/*-- Partial delegation --*/
+	got = resourceArgs(container, map[string]bool{"memory": true})
+	want = []string{"--memory", "268435456", "--memory-swap", "268435456", "--memory-reservation", "134217728"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got args %v, want %v", got, want)
+	}
+}
+
+func TestDetectControllersWithoutCgroups(t *testing.T) {
+	procCgroup := filepath.Join(t.TempDir(), "cgroup")
+
+	if err := os.WriteFile(procCgroup, []byte("0::/does/not/exist\n"), 0644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if controllers := detectControllers(procCgroup); len(controllers) != 0 {
+		t.Errorf("expected no controllers, got %v", controllers)
+	}
+
+	if controllers := detectControllers(filepath.Join(t.TempDir(), "missing")); controllers != nil {
+		t.Errorf("expected nil controllers, got %v", controllers)
+	}
+}
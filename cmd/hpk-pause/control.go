@@ -0,0 +1,283 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"hpk/internal/compute/control"
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/podhandler"
+
+	"github.com/creack/pty"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// serveControlSocket accepts sessions on the control socket of the pod, through which hpk-kubelet runs commands
+// in the containers (i.e, `kubectl exec`) and attaches to them (i.e, `kubectl attach`), until the context is cancelled.
+func serveControlSocket(ctx context.Context, pod *v1.Pod) {
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+	socketPath := hpk.Pod(client.ObjectKeyFromObject(pod)).ControlSocketPath()
+
+	listener, err := control.Listen(socketPath)
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed to create control socket %s", socketPath)
+		return
+	}
+
+	context.AfterFunc(ctx, func() { _ = listener.Close() })
+
+	log.Info().Msgf("Serving control socket %s", socketPath)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Error().Err(err).Msg("Failed to accept control session")
+
+			continue
+		}
+
+		go handleControlSession(pod, control.NewConn(conn))
+	}
+}
+
+// handleControlSession serves a single session, and reports how the requested operation has ended.
+func handleControlSession(pod *v1.Pod, session *control.Conn) {
+	defer session.Close()
+
+	req, err := session.ReadRequest()
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid control session")
+		return
+	}
+
+	var status control.ExitStatus
+
+	switch req.Operation {
+	case control.OperationExec:
+		status = execInContainer(pod, session, req)
+	case control.OperationAttach:
+		status = attachToContainer(session, req)
+	default:
+		status = control.ExitStatus{Error: fmt.Sprintf("unsupported operation '%s'", req.Operation)}
+	}
+
+	if err := session.WriteJSON(control.FrameExit, status); err != nil {
+		log.Warn().Err(err).Msgf("Failed to report the exit status of '%s' in container %s", req.Operation, req.Container)
+	}
+}
+
+// execInContainer runs the command of the request within the running container, i.e, in its namespaces,
+// root filesystem and environment, and streams its input and output through the session.
+func execInContainer(pod *v1.Pod, session *control.Conn, req control.Request) control.ExitStatus {
+	container := findContainer(pod, req.Container)
+	if container == nil {
+		return control.ExitStatus{Error: fmt.Sprintf("container %s is not found in pod %s", req.Container, pod.Name)}
+	}
+
+	if len(req.Command) == 0 {
+		return control.ExitStatus{Error: "no command was specified"}
+	}
+
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+
+	pid, err := runningContainerPID(hpk.Pod(client.ObjectKeyFromObject(pod)).Container(container.Name))
+	if err != nil {
+		return control.ExitStatus{Error: fmt.Sprintf("container %s: %v", container.Name, err)}
+	}
+
+	cmd, err := enterContainer(context.Background(), pid, req.Command)
+	if err != nil {
+		return control.ExitStatus{Error: fmt.Sprintf("container %s: %v", container.Name, err)}
+	}
+
+	log.Info().Msgf("Executing %v in container %s", req.Command, container.Name)
+
+	var (
+		stdin io.WriteCloser
+		ptmx  *os.File
+	)
+
+	// outputDone is closed once the output of a TTY session has been copied.
+	outputDone := make(chan struct{})
+
+	if req.TTY {
+		// the command becomes the leader of a new session, with the pty as its controlling terminal.
+		ptmx, err = pty.Start(cmd)
+		if err != nil {
+			return control.ExitStatus{Error: fmt.Sprintf("failed to start command: %v", err)}
+		}
+		defer ptmx.Close()
+
+		stdin = ptmx
+
+		go func() {
+			defer close(outputDone)
+
+			output := io.Discard
+			if req.Stdout {
+				output = session.Writer(control.FrameStdout)
+			}
+
+			// the copy ends with EIO, once the command and its children have closed the terminal.
+			_, _ = io.Copy(output, ptmx)
+		}()
+	} else {
+		close(outputDone)
+
+		if req.Stdin {
+			if stdin, err = cmd.StdinPipe(); err != nil {
+				return control.ExitStatus{Error: err.Error()}
+			}
+		}
+
+		if req.Stdout {
+			cmd.Stdout = session.Writer(control.FrameStdout)
+		}
+
+		if req.Stderr {
+			cmd.Stderr = session.Writer(control.FrameStderr)
+		}
+
+		// place the command in its own process group, so that it can be killed along with its children.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.WaitDelay = logDrainTimeout
+
+		if err := cmd.Start(); err != nil {
+			return control.ExitStatus{Error: fmt.Sprintf("failed to start command: %v", err)}
+		}
+	}
+
+	exited := make(chan struct{})
+
+	go forwardInput(session, stdin, ptmx, cmd.Process.Pid, exited)
+
+	if err := cmd.Wait(); err != nil {
+		log.Debug().Err(err).Msgf("Command %v in container %s has failed", req.Command, container.Name)
+	}
+
+	close(exited)
+
+	select {
+	case <-outputDone:
+	case <-time.After(logDrainTimeout):
+	}
+
+	return control.ExitStatus{Code: exitCode(cmd.ProcessState)}
+}
+
+// forwardInput applies the input and resize frames of the session to the command. If the client goes away
+// while the command is still running, the command is killed.
+func forwardInput(session *control.Conn, stdin io.WriteCloser, ptmx *os.File, pid int, exited <-chan struct{}) {
+	for {
+		frameType, payload, err := session.ReadFrame()
+		if err != nil {
+			select {
+			case <-exited:
+			default:
+				log.Info().Msgf("Control session has been closed. Killing process %d", pid)
+
+				_ = syscall.Kill(-pid, syscall.SIGKILL)
+			}
+
+			return
+		}
+
+		switch frameType {
+		case control.FrameStdin:
+			if stdin != nil {
+				_, _ = stdin.Write(payload)
+			}
+
+		case control.FrameStdinClose:
+			// closing the pty would also end the output, so the end of input is signalled only for pipes.
+			if stdin != nil && ptmx == nil {
+				_ = stdin.Close()
+			}
+
+		case control.FrameResize:
+			size, err := control.DecodeResize(payload)
+			if err != nil {
+				log.Warn().Err(err).Msg("Invalid resize frame")
+				continue
+			}
+
+			if ptmx != nil {
+				if err := pty.Setsize(ptmx, &pty.Winsize{Rows: size.Height, Cols: size.Width}); err != nil {
+					log.Warn().Err(err).Msg("Failed to resize terminal")
+				}
+			}
+
+		default:
+			log.Warn().Msgf("Unexpected frame type %d in control session", frameType)
+		}
+	}
+}
+
+// findContainer returns the container, init container, or ephemeral container of the pod with the given name.
+// Ephemeral containers are added after hpk-pause has started, so they are looked up in the pod definition that
+// hpk-kubelet keeps up to date in the job directory.
+func findContainer(pod *v1.Pod, name string) *v1.Container {
+	if container := lookupContainer(pod, name); container != nil {
+		return container
+	}
+
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+
+	updated, err := podhandler.LoadPodFromFile(hpk.Pod(client.ObjectKeyFromObject(pod)).EncodedJSONPath())
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to reload pod definition")
+		return nil
+	}
+
+	return lookupContainer(updated, name)
+}
+
+func lookupContainer(pod *v1.Pod, name string) *v1.Container {
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == name {
+			return &pod.Spec.Containers[i]
+		}
+	}
+
+	for i := range pod.Spec.InitContainers {
+		if pod.Spec.InitContainers[i].Name == name {
+			return &pod.Spec.InitContainers[i]
+		}
+	}
+
+	for i := range pod.Spec.EphemeralContainers {
+		if pod.Spec.EphemeralContainers[i].Name == name {
+			container := v1.Container(pod.Spec.EphemeralContainers[i].EphemeralContainerCommon)
+
+			return &container
+		}
+	}
+
+	return nil
+}
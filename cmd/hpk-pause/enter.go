@@ -0,0 +1,156 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/slurm"
+	"hpk/internal/compute/usage"
+)
+
+// namespaces are the namespaces that a command joins when entering a container, along with the flags of nsenter.
+var namespaces = []struct {
+	name string
+	flag string
+}{
+	// the user namespace comes first, as it grants the privileges for joining the rest.
+	{"user", "--user"},
+	{"mnt", "--mount"},
+	{"uts", "--uts"},
+	{"ipc", "--ipc"},
+	{"net", "--net"},
+	{"pid", "--pid"},
+	{"cgroup", "--cgroup"},
+}
+
+// runningContainerPID returns the pid of the apptainer process of the running instance of a container,
+// as recorded in the id file of the container.
+func runningContainerPID(containerPath endpoint.ContainerPath) (int, error) {
+	id, err := slurm.GetPIDFromFile(containerPath.IDPath())
+	if err != nil {
+		return 0, fmt.Errorf("container is not started: %w", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimPrefix(id, string(slurm.JobIDTypeProcess)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid container id '%s': %w", id, err)
+	}
+
+	// the pid file outlives the container, which has terminated once its exit code is recorded.
+	if _, err := os.Stat(containerPath.ExitCodePath()); err == nil {
+		return 0, fmt.Errorf("container has terminated")
+	}
+
+	if err := syscall.Kill(pid, 0); err != nil {
+		return 0, fmt.Errorf("container is not running: %w", err)
+	}
+
+	return pid, nil
+}
+
+// enterContainer returns a command that runs within the running container of the apptainer process, i.e, in its
+// namespaces, root filesystem, working directory and environment. The command therefore sees the processes, the
+// writable overlay and the mounts of the container, rather than a fresh instance of the image.
+func enterContainer(ctx context.Context, pid int, command []string) (*exec.Cmd, error) {
+	target, err := containerProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"--target", strconv.Itoa(target)}
+
+	for _, ns := range namespaces {
+		self, err := os.Readlink("/proc/self/ns/" + ns.name)
+		if err != nil {
+			// the namespace is not supported by the kernel.
+			continue
+		}
+
+		theirs, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/%s", target, ns.name))
+		if err != nil {
+			return nil, fmt.Errorf("cannot inspect the namespaces of process %d: %w", target, err)
+		}
+
+		// joining the current namespace is not permitted for user namespaces, and useless for the rest.
+		if self == theirs {
+			continue
+		}
+
+		args = append(args, ns.flag)
+
+		if ns.name == "user" {
+			// keep the identity of the user, as the container does.
+			args = append(args, "--preserve-credentials")
+		}
+	}
+
+	args = append(args, "--root", "--wd", "--")
+	args = append(args, command...)
+
+	environ, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", target))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the environment of process %d: %w", target, err)
+	}
+
+	cmd := exec.CommandContext(ctx, "nsenter", args...)
+	cmd.Env = splitEnviron(environ)
+
+	return cmd, nil
+}
+
+// containerProcess returns the process that runs in the mount namespace of the container, which is created by
+// apptainer. This is either pid itself, or its first descendant outside the mount namespace of hpk-pause.
+func containerProcess(pid int) (int, error) {
+	self, err := os.Readlink("/proc/self/ns/mnt")
+	if err != nil {
+		return 0, err
+	}
+
+	tree, err := usage.ProcessTree(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range tree {
+		if ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/mnt", p)); err == nil && ns != self {
+			return p, nil
+		}
+	}
+
+	// the container runs without a mount namespace of its own.
+	return pid, nil
+}
+
+// splitEnviron decodes the NUL-separated variables of /proc/<pid>/environ.
+func splitEnviron(environ []byte) []string {
+	var env []string
+
+	for _, variable := range bytes.Split(environ, []byte{0}) {
+		if len(variable) > 0 {
+			env = append(env, string(variable))
+		}
+	}
+
+	return env
+}
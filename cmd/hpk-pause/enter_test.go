@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hpk/internal/compute/control"
+	"hpk/internal/compute/endpoint"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestExecInRunningContainer(t *testing.T) {
+	workingDir := t.TempDir()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "exec-test",
+			Annotations: map[string]string{"workingDirectory": workingDir},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	}
+
+	/*-- The container creates a file on a mount that only exists in its own namespaces --*/
+	scratch := filepath.Join(workingDir, "scratch")
+	containerPath := endpoint.HPK(workingDir).Pod(client.ObjectKeyFromObject(pod)).Container("app")
+
+	startNamespacedContainer(t, containerPath, scratch)
+
+	/*-- The command sees the file of the container --*/
+	serverConn, clientConn := net.Pipe()
+
+	go handleControlSession(pod, control.NewConn(serverConn))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var output bytes.Buffer
+
+	err := control.NewConn(clientConn).Run(ctx, control.Request{
+		Operation: control.OperationExec,
+		Container: "app",
+		Command:   []string{"cat", filepath.Join(scratch, "file")},
+		Stdout:    true,
+		Stderr:    true,
+	}, control.Streams{
+		Stdout: &output,
+		Stderr: &output,
+	})
+	if err != nil {
+		t.Fatalf("exec: %v (%s)", err, output.String())
+	}
+
+	if output.String() != "hello\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+
+	/*-- Terminated containers cannot be entered --*/
+	if err := os.WriteFile(containerPath.ExitCodePath(), []byte("0"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runningContainerPID(containerPath); err == nil {
+		t.Error("expected an error for a terminated container")
+	}
+}
+
+// startNamespacedContainer starts a process that mounts a tmpfs on scratch within its own user and mount
+// namespaces, writes "hello" to scratch/file, and records its pid as the id of the container.
+func startNamespacedContainer(t *testing.T, containerPath endpoint.ContainerPath, scratch string) {
+	t.Helper()
+
+	if err := os.Mkdir(scratch, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	container := exec.Command("unshare", "--user", "--map-root-user", "--mount", "sh", "-c",
+		fmt.Sprintf("mount -t tmpfs tmpfs %[1]s && echo hello > %[1]s/file && echo ready && exec sleep 60", scratch))
+
+	stdout, err := container.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := container.Start(); err != nil {
+		t.Skipf("cannot create namespaces: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = container.Process.Kill()
+		_ = container.Wait()
+	})
+
+	if line, _ := bufio.NewReader(stdout).ReadString('\n'); line != "ready\n" {
+		t.Skip("cannot create namespaces")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(containerPath.IDPath()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(containerPath.IDPath(), []byte(fmt.Sprintf("pid://%d", container.Process.Pid)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindEphemeralContainer(t *testing.T) {
+	workingDir := t.TempDir()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "debug-test",
+			Annotations: map[string]string{"workingDirectory": workingDir},
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+	}
+
+	if findContainer(pod, "debugger") != nil {
+		t.Fatal("found a container that does not exist")
+	}
+
+	/*-- kubectl debug adds the ephemeral container to the definition of the running pod --*/
+	updated := pod.DeepCopy()
+	updated.Spec.EphemeralContainers = []v1.EphemeralContainer{{
+		EphemeralContainerCommon: v1.EphemeralContainerCommon{Name: "debugger", Image: "busybox"},
+	}}
+
+	encoded, err := json.Marshal(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podPath := endpoint.HPK(workingDir).Pod(client.ObjectKeyFromObject(pod))
+
+	if err := os.MkdirAll(podPath.JobDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(podPath.EncodedJSONPath(), encoded, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if container := findContainer(pod, "debugger"); container == nil || container.Image != "busybox" {
+		t.Errorf("got %v, want the ephemeral container", container)
+	}
+}
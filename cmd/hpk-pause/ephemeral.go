@@ -0,0 +1,143 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/image"
+	"hpk/internal/compute/podhandler"
+	kubecontainer "hpk/pkg/container"
+	"hpk/pkg/filenotify"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ephemeralPollInterval is how often the pod definition is checked for new ephemeral containers.
+const ephemeralPollInterval = 5 * time.Second
+
+// watchEphemeralContainers watches the pod definition for ephemeral containers that are added by `kubectl debug`,
+// and launches them alongside the running containers, until the context is cancelled. hpk-kubelet rewrites the
+// definition in the job directory whenever the pod is updated, so the API server is not needed.
+func watchEphemeralContainers(ctx context.Context, podSpecPath string, pod *v1.Pod, hpkEnv bool) {
+	launched := make(map[string]bool)
+
+	// ephemeral containers that were present at startup.
+	launchEphemeralContainers(pod, launched, hpkEnv)
+
+	// events of shared filesystems are not reliable, so the definition is polled.
+	watcher := filenotify.NewPollingWatcher(ephemeralPollInterval)
+	defer watcher.Close()
+
+	if err := watcher.Add(podSpecPath); err != nil {
+		log.Error().Err(err).Msgf("Cannot watch pod definition %s for ephemeral containers", podSpecPath)
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events():
+			if !ok {
+				return
+			}
+
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+				continue
+			}
+
+			updated, err := podhandler.LoadPodFromFile(podSpecPath)
+			if err != nil {
+				// the file may be partially written. It will be read again on the next change.
+				log.Warn().Err(err).Msg("Failed to reload pod definition")
+				continue
+			}
+
+			launchEphemeralContainers(updated, launched, hpkEnv)
+
+		case err, ok := <-watcher.Errors():
+			if !ok {
+				return
+			}
+
+			log.Warn().Err(err).Msg("Error watching pod definition for ephemeral containers")
+		}
+	}
+}
+
+// launchEphemeralContainers starts the ephemeral containers that have not been launched yet.
+func launchEphemeralContainers(pod *v1.Pod, launched map[string]bool, hpkEnv bool) {
+	for _, ephemeral := range pod.Spec.EphemeralContainers {
+		if launched[ephemeral.Name] {
+			continue
+		}
+
+		launched[ephemeral.Name] = true
+
+		// ephemeral containers share the same fields as regular containers.
+		container := v1.Container(ephemeral.EphemeralContainerCommon)
+
+		go func() {
+			if err := runEphemeralContainer(pod, &container, hpkEnv); err != nil {
+				log.Error().Err(err).Msgf("Failed to run ephemeral container %s", container.Name)
+			}
+		}()
+	}
+}
+
+// runEphemeralContainer pulls the image of the ephemeral container, and runs the container
+// with the same binds, DNS files and network namespace as the rest of the containers.
+func runEphemeralContainer(pod *v1.Pod, container *v1.Container, hpkEnv bool) error {
+	log.Info().Msgf("Spawning ephemeral container: %s", container.Name)
+
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+
+	if _, err := image.Pull(hpk.ImageDir(), image.Docker, container.Image); err != nil {
+		return fmt.Errorf("failed to pull image '%s': %w", container.Image, err)
+	}
+
+	/*-- hpk-kubelet does not generate env files for ephemeral containers, so we write the static values --*/
+	if len(container.Env) > 0 {
+		envFile, skipped := podhandler.EncodeEnvFile(container.Env)
+		if len(skipped) > 0 {
+			log.Warn().Msgf("Skipping env variables that cannot be set by a shell: %v", skipped)
+		}
+
+		if err := os.WriteFile(scratchEnvFilePath(pod, container), envFile, 0600); err != nil {
+			return fmt.Errorf("error writing env file: %v", err)
+		}
+	}
+
+	apptainerArgs, err := containerArgs(pod, container, executionMode(container), hpkEnv)
+	if err != nil {
+		return err
+	}
+
+	command, args := kubecontainer.ExpandContainerCommandAndArgs(container, container.Env)
+	apptainerArgs = append(apptainerArgs, command...)
+	apptainerArgs = append(apptainerArgs, args...)
+
+	runContainer(pod, container, apptainerArgs, nil)
+
+	return nil
+}
@@ -0,0 +1,258 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"hpk/internal/compute/podhandler"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+)
+
+// minimumGracePeriod is the time that a container is given to handle SIGTERM, even if its preStop
+// hook has consumed the entire grace period. This is the same as in the kubelet.
+const minimumGracePeriod = 2 * time.Second
+
+// supervisor keeps track of the main containers that are currently running,
+// so that they can be gracefully terminated when the pause container is stopped.
+var supervisor = &containerSupervisor{
+	running: make(map[string]*runningContainer),
+}
+
+type containerSupervisor struct {
+	mu sync.Mutex
+
+	// terminating is set once the pod is being stopped. Terminated containers are not restarted afterwards.
+	terminating bool
+
+	running map[string]*runningContainer
+
+	// sidecars tracks the sidecar containers, which run until the main containers have terminated.
+	sidecars sync.WaitGroup
+}
+
+// runningContainer describes the current incarnation of a main container.
+type runningContainer struct {
+	prober *prober
+
+	// pid is the process (and process group) id of the apptainer process.
+	pid int
+
+	// exited is closed when the apptainer process has exited.
+	exited <-chan struct{}
+}
+
+// register records the current incarnation of a container. It returns false if the pod is terminating,
+// in which case the container must be stopped immediately.
+func (s *containerSupervisor) register(name string, c *runningContainer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.running[name] = c
+
+	return !s.terminating
+}
+
+func (s *containerSupervisor) unregister(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.running, name)
+}
+
+func (s *containerSupervisor) isTerminating() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.terminating
+}
+
+// terminate stops all the running main containers in parallel, by running their preStop hooks,
+// sending SIGTERM to their process groups, and finally SIGKILL once the grace period has expired.
+func (s *containerSupervisor) terminate(pod *v1.Pod) {
+	s.stop(pod, false)
+}
+
+// terminateSidecars stops all the running sidecar containers, in the same way as the main containers, but one
+// at a time and in the reverse order of their declaration, as the kubelet does.
+func (s *containerSupervisor) terminateSidecars(pod *v1.Pod) {
+	s.stop(pod, true)
+}
+
+func (s *containerSupervisor) stop(pod *v1.Pod, sidecars bool) {
+	s.mu.Lock()
+	s.terminating = true
+
+	running := make([]*runningContainer, 0, len(s.running))
+	for _, c := range s.running {
+		if podhandler.IsSidecar(c.prober.container) == sidecars {
+			running = append(running, c)
+		}
+	}
+	s.mu.Unlock()
+
+	gracePeriod := terminationGracePeriod(pod)
+
+	if sidecars {
+		// the sidecars that are declared later may depend on the earlier ones, so they are stopped first.
+		sort.Slice(running, func(i, j int) bool {
+			return initContainerIndex(pod, running[i].prober.container.Name) > initContainerIndex(pod, running[j].prober.container.Name)
+		})
+
+		deadline := time.Now().Add(gracePeriod)
+
+		for _, c := range running {
+			stopContainer(c, time.Until(deadline))
+		}
+
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for _, c := range running {
+		wg.Add(1)
+
+		go func(c *runningContainer) {
+			defer wg.Done()
+
+			stopContainer(c, gracePeriod)
+		}(c)
+	}
+
+	wg.Wait()
+}
+
+// initContainerIndex returns the position of the init container in the spec of the pod, or -1 if it is not found.
+func initContainerIndex(pod *v1.Pod, name string) int {
+	for i, container := range pod.Spec.InitContainers {
+		if container.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// terminationGracePeriod returns the time given to the containers of the pod to terminate gracefully.
+func terminationGracePeriod(pod *v1.Pod) time.Duration {
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+
+	return defaultTerminationGracePeriod
+}
+
+// stopContainer runs the preStop hook of the container, and then kills the container
+// within the remaining of the grace period.
+func stopContainer(c *runningContainer, gracePeriod time.Duration) {
+	container := c.prober.container
+	deadline := time.Now().Add(gracePeriod)
+
+	if container.Lifecycle != nil && container.Lifecycle.PreStop != nil {
+		log.Info().Msgf("Running preStop hook of container %s", container.Name)
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+
+		if err := runHook(ctx, c.prober, container.Lifecycle.PreStop); err != nil {
+			log.Error().Err(err).Msgf("PreStop hook of container %s has failed", container.Name)
+		}
+
+		cancel()
+	}
+
+	remaining := time.Until(deadline)
+	if remaining < minimumGracePeriod {
+		remaining = minimumGracePeriod
+	}
+
+	log.Info().Msgf("Stopping container %s with grace period %v", container.Name, remaining)
+
+	killContainer(c.pid, remaining, c.exited)
+}
+
+// killContainer sends SIGTERM to the process group of a container, and if the container has not exited
+// within the grace period, it follows up with SIGKILL.
+func killContainer(pid int, gracePeriod time.Duration, exited <-chan struct{}) {
+	if err := syscall.Kill(-pid, syscall.SIGTERM); err != nil {
+		log.Error().Err(err).Msgf("Failed to send SIGTERM to process group %d", pid)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(gracePeriod):
+		if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+			log.Error().Err(err).Msgf("Failed to send SIGKILL to process group %d", pid)
+		}
+	}
+}
+
+// runHook executes a postStart or preStop handler of a container.
+func runHook(ctx context.Context, p *prober, handler *v1.LifecycleHandler) error {
+	switch {
+	case handler.Exec != nil:
+		return p.execProbe(ctx, handler.Exec)
+	case handler.HTTPGet != nil:
+		return p.httpProbe(ctx, handler.HTTPGet)
+	case handler.TCPSocket != nil:
+		return p.tcpProbe(ctx, handler.TCPSocket)
+	case handler.Sleep != nil:
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(handler.Sleep.Seconds) * time.Second):
+			return nil
+		}
+	default:
+		return fmt.Errorf("lifecycle hook of container %s has no handler", p.container.Name)
+	}
+}
+
+// restartBackoff returns the delay before restarting a container, which doubles
+// on every restart up to a limit, as the CrashLoopBackOff of the kubelet.
+func restartBackoff(restartCount int) time.Duration {
+	const (
+		initialBackoff = 10 * time.Second
+		maxBackoff     = 5 * time.Minute
+	)
+
+	if restartCount >= 5 {
+		return maxBackoff
+	}
+
+	return min(initialBackoff<<restartCount, maxBackoff)
+}
+
+// exitCode returns the exit code of a process, following the shell convention of 128+signal
+// for processes that were terminated by a signal (e.g, 143 for SIGTERM, 137 for SIGKILL).
+func exitCode(state *os.ProcessState) int {
+	if state == nil {
+		return -1
+	}
+
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	return state.ExitCode()
+}
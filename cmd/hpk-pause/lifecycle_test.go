@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestExitCodeOfSignaledProcess(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 3")
+	_ = cmd.Run()
+
+	if code := exitCode(cmd.ProcessState); code != 3 {
+		t.Errorf("got exit code %d, want 3", code)
+	}
+
+	cmd = exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	_ = cmd.Process.Signal(syscall.SIGTERM)
+	_ = cmd.Wait()
+
+	if code := exitCode(cmd.ProcessState); code != 143 {
+		t.Errorf("got exit code %d, want 143", code)
+	}
+}
+
+func TestKillContainerEscalatesToSIGKILL(t *testing.T) {
+	// the shell ignores SIGTERM, so it must be killed after the grace period.
+	cmd := exec.Command("sh", "-c", "trap '' TERM; sleep 10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+
+	// give the shell the time to install the trap.
+	time.Sleep(100 * time.Millisecond)
+
+	killContainer(cmd.Process.Pid, 200*time.Millisecond, exited)
+	<-exited
+
+	if code := exitCode(cmd.ProcessState); code != 137 {
+		t.Errorf("got exit code %d, want 137", code)
+	}
+}
+
+func TestSleepHook(t *testing.T) {
+	p := &prober{pod: &v1.Pod{}, container: &v1.Container{Name: "test-container"}}
+
+	hook := &v1.LifecycleHandler{Sleep: &v1.SleepAction{Seconds: 10}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := runHook(ctx, p, hook); err == nil {
+		t.Errorf("expected the sleep hook to be interrupted by the deadline")
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, 80 * time.Second, 160 * time.Second, 5 * time.Minute, 5 * time.Minute}
+
+	for restartCount, want := range expected {
+		if got := restartBackoff(restartCount); got != want {
+			t.Errorf("restart %d: got backoff %v, want %v", restartCount, got, want)
+		}
+	}
+}
+
+func TestSidecarsStopInReverseOrder(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+
+	pod := &v1.Pod{Spec: v1.PodSpec{InitContainers: []v1.Container{
+		{Name: "first", RestartPolicy: &always},
+		{Name: "second", RestartPolicy: &always},
+		{Name: "third", RestartPolicy: &always},
+	}}}
+
+	s := &containerSupervisor{running: make(map[string]*runningContainer)}
+
+	stopped := filepath.Join(t.TempDir(), "stopped")
+
+	for i := range pod.Spec.InitContainers {
+		container := &pod.Spec.InitContainers[i]
+
+		// each sidecar records when it is stopped.
+		cmd := exec.Command("sh", "-c", fmt.Sprintf("trap 'echo %s >> %s; exit 0' TERM; while true; do sleep 0.05; done", container.Name, stopped))
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("start: %v", err)
+		}
+
+		exited := make(chan struct{})
+
+		go func() {
+			_ = cmd.Wait()
+			close(exited)
+		}()
+
+		s.register(container.Name, &runningContainer{prober: &prober{pod: pod, container: container}, pid: cmd.Process.Pid, exited: exited})
+	}
+
+	// wait for the traps to be installed.
+	time.Sleep(200 * time.Millisecond)
+
+	s.terminateSidecars(pod)
+
+	order, err := os.ReadFile(stopped)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if string(order) != "third\nsecond\nfirst\n" {
+		t.Errorf("got stop order %q, want the reverse order of declaration", order)
+	}
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
@@ -38,6 +39,7 @@ import (
 
 	"github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
@@ -70,13 +72,67 @@ func fileExists(filename string) bool {
 	return !info.IsDir() // Ensure it's a file, not a directory
 }
 
+// acquirePod reads the pod definition that hpk-kubelet has written in the job directory. If the definition
+// cannot be read, and a kubeconfig is given, the pod is fetched from the API server instead.
+func acquirePod(namespace string, podID string, podSpecPath string, kubeconfig string) (*v1.Pod, error) {
+	if podSpecPath == "" && kubeconfig == "" {
+		return nil, fmt.Errorf("either -pod-spec or -kubeconfig must be provided")
+	}
+
+	var clientset *kubernetes.Clientset
+
+	if kubeconfig != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("error building kubeconfig: %w", err)
+		}
+
+		if clientset, err = kubernetes.NewForConfig(config); err != nil {
+			return nil, fmt.Errorf("error creating Kubernetes client: %w", err)
+		}
+	}
+
+	// the definition may not be visible yet, e.g, due to the caching of a shared filesystem.
+	timeout := time.After(5 * time.Minute)
+
+	for {
+		if podSpecPath != "" {
+			pod, err := podhandler.LoadPodFromFile(podSpecPath)
+			if err == nil {
+				return pod, nil
+			}
+
+			log.Error().Err(err).Msg("Error reading pod definition. Retrying...")
+		}
+
+		if clientset != nil {
+			pod, err := getPodDetails(clientset, namespace, podID)
+			if err == nil {
+				return pod, nil
+			}
+
+			log.Error().Err(err).Msg("Error getting pod details. Retrying...")
+		}
+
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf("timeout reached while acquiring pod %s/%s", namespace, podID)
+		case <-time.After(5 * time.Second): // Adjust retry interval as needed
+		}
+	}
+}
+
 func main() {
 	var podID string
 	var namespaceID string
+	var podSpecPath string
+	var kubeconfig string
 	var wg sync.WaitGroup
 
 	flag.StringVar(&podID, "pod", "", "Pod ID to query Kubernetes")
 	flag.StringVar(&namespaceID, "namespace", "", "Pod ID to query Kubernetes")
+	flag.StringVar(&podSpecPath, "pod-spec", "", "Path to the pod definition (pod.crd) written by hpk-kubelet")
+	flag.StringVar(&kubeconfig, "kubeconfig", "", "Kubeconfig for fetching the pod from the API server, if the pod definition cannot be read (optional)")
 	versionFlag := flag.Bool("version", false, "Print version and exit")
 	flag.Parse()
 
@@ -89,40 +145,9 @@ func main() {
 		log.Fatal().Msg("Please provide both the pod and namespace.")
 	}
 
-	config, err := clientcmd.BuildConfigFromFlags("", filepath.Join("/k8s-data", "admin.conf"))
+	pod, err := acquirePod(namespaceID, podID, podSpecPath, kubeconfig)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error building kubeconfig")
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error creating Kubernetes client")
-	}
-
-	// Main loop to keep asking for pod details
-	timeout := time.After(5 * time.Minute)
-
-acquire_pod_loop:
-	for {
-		select {
-		case <-timeout:
-			log.Error().Msg("Timeout reached. Exiting.")
-			os.Exit(1)
-		default:
-			_, err := getPodDetails(clientset, namespaceID, podID)
-			if err != nil {
-				log.Error().Err(err).Msg("Error getting pod details. Retrying...")
-				time.Sleep(5 * time.Second) // Adjust retry interval as needed
-				continue
-			}
-
-			break acquire_pod_loop
-		}
-	}
-
-	pod, err := getPodDetails(clientset, namespaceID, podID)
-	if err != nil {
-		panic(err)
+		log.Fatal().Err(err).Msg("Error acquiring pod definition")
 	}
 
 	if err := prepareContainers(pod); err != nil {
@@ -130,6 +155,15 @@ acquire_pod_loop:
 		return
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	/*-- Serve the commands of `kubectl exec`, including those for init containers --*/
+	go serveControlSocket(ctx, pod)
+
+	/*-- Publish the resource usage of the containers, for `kubectl top` and metrics-server --*/
+	go reportUsage(ctx, pod)
+
 	if len(pod.Spec.InitContainers) > 0 {
 		if err := handleInitContainers(pod, true); err != nil {
 			log.Error().Err(err).Msg("Error executing init containers")
@@ -142,45 +176,45 @@ acquire_pod_loop:
 		return
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGCHLD)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+
+	/*-- Launch the ephemeral containers that are added by `kubectl debug` --*/
+	compute.Environment.ApptainerBin = "apptainer"
+
+	if podSpecPath != "" {
+		go watchEphemeralContainers(ctx, podSpecPath, pod, true)
+	}
+
+	/*-- Exit once all containers have terminated --*/
+	go func() {
+		// Ensure completion of bookkeeping before reaping the remaining processes,
+		// as reaping races with the exit status that is collected for the containers.
+		wg.Wait()
+
+		log.Info().Msg("Containers have terminated.")
+
+		// Sidecars are stopped once the main containers have terminated.
+		supervisor.terminateSidecars(pod)
+		supervisor.sidecars.Wait()
+
+		reapZombies()
+
+		cancel()
+	}()
 
 	go func() {
 		for {
 			select {
 			case signo := <-signalChan:
-				switch signo {
-				case syscall.SIGINT, syscall.SIGTERM:
-					// Termination handling of the pause container by external signals
-					log.Info().Msgf("Received %v. Cleaning up...\n", signo)
-
-					// Ensure completion of bookkeeping before handling sigchld
-					wg.Wait()
-
-					// Initiate cleanup
-					cancel()
-
-				case syscall.SIGCHLD:
-					// SIGCHLD handling - reap zombie processes
-					log.Info().Msg("Received SIGCHLD. Containers have terminated. ")
-
-					// Ensure completion of bookkeeping before handling sigchld
-					wg.Wait()
-					for {
-						pid, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil)
-						if pid <= 0 {
-							if err != nil {
-								log.Error().Err(err).Msg("Error stopping hpk-pause")
-							}
-							break
-						}
-						log.Info().Msgf("pid: %v", pid)
-					}
+				// Termination handling of the pause container by external signals
+				log.Info().Msgf("Received %v. Cleaning up...\n", signo)
+
+				// Run the preStop hooks and stop the main containers within the grace period.
+				// The exit codes are recorded by the containers' supervisors, which then release the wait group.
+				// Sidecars are stopped afterwards.
+				supervisor.terminate(pod)
 
-					// Initiate cleanup after SIGCHLD handling
-					cancel()
-				}
 			case <-ctx.Done():
 				log.Info().Msg("Containers and context have terminated. Exiting...")
 				return
@@ -194,6 +228,20 @@ acquire_pod_loop:
 
 }
 
+// reapZombies collects the exit status of orphaned processes that have been re-parented to the pause container.
+func reapZombies() {
+	for {
+		pid, err := syscall.Wait4(-1, nil, syscall.WNOHANG, nil)
+		if pid <= 0 {
+			if err != nil && err != syscall.ECHILD {
+				log.Error().Err(err).Msg("Error stopping hpk-pause")
+			}
+			break
+		}
+		log.Info().Msgf("pid: %v", pid)
+	}
+}
+
 func prepareContainers(pod *v1.Pod) error {
 	if err := prepareDNS(pod); err != nil {
 		return fmt.Errorf("could not prepare DNS : %v", err)
@@ -212,10 +260,25 @@ func announceIP(pod *v1.Pod) error {
 	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
 	podPath := hpk.Pod(podKey)
 
+	ipAddresses, err := podIPAddresses()
+	if err != nil {
+		return err
+	}
+	ipString := strings.Join(ipAddresses, " ")
+
+	if err := os.WriteFile(podPath.IPAddressPath(), []byte(ipString), os.ModePerm); err != nil {
+		return fmt.Errorf("error writing to .ip file: %v", err)
+	}
+	return nil
+}
+
+// podIPAddresses returns the non-loopback IPv4 addresses of the pod's network namespace.
+func podIPAddresses() ([]string, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
-		return fmt.Errorf("could not get interfaces from host: %v", err)
+		return nil, fmt.Errorf("could not get interfaces from host: %v", err)
 	}
+
 	var ipAddresses []string
 	for _, addr := range addrs {
 		// Add only if the address is an IP address
@@ -223,12 +286,7 @@ func announceIP(pod *v1.Pod) error {
 			ipAddresses = append(ipAddresses, ipNet.IP.String())
 		}
 	}
-	ipString := strings.Join(ipAddresses, " ")
-
-	if err := os.WriteFile(podPath.IPAddressPath(), []byte(ipString), os.ModePerm); err != nil {
-		return fmt.Errorf("error writing to .ip file: %v", err)
-	}
-	return nil
+	return ipAddresses, nil
 }
 
 func cleanEnvironment() error {
@@ -284,17 +342,9 @@ options ndots:5
 		return fmt.Errorf("error getting hostname: %v", err)
 	}
 
-	addrs, err := net.InterfaceAddrs()
+	ipAddresses, err := podIPAddresses()
 	if err != nil {
-		return fmt.Errorf("could not get interfaces from host: %v", err)
-	}
-
-	var ipAddresses []string
-	for _, addr := range addrs {
-		// Add only if the address is an IP address
-		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
-			ipAddresses = append(ipAddresses, ipNet.IP.String())
-		}
+		return err
 	}
 	ipString := strings.Join(ipAddresses, " ") + " " + hostname
 
@@ -314,106 +364,39 @@ func DebugDNSInfo(resolvConfContent string, hostsContent string) {
 
 }
 
+// logDrainTimeout bounds the time for copying the remaining output of a container to its log file,
+// after the container has exited. Orphaned processes may otherwise keep the output pipes open.
+const logDrainTimeout = 5 * time.Second
+
 func handleInitContainers(pod *v1.Pod, hpkEnv bool) error {
-	isDebug := os.Getenv("DEBUG_MODE") == "true"
 	podKey := client.ObjectKeyFromObject(pod)
 	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
 	podPath := hpk.Pod(podKey)
 	for _, container := range pod.Spec.InitContainers {
-		effectiSecurityContext := podhandler.DetermineEffectiveSecurityContext(pod, &container)
-		uid, gid := podhandler.DetermineEffectiveRunAsUser(effectiSecurityContext)
 		log.Info().Msgf("Spawning init container: %s", container.Name)
-		instanceName := fmt.Sprintf("%s_%s_%s", pod.GetNamespace(), pod.GetName(), container.Name)
 
 		containerPath := podPath.Container(container.Name)
-		envFilePath := containerPath.EnvFilePath()
 
-		// Environment File Handling
-		if fileExists(envFilePath) {
-			output, err := exec.Command("sh", "-c", envFilePath).CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("error executing EnvFilePath: %v, output: %s", err, output)
-			}
-			envFileName := filepath.Join("/scratch", instanceName+".env")
-			if err := os.WriteFile(envFileName, output, 0644); err != nil {
-				return fmt.Errorf("error writing env file: %v", err)
-			}
+		if err := prepareEnvFile(pod, &container); err != nil {
+			return err
 		}
 
-		executionMode := "exec"
-		if container.Command == nil {
-			executionMode = "run"
+		apptainerArgs, err := containerArgs(pod, &container, executionMode(&container), hpkEnv)
+		if err != nil {
+			return err
 		}
 
-		binds := make([]string, len(container.VolumeMounts))
+		command, args := kubecontainer.ExpandContainerCommandAndArgs(&container, containerEnv(pod, &container))
+		apptainerArgs = append(apptainerArgs, command...)
+		apptainerArgs = append(apptainerArgs, args...)
 
-		// check the code from https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go#L196
-		for i, mount := range container.VolumeMounts {
-			hostPath := filepath.Join(podPath.VolumeDir(), mount.Name)
-
-			subPath := mount.SubPath
-			if mount.SubPathExpr != "" {
-
-				path, err := kubecontainer.ExpandContainerVolumeMounts(mount, podhandler.FromServices(context.Background(), pod.Namespace))
-				if err != nil {
-					compute.SystemPanic(err, "cannot expand env variables for container '%s' of pod '%s'", container.Name, podKey)
-				}
-				subPath = path
+		/*-- Sidecars keep running alongside the main containers. Proceed once they have started --*/
+		if podhandler.IsSidecar(&container) {
+			if err := startSidecar(pod, &container, apptainerArgs); err != nil {
+				return err
 			}
 
-			if subPath != "" {
-				if filepath.IsAbs(subPath) {
-					return fmt.Errorf("error SubPath '%s' must not be an absolute path", subPath)
-				}
-
-				subPathFile := filepath.Join(hostPath, subPath)
-
-				// mount the subpath
-				hostPath = subPathFile
-			}
-
-			accessMode := "rw"
-			if mount.ReadOnly {
-				accessMode = "ro"
-			}
-
-			binds[i] = hostPath + ":" + mount.MountPath + ":" + accessMode
-		}
-
-		// Apptainer Command Construction
-		apptainerVerbosity := "--quiet"
-		if isDebug {
-			apptainerVerbosity = "--debug"
-		}
-		apptainerArgs := []string{
-			apptainerVerbosity, executionMode, "--nv", "--cleanenv", "--writable-tmpfs", "--no-mount", "home", "--unsquash",
-		}
-		if hpkEnv {
-			apptainerArgs = append(apptainerArgs, "--bind", "/scratch/etc/resolv.conf:/etc/resolv.conf,/scratch/etc/hosts:/etc/hosts")
-		}
-		if len(binds) > 0 {
-			bindArgs := &apptainerArgs[len(apptainerArgs)-1]
-			*bindArgs += "," + strings.Join(binds, ",")
-		}
-		if uid != 0 {
-			apptainerArgs = append(apptainerArgs, "--security", fmt.Sprintf("uid:%d,gid:%d", uid, uid), "--userns")
-		}
-		if gid != 0 {
-			apptainerArgs = append(apptainerArgs, "--security", fmt.Sprintf("gid:%d", gid), "--userns")
-		}
-
-		if fileExists(envFilePath) {
-			apptainerArgs = append(apptainerArgs, "--env-file", filepath.Join("/scratch", instanceName+".env"))
-		}
-
-		apptainerArgs = append(apptainerArgs, hpk.ImageDir()+image.ParseImageName(container.Image))
-		apptainerArgs = append(apptainerArgs, kubecontainer.ExpandContainerCommandOnlyStatic(container.Command, container.Env)...)
-		apptainerArgs = append(apptainerArgs, kubecontainer.ExpandContainerCommandOnlyStatic(container.Args, container.Env)...)
-
-		// Get the PID
-		pid := os.Getpid()
-		if err := os.WriteFile(containerPath.IDPath(), []byte(fmt.Sprintf("pid://%d", pid)), 0644); err != nil {
-			return fmt.Errorf("failed to create pid file") // Log the error
+			continue
 		}
 
 		// Execute Apptainer (Blocking)
@@ -422,20 +405,43 @@ func handleInitContainers(pod *v1.Pod, hpkEnv bool) error {
 		cmd.Env = os.Environ()
 
 		// Open log file
-		logFile, err := os.Create(containerPath.LogsPath())
+		logFile, err := createLogFile(pod, containerPath)
 		if err != nil {
 			return fmt.Errorf("failed to create log file: %v", err)
 		}
 		defer logFile.Close()
 
-		// // Redirect output to log file
-		cmd.Stdout = logFile
-		cmd.Stderr = logFile
-		if err := cmd.Run(); err != nil {
+		if err := resetTerminationLog(containerPath); err != nil {
+			return fmt.Errorf("failed to create termination log: %v", err)
+		}
+
+		// Redirect output to log file, in the CRI logging format
+		logWriter := kubecontainer.NewLogWriter(logFile)
+		stdout, stderr := logWriter.Stream(kubecontainer.Stdout), logWriter.Stream(kubecontainer.Stderr)
+
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		cmd.WaitDelay = logDrainTimeout
+
+		if err := cmd.Start(); err != nil {
+			return fmt.Errorf("failed to start init container: %v", err)
+		}
+
+		// Get the PID, through which `kubectl exec` enters the container
+		if err := os.WriteFile(containerPath.IDPath(), []byte(fmt.Sprintf("pid://%d", cmd.Process.Pid)), 0644); err != nil {
+			return fmt.Errorf("failed to create pid file") // Log the error
+		}
+
+		err = cmd.Wait()
+
+		_ = stdout.Close()
+		_ = stderr.Close()
+
+		if err != nil {
 			log.Error().Err(err).Msgf("Error executing init container: %s", container.Name)
 			return fmt.Errorf("init container failed: %v", err) // Abort on failure
 		}
-		if err := os.WriteFile(containerPath.ExitCodePath(), []byte(strconv.Itoa(cmd.ProcessState.ExitCode())), 0644); err != nil {
+		if err := os.WriteFile(containerPath.ExitCodePath(), []byte(strconv.Itoa(exitCode(cmd.ProcessState))), 0644); err != nil {
 			return fmt.Errorf("failed to create exitCode file") // Log the error
 		}
 	}
@@ -443,143 +449,421 @@ func handleInitContainers(pod *v1.Pod, hpkEnv bool) error {
 }
 
 func handleContainers(pod *v1.Pod, wg *sync.WaitGroup, hpkEnv bool) error {
-	isDebug := os.Getenv("DEBUG_MODE") == "true"
+	for _, container := range pod.Spec.Containers {
+		if err := prepareEnvFile(pod, &container); err != nil {
+			return err
+		}
+
+		apptainerArgs, err := containerArgs(pod, &container, executionMode(&container), hpkEnv)
+		if err != nil {
+			return err
+		}
+
+		command, args := kubecontainer.ExpandContainerCommandAndArgs(&container, containerEnv(pod, &container))
+		apptainerArgs = append(apptainerArgs, command...)
+		apptainerArgs = append(apptainerArgs, args...)
+
+		wg.Add(1)
+		go func(container v1.Container) { // Ensure container cleanup
+			defer wg.Done()
+
+			runContainer(pod, &container, apptainerArgs, nil)
+		}(container)
+
+	}
+	return nil
+}
+
+// startSidecar runs a sidecar container in the background, and blocks until the container is started,
+// i.e, its startup probe has succeeded, or it is running if there is no startup probe.
+func startSidecar(pod *v1.Pod, container *v1.Container, apptainerArgs []string) error {
+	log.Info().Msgf("Spawning sidecar container: %s", container.Name)
+
+	started := make(chan struct{})
+	exited := make(chan struct{})
+
+	supervisor.sidecars.Add(1)
+
+	go func() {
+		defer supervisor.sidecars.Done()
+		defer close(exited)
+
+		runContainer(pod, container, apptainerArgs, sync.OnceFunc(func() { close(started) }))
+	}()
+
+	select {
+	case <-started:
+		return nil
+	case <-exited:
+		return fmt.Errorf("sidecar container %s has terminated before starting", container.Name)
+	}
+}
+
+// runContainer executes a main (or sidecar) container and supervises it until it terminates.
+// Once the container is started, its postStart hook is executed, followed by its probes.
+// If the postStart hook or the liveness (or startup) probe fails, the container is killed and,
+// if the restartPolicy of the pod permits, it is started again. Sidecars are always restarted
+// until the pod is terminating. If set, onStarted is invoked once the container is started.
+func runContainer(pod *v1.Pod, container *v1.Container, apptainerArgs []string, onStarted func()) {
 	podKey := client.ObjectKeyFromObject(pod)
 	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
-	podPath := hpk.Pod(podKey)
-	for _, container := range pod.Spec.Containers {
-		effectiSecurityContext := podhandler.DetermineEffectiveSecurityContext(pod, &container)
-		uid, gid := podhandler.DetermineEffectiveRunAsUser(effectiSecurityContext)
-		instanceName := fmt.Sprintf("%s_%s_%s", pod.GetNamespace(), pod.GetName(), container.Name)
+	containerPath := hpk.Pod(podKey).Container(container.Name)
 
-		containerPath := podPath.Container(container.Name)
-		envFilePath := containerPath.EnvFilePath()
+	defer supervisor.unregister(container.Name)
 
-		// Environment File Handling
-		if fileExists(envFilePath) {
-			output, err := exec.Command("sh", "-c", envFilePath).CombinedOutput()
-			if err != nil {
-				return fmt.Errorf("error executing EnvFilePath: %v, output: %s", err, output)
-			}
-			envFileName := filepath.Join("/scratch", instanceName+".env")
-			if err := os.WriteFile(envFileName, output, 0644); err != nil {
-				return fmt.Errorf("error writing env file: %v", err)
-			}
+	for restartCount := 0; ; restartCount++ {
+		// Every instance of the container starts with a new log file. The log of the previous instance is kept.
+		logFile, err := createLogFile(pod, containerPath)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to create log file %s", containerPath.LogsPath())
+			return
 		}
 
-		executionMode := "exec"
-		if container.Command == nil {
-			executionMode = "run"
+		if err := resetTerminationLog(containerPath); err != nil {
+			log.Error().Err(err).Msgf("Failed to create termination log %s", containerPath.TerminationMessagePath())
+			_ = logFile.Close()
+			return
 		}
 
-		binds := make([]string, len(container.VolumeMounts))
+		// Execute Apptainer in Background
+		log.Debug().Msg(fmt.Sprintf("ApptainerArgs: %v", apptainerArgs))
+		cmd := exec.Command("apptainer", apptainerArgs...)
+		cmd.Env = os.Environ()
 
-		// check the code from https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go#L196
-		for i, mount := range container.VolumeMounts {
-			hostPath := filepath.Join(podPath.VolumeDir(), mount.Name)
+		// Redirect output to log file, in the CRI logging format
+		logWriter := kubecontainer.NewLogWriter(logFile)
+		stdout, stderr := logWriter.Stream(kubecontainer.Stdout), logWriter.Stream(kubecontainer.Stderr)
 
-			subPath := mount.SubPath
-			if mount.SubPathExpr != "" {
+		// The output is also streamed to the clients of `kubectl attach`, which feed the stdin (or tty) of the container.
+		attachPoint := attachPoints.get(container)
 
-				path, err := kubecontainer.ExpandContainerVolumeMounts(mount, podhandler.FromServices(context.Background(), pod.Namespace))
-				if err != nil {
-					compute.SystemPanic(err, "cannot expand env variables for container '%s' of pod '%s'", container.Name, podKey)
-				}
-				subPath = path
-			}
+		log.Info().Msgf("Spawning main container: %s", container.Name)
+		// Start the  container
+		finishStreams, err := attachPoint.start(cmd, stdout, stderr)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start Apptainer container")
+			_ = logFile.Close()
+			return
+		}
 
-			if subPath != "" {
-				if filepath.IsAbs(subPath) {
-					return fmt.Errorf("error SubPath '%s' must not be an absolute path", subPath)
-				}
+		// Get the PID
+		pid := cmd.Process.Pid
+		if err := os.WriteFile(containerPath.IDPath(), []byte(fmt.Sprintf("pid://%d", pid)), 0644); err != nil {
+			log.Error().Err(err).Msg("Failed to create pid file") // Log the error
+			_ = logFile.Close()
+			return
+		}
 
-				subPathFile := filepath.Join(hostPath, subPath)
+		/*-- Register the container, so that it can be stopped gracefully --*/
+		exited := make(chan struct{})
+		p := newProber(pod, container)
+		p.onStarted = onStarted
 
-				// mount the subpath
-				hostPath = subPathFile
-			}
+		current := &runningContainer{prober: p, pid: pid, exited: exited}
+
+		if !supervisor.register(container.Name, current) {
+			// the pod started terminating while the container was starting.
+			go stopContainer(current, terminationGracePeriod(pod))
+		}
+
+		/*-- Run the postStart hook, and then the probes, for as long as the container is running --*/
+		unhealthy := make(chan struct{})
+		markUnhealthy := sync.OnceFunc(func() { close(unhealthy) })
+		hookCtx, cancelHooks := context.WithCancel(context.Background())
 
-			accessMode := "rw"
-			if mount.ReadOnly {
-				accessMode = "ro"
+		go func() {
+			if container.Lifecycle != nil && container.Lifecycle.PostStart != nil {
+				if err := runHook(hookCtx, p, container.Lifecycle.PostStart); err != nil {
+					if hookCtx.Err() != nil {
+						return
+					}
+
+					log.Error().Err(err).Msgf("PostStart hook of container %s has failed. Killing container", container.Name)
+
+					markUnhealthy()
+					stopContainer(current, terminationGracePeriod(pod))
+
+					return
+				}
 			}
 
-			binds[i] = hostPath + ":" + mount.MountPath + ":" + accessMode
+			p.run(hookCtx, func(gracePeriod time.Duration) {
+				log.Info().Msgf("Container %s failed its liveness probe and will be restarted", container.Name)
+
+				markUnhealthy()
+				killContainer(pid, gracePeriod, exited)
+			})
+		}()
+
+		// Handle Exit (consider moving output writing or using cmd.Wait)
+		if err := cmd.Wait(); err != nil {
+			log.Error().Err(err).Msgf("error executing container: %s, because of %v", container.Name, err)
 		}
 
-		// Apptainer Command Construction
-		apptainerVerbosity := "--quiet"
-		if isDebug {
-			apptainerVerbosity = "--debug"
+		finishStreams()
+
+		_ = stdout.Close()
+		_ = stderr.Close()
+		_ = logFile.Close()
+
+		close(exited)
+		cancelHooks()
+
+		attachPoint.exited(exitCode(cmd.ProcessState))
+
+		/*-- Restart the container if it was killed due to a failed hook or liveness probe, or if it is a sidecar --*/
+		restart := podhandler.IsSidecar(container)
+
+		reason := "Completed"
+		if exitCode(cmd.ProcessState) != 0 {
+			reason = "Error"
 		}
-		apptainerArgs := []string{
-			apptainerVerbosity, executionMode, "--nv", "--cleanenv", "--writable-tmpfs", "--no-mount", "home", "--unsquash",
+
+		select {
+		case <-unhealthy:
+			restart = restart || pod.Spec.RestartPolicy != v1.RestartPolicyNever
+			reason = "Unhealthy"
+		default:
 		}
-		if hpkEnv {
-			apptainerArgs = append(apptainerArgs, "--bind", "/scratch/etc/resolv.conf:/etc/resolv.conf,/scratch/etc/hosts:/etc/hosts")
-			if len(binds) > 0 {
-				bindArgs := &apptainerArgs[len(apptainerArgs)-1]
-				*bindArgs += "," + strings.Join(binds, ",")
+
+		if restart && !supervisor.isTerminating() {
+			// the instance is archived and its reason is written first, as the restart count triggers the update
+			// of the container status.
+			archiveContainerInstance(containerPath)
+
+			lastTermination := fmt.Sprintf("%s %d", reason, exitCode(cmd.ProcessState))
+			if err := os.WriteFile(containerPath.LastTerminationPath(), []byte(lastTermination), 0644); err != nil {
+				log.Error().Err(err).Msg("Failed to create lastTermination file")
+			}
+
+			if err := os.WriteFile(containerPath.RestartCountPath(), []byte(strconv.Itoa(restartCount+1)), 0644); err != nil {
+				log.Error().Err(err).Msg("Failed to create restartCount file")
 			}
+
+			// back off, so that a crashing container does not spin.
+			time.Sleep(restartBackoff(restartCount))
+
+			continue
 		}
-		if uid != 0 {
-			apptainerArgs = append(apptainerArgs, "--security", fmt.Sprintf("uid:%d,gid:%d", uid, uid), "--userns")
+
+		if err := os.WriteFile(containerPath.ExitCodePath(), []byte(strconv.Itoa(exitCode(cmd.ProcessState))), 0644); err != nil {
+			log.Error().Err(err).Msg("Failed to create exitCode file") // Log the error
 		}
-		if gid != 0 {
-			apptainerArgs = append(apptainerArgs, "--security", fmt.Sprintf("gid:%d", gid), "--userns")
+
+		return
+	}
+}
+
+// createLogFile archives the log of the previous instance of a container, and creates a new
+// log file that is rotated according to the limits set by hpk-kubelet.
+func createLogFile(pod *v1.Pod, containerPath endpoint.ContainerPath) (*kubecontainer.RotatingFile, error) {
+	const (
+		defaultLogMaxSize  = 10 * 1024 * 1024
+		defaultLogMaxFiles = 5
+	)
+
+	maxSize := int64(defaultLogMaxSize)
+	if value, ok := pod.Annotations["containerLogMaxSize"]; ok {
+		if quantity, err := resource.ParseQuantity(value); err == nil {
+			maxSize = quantity.Value()
+		} else {
+			log.Warn().Err(err).Msgf("Invalid containerLogMaxSize '%s'. Using the default", value)
 		}
+	}
 
-		if fileExists(envFilePath) {
-			apptainerArgs = append(apptainerArgs, "--env-file", filepath.Join("/scratch", instanceName+".env"))
+	maxFiles := defaultLogMaxFiles
+	if value, ok := pod.Annotations["containerLogMaxFiles"]; ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			maxFiles = n
+		} else {
+			log.Warn().Err(err).Msgf("Invalid containerLogMaxFiles '%s'. Using the default", value)
 		}
+	}
 
-		apptainerArgs = append(apptainerArgs, hpk.ImageDir()+image.ParseImageName(container.Image))
-		apptainerArgs = append(apptainerArgs, kubecontainer.ExpandContainerCommandOnlyStatic(container.Command, container.Env)...)
-		apptainerArgs = append(apptainerArgs, kubecontainer.ExpandContainerCommandOnlyStatic(container.Args, container.Env)...)
+	if err := kubecontainer.ArchiveLogFile(containerPath.LogsPath(), containerPath.PreviousLogsPath()); err != nil {
+		log.Warn().Err(err).Msg("Failed to keep the logs of the previous container instance")
+	}
 
-		wg.Add(1)
-		go func(container v1.Container) { // Ensure container cleanup
-			defer wg.Done()
-			// Execute Apptainer in Background
-			log.Debug().Msg(fmt.Sprintf("ApptainerArgs: %v", apptainerArgs))
-			cmd := exec.Command("apptainer", apptainerArgs...)
-			cmd.Env = os.Environ()
-			// If needed, get references to stdout and stderr
-			// log.Debug().Msgf("LogPath: %s", containerPath.LogsPath())
-			logFile, err := os.Create(containerPath.LogsPath())
+	return kubecontainer.CreateRotatingFile(containerPath.LogsPath(), maxSize, maxFiles)
+}
+
+// archiveContainerInstance keeps the logs and the termination message of a container instance that is restarted,
+// for hpk-kubelet to report them in the last termination state, and to serve them to `kubectl logs --previous`.
+func archiveContainerInstance(containerPath endpoint.ContainerPath) {
+	if err := kubecontainer.ArchiveLogFile(containerPath.LogsPath(), containerPath.PreviousLogsPath()); err != nil {
+		log.Warn().Err(err).Msg("Failed to keep the logs of the previous container instance")
+	}
+
+	err := os.Rename(containerPath.TerminationMessagePath(), containerPath.PreviousTerminationMessagePath())
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Err(err).Msg("Failed to keep the termination message of the previous container instance")
+	}
+}
+
+// resetTerminationLog creates an empty termination log for a new instance of a container. The file is bound
+// to the terminationMessagePath of the container, and is writable by any user the container may run as.
+func resetTerminationLog(containerPath endpoint.ContainerPath) error {
+	path := containerPath.TerminationMessagePath()
+
+	if err := os.WriteFile(path, nil, 0o666); err != nil {
+		return err
+	}
+
+	// the permissions of new files are restricted by the umask.
+	return os.Chmod(path, 0o666)
+}
+
+// executionMode returns "run" for containers without a command, which will execute the runscript
+// defined in the Entrypoint of the image. Otherwise, it returns "exec".
+func executionMode(container *v1.Container) string {
+	if container.Command == nil {
+		return "run"
+	}
+
+	return "exec"
+}
+
+// prepareEnvFile fills the IPs of the pod into the env file of the container, as generated by hpk-kubelet,
+// and stores the result in the scratch directory of the pod.
+func prepareEnvFile(pod *v1.Pod, container *v1.Container) error {
+	podKey := client.ObjectKeyFromObject(pod)
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+	envFilePath := hpk.Pod(podKey).Container(container.Name).EnvFilePath()
+
+	if !fileExists(envFilePath) {
+		return nil
+	}
+
+	content, err := os.ReadFile(envFilePath)
+	if err != nil {
+		return fmt.Errorf("error reading env file: %v", err)
+	}
+
+	ipAddresses, err := podIPAddresses()
+	if err != nil {
+		return err
+	}
+
+	var podIP string
+	if len(ipAddresses) > 0 {
+		podIP = ipAddresses[0]
+	}
+
+	// IPs contain neither quotes nor newlines, so they can be placed verbatim into the quoted values.
+	content = bytes.ReplaceAll(content, []byte(podhandler.PodIPsPlaceholder), []byte(strings.Join(ipAddresses, ",")))
+	content = bytes.ReplaceAll(content, []byte(podhandler.PodIPPlaceholder), []byte(podIP))
+
+	if err := os.WriteFile(scratchEnvFilePath(pod, container), content, 0600); err != nil {
+		return fmt.Errorf("error writing env file: %v", err)
+	}
+
+	return nil
+}
+
+// containerEnv returns the resolved environment of the container, as prepared in the scratch directory.
+// If there is no env file, the static variables of the container are returned.
+func containerEnv(pod *v1.Pod, container *v1.Container) []v1.EnvVar {
+	content, err := os.ReadFile(scratchEnvFilePath(pod, container))
+	if err != nil {
+		return container.Env
+	}
+
+	env, err := podhandler.DecodeEnvFile(content)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Cannot decode the env file of container: %s", container.Name)
+
+		return container.Env
+	}
+
+	return env
+}
+
+func scratchEnvFilePath(pod *v1.Pod, container *v1.Container) string {
+	instanceName := fmt.Sprintf("%s_%s_%s", pod.GetNamespace(), pod.GetName(), container.Name)
+
+	return filepath.Join("/scratch", instanceName+".env")
+}
+
+// containerArgs returns the apptainer arguments for instantiating the image of a container,
+// with the binds, DNS files, security context, environment, and resource limits of the pod.
+// The command of the container is expected to be appended by the caller.
+func containerArgs(pod *v1.Pod, container *v1.Container, executionMode string, hpkEnv bool) ([]string, error) {
+	isDebug := os.Getenv("DEBUG_MODE") == "true"
+	podKey := client.ObjectKeyFromObject(pod)
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+	podPath := hpk.Pod(podKey)
+
+	effectiSecurityContext := podhandler.DetermineEffectiveSecurityContext(pod, container)
+	uid, gid := podhandler.DetermineEffectiveRunAsUser(effectiSecurityContext)
+
+	var binds []string
+	if hpkEnv {
+		binds = append(binds, "/scratch/etc/resolv.conf:/etc/resolv.conf", "/scratch/etc/hosts:/etc/hosts")
+	}
+
+	// check the code from https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go#L196
+	for _, mount := range container.VolumeMounts {
+		hostPath := filepath.Join(podPath.VolumeDir(), mount.Name)
+
+		subPath := mount.SubPath
+		if mount.SubPathExpr != "" {
+
+			path, err := kubecontainer.ExpandContainerVolumeMounts(mount, containerEnv(pod, container))
 			if err != nil {
-				log.Error().Err(err).Msgf("Failed to create log file %s", containerPath.LogsPath())
-				return
-			}
-			defer logFile.Close()
-
-			cmd.Stdout = logFile
-			cmd.Stderr = logFile
-			log.Info().Msgf("Spawning main container: %s", container.Name)
-			// Start the  container
-			if err := cmd.Start(); err != nil {
-				log.Error().Err(err).Msg("Failed to start Apptainer container")
+				compute.SystemPanic(err, "cannot expand env variables for container '%s' of pod '%s'", container.Name, podKey)
 			}
+			subPath = path
+		}
 
-			// Get the PID
-			pid := cmd.Process.Pid
-			if err := os.WriteFile(containerPath.IDPath(), []byte(fmt.Sprintf("pid://%d", pid)), 0644); err != nil {
-				log.Error().Err(err).Msg("Failed to create pid file") // Log the error
-				return
+		if subPath != "" {
+			if filepath.IsAbs(subPath) {
+				return nil, fmt.Errorf("error SubPath '%s' must not be an absolute path", subPath)
 			}
 
-			// Handle Exit (consider moving output writing or using cmd.Wait)
-			if err := cmd.Wait(); err != nil {
-				log.Error().Err(err).Msgf("error executing container: %s, because of %v", container.Name, err)
-			}
+			subPathFile := filepath.Join(hostPath, subPath)
 
-			if err := os.WriteFile(containerPath.ExitCodePath(), []byte(strconv.Itoa(cmd.ProcessState.ExitCode())), 0644); err != nil {
-				log.Error().Err(err).Msg("Failed to create exitCode file") // Log the error
-				return
-			}
+			// mount the subpath
+			hostPath = subPathFile
+		}
 
-		}(container)
+		accessMode := "rw"
+		if mount.ReadOnly {
+			accessMode = "ro"
+		}
 
+		binds = append(binds, hostPath+":"+mount.MountPath+":"+accessMode)
 	}
-	return nil
+
+	// the message that the container writes before terminating is read by hpk-kubelet.
+	if container.TerminationMessagePath != "" {
+		binds = append(binds, podPath.Container(container.Name).TerminationMessagePath()+":"+container.TerminationMessagePath+":rw")
+	}
+
+	// Apptainer Command Construction
+	apptainerVerbosity := "--quiet"
+	if isDebug {
+		apptainerVerbosity = "--debug"
+	}
+	apptainerArgs := []string{
+		apptainerVerbosity, executionMode, "--nv", "--cleanenv", "--writable-tmpfs", "--no-mount", "home", "--unsquash",
+	}
+	if len(binds) > 0 {
+		apptainerArgs = append(apptainerArgs, "--bind", strings.Join(binds, ","))
+	}
+	if uid != 0 {
+		apptainerArgs = append(apptainerArgs, "--security", fmt.Sprintf("uid:%d,gid:%d", uid, uid), "--userns")
+	}
+	if gid != 0 {
+		apptainerArgs = append(apptainerArgs, "--security", fmt.Sprintf("gid:%d", gid), "--userns")
+	}
+
+	if fileExists(scratchEnvFilePath(pod, container)) {
+		apptainerArgs = append(apptainerArgs, "--env-file", scratchEnvFilePath(pod, container))
+	}
+
+	apptainerArgs = append(apptainerArgs, resourceArgs(container, delegatedControllers())...)
+
+	apptainerArgs = append(apptainerArgs, hpk.ImageDir()+image.ParseImageName(container.Image))
+
+	return apptainerArgs, nil
 }
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 
 	"hpk/internal/compute/endpoint"
+	kubecontainer "hpk/pkg/container"
+
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -63,7 +68,7 @@ func TestHandleInitContainers_Success(t *testing.T) {
 		t.Errorf("handleInitContainers failed unexpectedly: %v", err)
 	}
 	//  Verify log file contents (adjust the path as needed based on your implementation)
-	logData, err := os.ReadFile(logPath)
+	logData, err := readLogs(logPath)
 	if err != nil {
 		t.Errorf("Error reading log file: %v", err)
 	}
@@ -126,7 +131,7 @@ func TestHandleContainers_Success(t *testing.T) {
 
 	wg.Wait()
 	//  Verify log file contents (adjust the path as needed based on your implementation)
-	logData, err := os.ReadFile(logPath)
+	logData, err := readLogs(logPath)
 	if err != nil {
 		t.Errorf("Error reading log file: %v", err)
 	}
@@ -145,3 +150,91 @@ func TestHandleContainers_Success(t *testing.T) {
 		t.Errorf("Unexpected exitCode. Got: %v, Expected: %v", string(exitData), 0)
 	}
 }
+
+// readLogs decodes the CRI-formatted log file of a container.
+func readLogs(logPath string) (string, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var out strings.Builder
+	if err := kubecontainer.ReadLogs(f, &out, &kubecontainer.LogOptions{}); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+// Test that the pod definition is read from the job directory, without access to the API server
+func TestAcquirePodFromFile(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-offline-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{"workingDirectory": "/home/test"},
+		},
+	}
+
+	podSpecPath := filepath.Join(t.TempDir(), "pod.crd")
+
+	data, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+
+	if err := os.WriteFile(podSpecPath, data, 0600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	acquired, err := acquirePod("default", "test-offline-pod", podSpecPath, "")
+	if err != nil {
+		t.Fatalf("acquirePod failed unexpectedly: %v", err)
+	}
+
+	if acquired.Name != pod.Name || acquired.Annotations["workingDirectory"] != "/home/test" {
+		t.Errorf("Unexpected pod: %v", acquired.ObjectMeta)
+	}
+
+	if _, err := acquirePod("default", "test-offline-pod", "", ""); err == nil {
+		t.Errorf("Expected error without pod definition or kubeconfig")
+	}
+}
+
+func TestArchiveContainerInstance(t *testing.T) {
+	podPath := endpoint.HPK(t.TempDir()).Pod(client.ObjectKey{Namespace: "default", Name: "restarted"})
+	containerPath := podPath.Container("main")
+
+	if err := os.MkdirAll(podPath.LogDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, content := range map[string]string{
+		containerPath.LogsPath():               "2023-05-01T10:00:00.000000000Z stdout F crashed\n",
+		containerPath.TerminationMessagePath(): "out of disk",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archiveContainerInstance(containerPath)
+
+	/*-- The message and the logs of the instance outlive the reset for the next instance --*/
+	if err := resetTerminationLog(containerPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := os.ReadFile(containerPath.PreviousTerminationMessagePath()); err != nil || string(data) != "out of disk" {
+		t.Errorf("got previous message %q (%v)", data, err)
+	}
+
+	if data, err := os.ReadFile(containerPath.PreviousLogsPath()); err != nil || !strings.Contains(string(data), "crashed") {
+		t.Errorf("got previous logs %q (%v)", data, err)
+	}
+
+	if data, err := os.ReadFile(containerPath.TerminationMessagePath()); err != nil || len(data) != 0 {
+		t.Errorf("got message %q (%v), want an empty one for the next instance", data, err)
+	}
+}
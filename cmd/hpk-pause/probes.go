@@ -0,0 +1,530 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"hpk/internal/compute/endpoint"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protowire"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Defaults for the probe fields, as defined by the Kubernetes API.
+const (
+	defaultProbePeriodSeconds    = 10
+	defaultProbeTimeoutSeconds   = 1
+	defaultProbeSuccessThreshold = 1
+	defaultProbeFailureThreshold = 3
+
+	defaultTerminationGracePeriod = 30 * time.Second
+)
+
+// prober runs the startup, readiness, and liveness probes of a container from within the network namespace
+// of the pod, and publishes their results as control files, so that hpk-kubelet can update the pod status.
+type prober struct {
+	pod       *v1.Pod
+	container *v1.Container
+
+	containerPath endpoint.ContainerPath
+
+	// podIP is the address on which httpGet, tcpSocket, and grpc probes connect, unless a host is specified.
+	podIP string
+
+	// ready caches the last published readiness, so that the control file is rewritten only on changes.
+	ready *bool
+
+	// onStarted, if set, is invoked once the container is considered started.
+	onStarted func()
+}
+
+func newProber(pod *v1.Pod, container *v1.Container) *prober {
+	podKey := client.ObjectKeyFromObject(pod)
+	hpk := endpoint.HPK(pod.Annotations["workingDirectory"])
+
+	podIP := "127.0.0.1"
+	if ipAddresses, err := podIPAddresses(); err == nil && len(ipAddresses) > 0 {
+		podIP = ipAddresses[0]
+	}
+
+	return &prober{
+		pod:           pod,
+		container:     container,
+		containerPath: hpk.Pod(podKey).Container(container.Name),
+		podIP:         podIP,
+	}
+}
+
+// run executes the probes of the container until the context is cancelled.
+// The readiness and liveness probes are held back until the startup probe has succeeded.
+// If the startup or the liveness probe fails, onUnhealthy is invoked with the grace period that
+// the container must be given before being forcibly killed.
+func (p *prober) run(ctx context.Context, onUnhealthy func(gracePeriod time.Duration)) {
+	c := p.container
+
+	/*---------------------------------------------------
+	 * Startup Probe
+	 *---------------------------------------------------*/
+	if err := os.Remove(p.containerPath.StartedPath()); err != nil && !os.IsNotExist(err) {
+		log.Error().Err(err).Msgf("Failed to remove started file of container %s", c.Name)
+	}
+
+	if c.ReadinessProbe != nil {
+		p.setReady(false)
+	}
+
+	if c.StartupProbe != nil {
+		started := false
+
+		p.worker(ctx, "startup", c.StartupProbe, func(success bool) bool {
+			if !success {
+				onUnhealthy(p.gracePeriod(c.StartupProbe))
+				return false
+			}
+
+			started = true
+			return false
+		})
+
+		if !started {
+			return
+		}
+	}
+
+	if err := os.WriteFile(p.containerPath.StartedPath(), []byte("true"), 0644); err != nil {
+		log.Error().Err(err).Msgf("Failed to create started file of container %s", c.Name)
+	}
+
+	if p.onStarted != nil {
+		p.onStarted()
+	}
+
+	/*---------------------------------------------------
+	 * Readiness and Liveness Probes
+	 *---------------------------------------------------*/
+	if c.ReadinessProbe == nil {
+		p.setReady(true)
+	} else {
+		go p.worker(ctx, "readiness", c.ReadinessProbe, func(success bool) bool {
+			p.setReady(success)
+			return true
+		})
+	}
+
+	if c.LivenessProbe != nil {
+		go p.worker(ctx, "liveness", c.LivenessProbe, func(success bool) bool {
+			if !success {
+				onUnhealthy(p.gracePeriod(c.LivenessProbe))
+				return false
+			}
+
+			return true
+		})
+	}
+}
+
+// worker periodically executes a probe, and invokes onResult whenever the probe result
+// crosses the success or failure threshold. The worker stops when onResult returns false,
+// or when the context is cancelled.
+func (p *prober) worker(ctx context.Context, name string, probe *v1.Probe, onResult func(success bool) bool) {
+	period := time.Duration(valueOrDefault(probe.PeriodSeconds, defaultProbePeriodSeconds)) * time.Second
+	timeout := time.Duration(valueOrDefault(probe.TimeoutSeconds, defaultProbeTimeoutSeconds)) * time.Second
+
+	// the startup and liveness probes must have a successThreshold of 1.
+	threshold := newProbeThreshold(probe)
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(probe.InitialDelaySeconds) * time.Second):
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		err := p.probe(ctx, probe, timeout)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			log.Debug().Err(err).Msgf("%s probe of container %s failed", name, p.container.Name)
+		}
+
+		if result, ok := threshold.observe(err == nil); ok {
+			if !onResult(result) {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *prober) setReady(ready bool) {
+	if p.ready != nil && *p.ready == ready {
+		return
+	}
+
+	p.ready = &ready
+
+	if err := os.WriteFile(p.containerPath.ReadinessPath(), []byte(strconv.FormatBool(ready)), 0644); err != nil {
+		log.Error().Err(err).Msgf("Failed to create ready file of container %s", p.container.Name)
+	}
+}
+
+// gracePeriod returns the time given to the container to terminate after a failed probe.
+func (p *prober) gracePeriod(probe *v1.Probe) time.Duration {
+	if probe.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*probe.TerminationGracePeriodSeconds) * time.Second
+	}
+
+	return terminationGracePeriod(p.pod)
+}
+
+/*---------------------------------------------------
+ * Probe Thresholds
+ *---------------------------------------------------*/
+
+// probeThreshold counts consecutive probe results, as in the kubelet's prober.
+type probeThreshold struct {
+	successThreshold int32
+	failureThreshold int32
+
+	successes int32
+	failures  int32
+}
+
+func newProbeThreshold(probe *v1.Probe) *probeThreshold {
+	return &probeThreshold{
+		successThreshold: valueOrDefault(probe.SuccessThreshold, defaultProbeSuccessThreshold),
+		failureThreshold: valueOrDefault(probe.FailureThreshold, defaultProbeFailureThreshold),
+	}
+}
+
+// observe records the outcome of a probe. It returns the result and true once the consecutive
+// outcomes reach the respective threshold. Otherwise, the result is not yet decided.
+func (t *probeThreshold) observe(success bool) (result bool, decided bool) {
+	if success {
+		t.successes++
+		t.failures = 0
+
+		return true, t.successes >= t.successThreshold
+	}
+
+	t.failures++
+	t.successes = 0
+
+	return false, t.failures >= t.failureThreshold
+}
+
+func valueOrDefault(value int32, defaultValue int32) int32 {
+	if value == 0 {
+		return defaultValue
+	}
+
+	return value
+}
+
+/*---------------------------------------------------
+ * Probe Handlers
+ *---------------------------------------------------*/
+
+// probe executes a single probe, and returns nil if the probe has succeeded.
+func (p *prober) probe(ctx context.Context, probe *v1.Probe, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	switch {
+	case probe.Exec != nil:
+		return p.execProbe(ctx, probe.Exec)
+	case probe.HTTPGet != nil:
+		return p.httpProbe(ctx, probe.HTTPGet)
+	case probe.TCPSocket != nil:
+		return p.tcpProbe(ctx, probe.TCPSocket)
+	case probe.GRPC != nil:
+		return p.grpcProbe(ctx, probe.GRPC)
+	default:
+		return fmt.Errorf("probe of container %s has no handler", p.container.Name)
+	}
+}
+
+// execProbe runs the command within the running container. The probe succeeds if the command exits with 0.
+func (p *prober) execProbe(ctx context.Context, action *v1.ExecAction) error {
+	pid, err := runningContainerPID(p.containerPath)
+	if err != nil {
+		return err
+	}
+
+	cmd, err := enterContainer(ctx, pid, action.Command)
+	if err != nil {
+		return err
+	}
+
+	// on timeout, kill the command along with its children, rather than only nsenter.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = logDrainTimeout
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("command '%s' failed: %w, output: %s", strings.Join(action.Command, " "), err, output)
+	}
+
+	return nil
+}
+
+// httpProbe performs an HTTP GET. The probe succeeds if the status code is within [200, 400).
+func (p *prober) httpProbe(ctx context.Context, action *v1.HTTPGetAction) error {
+	port, err := p.resolvePort(action.Port)
+	if err != nil {
+		return err
+	}
+
+	host := action.Host
+	if host == "" {
+		host = p.podIP
+	}
+
+	scheme := strings.ToLower(string(action.Scheme))
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	path := action.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	target := &url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	if u, err := url.Parse(path); err == nil {
+		target.Path, target.RawQuery = u.Path, u.RawQuery
+	} else {
+		target.Path = path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("User-Agent", "kube-probe/hpk")
+	req.Header.Set("Accept", "*/*")
+
+	for _, header := range action.HTTPHeaders {
+		if strings.EqualFold(header.Name, "Host") {
+			req.Host = header.Value
+		} else {
+			req.Header.Set(header.Name, header.Value)
+		}
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // as in the kubelet prober
+			DisableKeepAlives: true,
+		},
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 10*1024))
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("HTTP probe failed with statuscode: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// tcpProbe opens a TCP connection. The probe succeeds if the connection is established.
+func (p *prober) tcpProbe(ctx context.Context, action *v1.TCPSocketAction) error {
+	port, err := p.resolvePort(action.Port)
+	if err != nil {
+		return err
+	}
+
+	host := action.Host
+	if host == "" {
+		host = p.podIP
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// grpcProbe invokes the standard gRPC health checking protocol (grpc.health.v1.Health/Check)
+// over cleartext HTTP/2. The probe succeeds if the service reports SERVING.
+func (p *prober) grpcProbe(ctx context.Context, action *v1.GRPCAction) error {
+	const healthCheckServing = 1
+
+	service := ""
+	if action.Service != nil {
+		service = *action.Service
+	}
+
+	transport := &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}
+	defer transport.CloseIdleConnections()
+
+	target := "http://" + net.JoinHostPort(p.podIP, strconv.Itoa(int(action.Port))) + "/grpc.health.v1.Health/Check"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(encodeGRPCHealthCheckRequest(service)))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+
+	if grpcStatus := resp.Trailer.Get("Grpc-Status"); grpcStatus != "" && grpcStatus != "0" {
+		return fmt.Errorf("gRPC probe failed with status: %s, message: %s", grpcStatus, resp.Trailer.Get("Grpc-Message"))
+	}
+
+	status, err := decodeGRPCHealthCheckResponse(body)
+	if err != nil {
+		return err
+	}
+
+	if status != healthCheckServing {
+		return fmt.Errorf("gRPC probe failed with serving status: %d", status)
+	}
+
+	return nil
+}
+
+// encodeGRPCHealthCheckRequest returns a length-prefixed HealthCheckRequest{service} message.
+func encodeGRPCHealthCheckRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+		msg = protowire.AppendString(msg, service)
+	}
+
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+
+	return append(frame, msg...)
+}
+
+// decodeGRPCHealthCheckResponse returns the status field of a length-prefixed HealthCheckResponse message.
+func decodeGRPCHealthCheckResponse(frame []byte) (uint64, error) {
+	if len(frame) < 5 {
+		return 0, fmt.Errorf("gRPC response is too short")
+	}
+
+	if frame[0] != 0 {
+		return 0, fmt.Errorf("compressed gRPC responses are not supported")
+	}
+
+	msg := frame[5:]
+	if length := binary.BigEndian.Uint32(frame[1:5]); int(length) > len(msg) {
+		return 0, fmt.Errorf("gRPC response is truncated")
+	} else {
+		msg = msg[:length]
+	}
+
+	var status uint64
+
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(msg)
+			if n < 0 {
+				return 0, protowire.ParseError(n)
+			}
+			status, msg = v, msg[n:]
+
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if n < 0 {
+			return 0, protowire.ParseError(n)
+		}
+		msg = msg[n:]
+	}
+
+	return status, nil
+}
+
+// resolvePort returns the numeric value of a probe port, looking up named ports in the container spec.
+func (p *prober) resolvePort(port intstr.IntOrString) (int, error) {
+	if port.Type == intstr.Int {
+		return port.IntValue(), nil
+	}
+
+	for _, containerPort := range p.container.Ports {
+		if containerPort.Name == port.StrVal {
+			return int(containerPort.ContainerPort), nil
+		}
+	}
+
+	if value, err := strconv.Atoi(port.StrVal); err == nil {
+		return value, nil
+	}
+
+	return 0, fmt.Errorf("port '%s' is not found in container %s", port.StrVal, p.container.Name)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testProber(t *testing.T, rawURL string) (*prober, int) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatalf("split host: %v", err)
+	}
+
+	port, _ := strconv.Atoi(portStr)
+
+	container := &v1.Container{
+		Name:  "test-container",
+		Ports: []v1.ContainerPort{{Name: "http", ContainerPort: int32(port)}},
+	}
+
+	return &prober{pod: &v1.Pod{}, container: container, podIP: host}, port
+}
+
+func TestProbeThreshold(t *testing.T) {
+	threshold := newProbeThreshold(&v1.Probe{SuccessThreshold: 2, FailureThreshold: 2})
+
+	steps := []struct {
+		success bool
+		result  bool
+		decided bool
+	}{
+		{true, true, false},
+		{false, false, false},
+		{true, true, false},
+		{true, true, true},
+		{false, false, false},
+		{false, false, true},
+		{false, false, true},
+	}
+
+	for i, step := range steps {
+		result, decided := threshold.observe(step.success)
+		if result != step.result || decided != step.decided {
+			t.Errorf("step %d: got (%v, %v), want (%v, %v)", i, result, decided, step.result, step.decided)
+		}
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || r.Header.Get("X-Custom") != "yes" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p, _ := testProber(t, server.URL)
+
+	probe := &v1.Probe{ProbeHandler: v1.ProbeHandler{HTTPGet: &v1.HTTPGetAction{
+		Path:        "/healthz",
+		Port:        intstr.FromString("http"),
+		HTTPHeaders: []v1.HTTPHeader{{Name: "X-Custom", Value: "yes"}},
+	}}}
+
+	if err := p.probe(context.Background(), probe, time.Second); err != nil {
+		t.Errorf("expected probe to succeed: %v", err)
+	}
+
+	probe.HTTPGet.Path = "/other"
+	if err := p.probe(context.Background(), probe, time.Second); err == nil {
+		t.Errorf("expected probe to fail")
+	}
+}
+
+func TestTCPProbe(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+
+	p, port := testProber(t, server.URL)
+
+	probe := &v1.Probe{ProbeHandler: v1.ProbeHandler{TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt32(int32(port))}}}
+
+	if err := p.probe(context.Background(), probe, time.Second); err != nil {
+		t.Errorf("expected probe to succeed: %v", err)
+	}
+
+	server.Close()
+
+	if err := p.probe(context.Background(), probe, time.Second); err == nil {
+		t.Errorf("expected probe to fail on a closed port")
+	}
+}
+
+func TestExecProbe(t *testing.T) {
+	workingDir := t.TempDir()
+	scratch := filepath.Join(workingDir, "scratch")
+
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Namespace:   "default",
+		Name:        "probe-test",
+		Annotations: map[string]string{"workingDirectory": workingDir},
+	}}
+
+	p := newProber(pod, &v1.Container{Name: "app"})
+
+	// the probe fails until the container is running.
+	probe := &v1.Probe{ProbeHandler: v1.ProbeHandler{Exec: &v1.ExecAction{Command: []string{"test", "-f", scratch + "/file"}}}}
+
+	if err := p.probe(context.Background(), probe, time.Second); err == nil {
+		t.Errorf("expected probe to fail before the container is started")
+	}
+
+	startNamespacedContainer(t, p.containerPath, scratch)
+
+	// the file is only visible within the running container.
+	if err := p.probe(context.Background(), probe, 5*time.Second); err != nil {
+		t.Errorf("expected probe to succeed: %v", err)
+	}
+
+	probe.Exec.Command = []string{"test", "-f", scratch + "/missing"}
+
+	if err := p.probe(context.Background(), probe, 5*time.Second); err == nil {
+		t.Errorf("expected probe to fail")
+	}
+}
+
+func TestGRPCHealthCheckEncoding(t *testing.T) {
+	req := encodeGRPCHealthCheckRequest("svc")
+	if want := []byte{0, 0, 0, 0, 5, 0x0a, 3, 's', 'v', 'c'}; string(req) != string(want) {
+		t.Errorf("got request %v, want %v", req, want)
+	}
+
+	status, err := decodeGRPCHealthCheckResponse([]byte{0, 0, 0, 0, 2, 0x08, 1})
+	if err != nil || status != 1 {
+		t.Errorf("got status (%d, %v), want SERVING", status, err)
+	}
+
+	if _, err := decodeGRPCHealthCheckResponse([]byte{0, 0, 0, 0, 4, 0x08}); err == nil {
+		t.Errorf("expected truncated response to fail")
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/podhandler"
+	"hpk/internal/compute/slurm"
+	"hpk/internal/compute/usage"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// usageInterval is how often the resource usage of the containers is sampled.
+// It matches the resolution of metrics-server.
+const usageInterval = 10 * time.Second
+
+// reportUsage periodically samples the resource usage of the running containers, and writes it to the
+// pod directory, from where hpk-kubelet serves the statistics of the pod. It returns when the context is cancelled.
+func reportUsage(ctx context.Context, pod *v1.Pod) {
+	podPath := endpoint.HPK(pod.Annotations["workingDirectory"]).Pod(client.ObjectKeyFromObject(pod))
+
+	previous := make(map[string]*usage.ContainerUsage)
+
+	ticker := time.NewTicker(usageInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, name := range sampledContainers(pod) {
+			containerPath := podPath.Container(name)
+
+			sample, err := sampleContainer(containerPath, previous[name])
+			if err != nil {
+				// the container is not running. Stale samples are ignored by hpk-kubelet.
+				delete(previous, name)
+				continue
+			}
+
+			if err := usage.Write(containerPath.UsagePath(), sample); err != nil {
+				log.Warn().Err(err).Msgf("Failed to write the resource usage of container %s", name)
+			}
+
+			previous[name] = &sample
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sampledContainers returns the containers that run in processes of their own. Regular init containers run
+// in the foreground of hpk-pause, and are not accounted, as in the kubelet.
+func sampledContainers(pod *v1.Pod) []string {
+	var names []string
+
+	for i := range pod.Spec.InitContainers {
+		if podhandler.IsSidecar(&pod.Spec.InitContainers[i]) {
+			names = append(names, pod.Spec.InitContainers[i].Name)
+		}
+	}
+
+	for _, container := range pod.Spec.Containers {
+		names = append(names, container.Name)
+	}
+
+	return names
+}
+
+// sampleContainer measures the process tree that is referenced by the pid:// id of the container.
+func sampleContainer(containerPath endpoint.ContainerPath, previous *usage.ContainerUsage) (usage.ContainerUsage, error) {
+	id, err := slurm.GetPIDFromFile(containerPath.IDPath())
+	if err != nil {
+		return usage.ContainerUsage{}, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimPrefix(id, string(slurm.JobIDTypeProcess)))
+	if err != nil {
+		return usage.ContainerUsage{}, err
+	}
+
+	// the pid file outlives the container, which has terminated once its exit code is recorded.
+	if _, err := os.Stat(containerPath.ExitCodePath()); err == nil {
+		return usage.ContainerUsage{}, os.ErrNotExist
+	}
+
+	return usage.Sample(pid, previous)
+}
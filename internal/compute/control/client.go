@@ -0,0 +1,179 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Streams are the client-side ends of a session. Nil streams are not forwarded.
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Resize delivers the changes of the terminal size, for TTY sessions.
+	Resize <-chan TerminalSize
+}
+
+// ExitError is returned when the command of a session exits with a non-zero code.
+// It satisfies the ExitError interface of k8s.io/utils/exec, so that the code is reported to kubectl.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command terminated with exit code %d", e.Code)
+}
+
+func (e *ExitError) String() string {
+	return e.Error()
+}
+
+func (e *ExitError) Exited() bool {
+	return true
+}
+
+func (e *ExitError) ExitStatus() int {
+	return e.Code
+}
+
+// Dial connects to the control socket at the given path.
+func Dial(ctx context.Context, path string) (*Conn, error) {
+	var conn net.Conn
+
+	err := withSocketPath(path, func(addr string) (err error) {
+		var dialer net.Dialer
+
+		conn, err = dialer.DialContext(ctx, "unix", addr)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the control socket '%s': %w", path, err)
+	}
+
+	return NewConn(conn), nil
+}
+
+// Run opens a session on the control socket at the given path, and streams the data between the
+// session and the given streams, until the command has exited or the context is cancelled.
+func Run(ctx context.Context, path string, req Request, streams Streams) error {
+	conn, err := Dial(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	return conn.Run(ctx, req, streams)
+}
+
+// Run opens a session on the connection, as described by Run. The connection is closed on return.
+func (c *Conn) Run(ctx context.Context, req Request, streams Streams) error {
+	defer c.Close()
+
+	// unblock the reading of frames once the context is cancelled.
+	stop := context.AfterFunc(ctx, func() { _ = c.Close() })
+	defer stop()
+
+	if err := c.WriteJSON(FrameRequest, req); err != nil {
+		return fmt.Errorf("cannot send request: %w", err)
+	}
+
+	/*-- Forward the input and the resize events --*/
+	done := make(chan struct{})
+	defer close(done)
+
+	if streams.Stdin != nil {
+		go func() {
+			if _, err := io.Copy(c.Writer(FrameStdin), streams.Stdin); err == nil {
+				_ = c.WriteFrame(FrameStdinClose, nil)
+			}
+		}()
+	}
+
+	if streams.Resize != nil {
+		go func() {
+			for {
+				select {
+				case size, ok := <-streams.Resize:
+					if !ok {
+						return
+					}
+
+					if err := c.WriteFrame(FrameResize, EncodeResize(size)); err != nil {
+						return
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	/*-- Receive the output until the command exits --*/
+	for {
+		frameType, payload, err := c.ReadFrame()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if errors.Is(err, io.EOF) {
+				return errors.New("control socket was closed before the command exited")
+			}
+
+			return err
+		}
+
+		switch frameType {
+		case FrameStdout:
+			if streams.Stdout != nil {
+				if _, err := streams.Stdout.Write(payload); err != nil {
+					return err
+				}
+			}
+
+		case FrameStderr:
+			if streams.Stderr != nil {
+				if _, err := streams.Stderr.Write(payload); err != nil {
+					return err
+				}
+			}
+
+		case FrameExit:
+			var status ExitStatus
+			if err := json.Unmarshal(payload, &status); err != nil {
+				return fmt.Errorf("invalid exit status: %w", err)
+			}
+
+			if status.Error != "" {
+				return errors.New(status.Error)
+			}
+
+			if status.Code != 0 {
+				return &ExitError{Code: status.Code}
+			}
+
+			return nil
+
+		default:
+			return fmt.Errorf("unexpected frame type %d", frameType)
+		}
+	}
+}
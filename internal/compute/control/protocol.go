@@ -0,0 +1,279 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package control implements the protocol of the control socket that hpk-pause exposes in the pod directory,
+// through which hpk-kubelet runs commands in the containers of the pod, and attaches to their streams.
+//
+// A session starts with a Request frame from the client. Then, the client sends the stdin and resize frames,
+// and the server sends the stdout and stderr frames, until the server sends an exit frame and closes the session.
+// Every frame is encoded as a 1-byte type, followed by a 4-byte (big endian) length and the payload.
+package control
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type FrameType byte
+
+const (
+	// FrameRequest carries the JSON-encoded Request that opens a session (client to server).
+	FrameRequest FrameType = iota + 1
+
+	// FrameStdin carries input for the command (client to server).
+	FrameStdin
+
+	// FrameStdinClose marks the end of the input (client to server).
+	FrameStdinClose
+
+	// FrameResize carries the new width and height of the terminal, as 2-byte integers (client to server).
+	FrameResize
+
+	// FrameStdout carries output of the command (server to client).
+	FrameStdout
+
+	// FrameStderr carries error output of the command (server to client).
+	FrameStderr
+
+	// FrameExit carries the JSON-encoded ExitStatus of the command, and ends the session (server to client).
+	FrameExit
+)
+
+// MaxFrameSize is the maximum payload of a frame. Larger writes are split into multiple frames.
+const MaxFrameSize = 64 * 1024
+
+// maxSocketPathLen is the maximum length of a path that fits in sockaddr_un.sun_path.
+const maxSocketPathLen = 107
+
+const (
+	// OperationExec runs a new command in the context of a container.
+	OperationExec = "exec"
+
+	// OperationAttach connects to the stdin (or tty) and the output of a running container.
+	OperationAttach = "attach"
+)
+
+// Request describes the operation of a session.
+type Request struct {
+	Operation string   `json:"operation"`
+	Container string   `json:"container"`
+	Command   []string `json:"command,omitempty"`
+
+	Stdin  bool `json:"stdin,omitempty"`
+	Stdout bool `json:"stdout,omitempty"`
+	Stderr bool `json:"stderr,omitempty"`
+	TTY    bool `json:"tty,omitempty"`
+}
+
+// ExitStatus describes how a session has ended. Error is set if the command could not be run at all.
+type ExitStatus struct {
+	Code  int    `json:"code"`
+	Error string `json:"error,omitempty"`
+}
+
+// TerminalSize is the size of the terminal of a TTY session.
+type TerminalSize struct {
+	Width  uint16
+	Height uint16
+}
+
+// EncodeResize encodes a terminal size as the payload of a resize frame.
+func EncodeResize(size TerminalSize) []byte {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint16(payload[0:2], size.Width)
+	binary.BigEndian.PutUint16(payload[2:4], size.Height)
+
+	return payload
+}
+
+// DecodeResize decodes the payload of a resize frame.
+func DecodeResize(payload []byte) (TerminalSize, error) {
+	if len(payload) != 4 {
+		return TerminalSize{}, fmt.Errorf("invalid resize frame of %d bytes", len(payload))
+	}
+
+	return TerminalSize{
+		Width:  binary.BigEndian.Uint16(payload[0:2]),
+		Height: binary.BigEndian.Uint16(payload[2:4]),
+	}, nil
+}
+
+/*---------------------------------------------------
+ * Framing
+ *---------------------------------------------------*/
+
+// Conn exchanges frames over a connection to the control socket.
+// Frames can be written concurrently, but they must be read by a single goroutine.
+type Conn struct {
+	mu sync.Mutex
+	rw io.ReadWriteCloser
+}
+
+func NewConn(rw io.ReadWriteCloser) *Conn {
+	return &Conn{rw: rw}
+}
+
+// WriteFrame writes a single frame. The payload must not exceed MaxFrameSize.
+func (c *Conn) WriteFrame(frameType FrameType, payload []byte) error {
+	if len(payload) > MaxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds the maximum size", len(payload))
+	}
+
+	header := make([]byte, 5)
+	header[0] = byte(frameType)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+
+	_, err := c.rw.Write(payload)
+
+	return err
+}
+
+// ReadFrame reads the next frame.
+func (c *Conn) ReadFrame() (FrameType, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.rw, header); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxFrameSize {
+		return 0, nil, fmt.Errorf("frame of %d bytes exceeds the maximum size", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+
+	return FrameType(header[0]), payload, nil
+}
+
+// WriteJSON writes a frame with the JSON encoding of v.
+func (c *Conn) WriteJSON(frameType FrameType, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.WriteFrame(frameType, payload)
+}
+
+// ReadRequest reads the Request that opens a session.
+func (c *Conn) ReadRequest() (Request, error) {
+	var req Request
+
+	frameType, payload, err := c.ReadFrame()
+	if err != nil {
+		return req, err
+	}
+
+	if frameType != FrameRequest {
+		return req, fmt.Errorf("expected a request frame, got %d", frameType)
+	}
+
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return req, fmt.Errorf("invalid request: %w", err)
+	}
+
+	return req, nil
+}
+
+// Writer returns a writer that sends its input as frames of the given type.
+func (c *Conn) Writer(frameType FrameType) io.Writer {
+	return &frameWriter{conn: c, frameType: frameType}
+}
+
+func (c *Conn) Close() error {
+	return c.rw.Close()
+}
+
+type frameWriter struct {
+	conn      *Conn
+	frameType FrameType
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for written < len(p) {
+		chunk := p[written:min(len(p), written+MaxFrameSize)]
+
+		if err := w.conn.WriteFrame(w.frameType, chunk); err != nil {
+			return written, err
+		}
+
+		written += len(chunk)
+	}
+
+	return written, nil
+}
+
+/*---------------------------------------------------
+ * Socket
+ *---------------------------------------------------*/
+
+// Listen creates the control socket at the given path, replacing any stale socket of a previous run.
+// The socket is accessible only by its owner.
+func Listen(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var listener net.Listener
+
+	err := withSocketPath(path, func(addr string) (err error) {
+		listener, err = net.Listen("unix", addr)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+
+		return nil, err
+	}
+
+	return listener, nil
+}
+
+// withSocketPath invokes f with an address for the socket at path. Paths that do not fit in sun_path
+// (which is common for deep pod directories) are addressed relative to a descriptor of their directory.
+func withSocketPath(path string, f func(addr string) error) error {
+	if len(path) <= maxSocketPathLen {
+		return f(path)
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return f(fmt.Sprintf("/proc/self/fd/%d/%s", dir.Fd(), filepath.Base(path)))
+}
@@ -0,0 +1,158 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (b *bufferConn) Close() error { return nil }
+
+func TestFrameRoundTrip(t *testing.T) {
+	conn := NewConn(&bufferConn{})
+
+	large := bytes.Repeat([]byte("x"), MaxFrameSize+10)
+
+	if _, err := conn.Writer(FrameStdout).Write(large); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := conn.WriteFrame(FrameResize, EncodeResize(TerminalSize{Width: 80, Height: 24})); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	/*-- Large writes are split into multiple frames --*/
+	var output []byte
+
+	for _, expectedSize := range []int{MaxFrameSize, 10} {
+		frameType, payload, err := conn.ReadFrame()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+
+		if frameType != FrameStdout || len(payload) != expectedSize {
+			t.Fatalf("got frame %d of %d bytes, want %d of %d bytes", frameType, len(payload), FrameStdout, expectedSize)
+		}
+
+		output = append(output, payload...)
+	}
+
+	if !bytes.Equal(output, large) {
+		t.Errorf("payload was not preserved")
+	}
+
+	frameType, payload, err := conn.ReadFrame()
+	if err != nil || frameType != FrameResize {
+		t.Fatalf("expected resize frame, got %d, %v", frameType, err)
+	}
+
+	if size, err := DecodeResize(payload); err != nil || size != (TerminalSize{Width: 80, Height: 24}) {
+		t.Errorf("unexpected size %+v, %v", size, err)
+	}
+
+	if _, _, err := conn.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+// serveEcho accepts a single session, which echoes the input to stdout in upper case,
+// writes the command to stderr, and exits with the given code.
+func serveEcho(t *testing.T, listener net.Listener, code int) {
+	t.Helper()
+
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		conn := NewConn(c)
+		defer conn.Close()
+
+		req, err := conn.ReadRequest()
+		if err != nil {
+			t.Errorf("read request: %v", err)
+			return
+		}
+
+		_, _ = conn.Writer(FrameStderr).Write([]byte(strings.Join(req.Command, " ")))
+
+		for {
+			frameType, payload, err := conn.ReadFrame()
+			if err != nil {
+				t.Errorf("read frame: %v", err)
+				return
+			}
+
+			if frameType == FrameStdinClose {
+				break
+			}
+
+			_, _ = conn.Writer(FrameStdout).Write(bytes.ToUpper(payload))
+		}
+
+		_ = conn.WriteJSON(FrameExit, ExitStatus{Code: code})
+	}()
+}
+
+func TestRun(t *testing.T) {
+	// deep directories exceed the maximum length of socket paths.
+	dir := filepath.Join(t.TempDir(), strings.Repeat("d", 60), strings.Repeat("d", 60))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	socketPath := filepath.Join(dir, "control.sock")
+
+	listener, err := Listen(socketPath)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	if info, err := os.Stat(socketPath); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("unexpected socket: %v, %v", info, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	/*-- Successful command --*/
+	serveEcho(t, listener, 0)
+
+	var stdout, stderr bytes.Buffer
+
+	err = Run(ctx, socketPath, Request{Operation: OperationExec, Command: []string{"echo", "hi"}}, Streams{
+		Stdin:  strings.NewReader("hello"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if stdout.String() != "HELLO" || stderr.String() != "echo hi" {
+		t.Errorf("unexpected output %q, %q", stdout.String(), stderr.String())
+	}
+
+	/*-- Failed command --*/
+	serveEcho(t, listener, 3)
+
+	err = Run(ctx, socketPath, Request{Operation: OperationExec}, Streams{Stdin: strings.NewReader("")})
+
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("expected exit code 3, got %v", err)
+	}
+}
@@ -46,6 +46,19 @@ const (
 
 	// ExtensionJobID describes the file  where the sbatch script will write its job id.
 	ExtensionJobID ControlFileType = ".jobid"
+
+	// ExtensionStarted describes the file where the pause container marks that the startup probe has succeeded.
+	ExtensionStarted ControlFileType = ".started"
+
+	// ExtensionReady describes the file where the pause container writes the result of the readiness probe.
+	ExtensionReady ControlFileType = ".ready"
+
+	// ExtensionRestartCount describes the file where the pause container writes how many times a container has been restarted.
+	ExtensionRestartCount ControlFileType = ".restartCount"
+
+	// ExtensionLastTermination describes the file where the pause container writes why the last instance of a
+	// restarted container has terminated, as "<reason> <exit code>". It is written before the restart count.
+	ExtensionLastTermination ControlFileType = ".lastTermination"
 )
 
 // Pod-Related Extensions
@@ -67,6 +80,12 @@ const (
 
 	// ExtensionLogs describes the file  where the sbatch script will write its logs.
 	ExtensionLogs = ".logs"
+
+	// ExtensionTerminationLog describes the file where the container will write its termination message.
+	ExtensionTerminationLog = ".termination-log"
+
+	// ExtensionUsage describes the file where the pause container writes the resource usage of the container.
+	ExtensionUsage = ".usage"
 )
 
 type HPKPath string
@@ -185,16 +204,12 @@ func (p PodPath) String() string {
 
 // PodEnvironmentIsOK checks if the pod structure is ok, and if it is not, it returns an indiciate reason
 func (p PodPath) PodEnvironmentIsOK() (bool, string) {
-	// check that there is a valid pod description
-	if _, err := os.Open(p.EncodedJSONPath()); err != nil {
+	// check that there is a valid pod description.
+	// pods that have failed with a system error are still valid, and are reported as failed on recovery.
+	if _, err := os.Stat(p.EncodedJSONPath()); err != nil {
 		return false, "no pod specification was found"
 	}
 
-	// check if the pod is already failed
-	if _, err := os.Open(p.SysErrorFilePath()); !os.IsNotExist(err) {
-		return false, "pod has failed with a system error"
-	}
-
 	return true, ""
 }
 
@@ -215,6 +230,11 @@ func (p PodPath) ControlFileDir() string {
 	return filepath.Join(string(p), "controlfiles")
 }
 
+// ControlSocketPath points to the UNIX socket through which hpk-pause runs commands in the containers of the pod.
+func (p PodPath) ControlSocketPath() string {
+	return filepath.Join(string(p), "control.sock")
+}
+
 // EncodedJSONPath .hpk/namespace/podName/.virtualenv/pod.crd
 func (p PodPath) EncodedJSONPath() string {
 	return filepath.Join(p.JobDir(), "pod"+ExtensionCRD)
@@ -276,6 +296,21 @@ func (c ContainerPath) LogsPath() string {
 	return filepath.Join(c.p.LogDir(), c.containerName+ExtensionLogs)
 }
 
+// PreviousLogsPath points to the logs of the previous instance of a restarted container.
+func (c ContainerPath) PreviousLogsPath() string {
+	return filepath.Join(c.p.LogDir(), c.containerName+".previous"+ExtensionLogs)
+}
+
+// TerminationMessagePath points to the file that is bound to the terminationMessagePath of the container.
+func (c ContainerPath) TerminationMessagePath() string {
+	return filepath.Join(c.p.LogDir(), c.containerName+ExtensionTerminationLog)
+}
+
+// PreviousTerminationMessagePath points to the termination message of the previous instance of a restarted container.
+func (c ContainerPath) PreviousTerminationMessagePath() string {
+	return filepath.Join(c.p.LogDir(), c.containerName+".previous"+ExtensionTerminationLog)
+}
+
 func (c ContainerPath) IDPath() string {
 	return filepath.Join(c.p.ControlFileDir(), c.containerName+string(ExtensionJobID))
 }
@@ -284,6 +319,22 @@ func (c ContainerPath) ExitCodePath() string {
 	return filepath.Join(c.p.ControlFileDir(), c.containerName+string(ExtensionExitCode))
 }
 
+func (c ContainerPath) StartedPath() string {
+	return filepath.Join(c.p.ControlFileDir(), c.containerName+string(ExtensionStarted))
+}
+
+func (c ContainerPath) ReadinessPath() string {
+	return filepath.Join(c.p.ControlFileDir(), c.containerName+string(ExtensionReady))
+}
+
+func (c ContainerPath) RestartCountPath() string {
+	return filepath.Join(c.p.ControlFileDir(), c.containerName+string(ExtensionRestartCount))
+}
+
+func (c ContainerPath) LastTerminationPath() string {
+	return filepath.Join(c.p.ControlFileDir(), c.containerName+string(ExtensionLastTermination))
+}
+
 /*
 	Container-Related paths not captured by Slurm Notifier.
 	They are needed for HPK to bootstrap a container.
@@ -292,3 +343,9 @@ func (c ContainerPath) ExitCodePath() string {
 func (c ContainerPath) EnvFilePath() string {
 	return filepath.Join(c.p.JobDir(), c.containerName+ExtensionEnvironment)
 }
+
+// UsagePath points to the resource usage of the container. It is kept out of the control files,
+// because it is updated periodically and must not trigger pod events.
+func (c ContainerPath) UsagePath() string {
+	return filepath.Join(c.p.JobDir(), c.containerName+ExtensionUsage)
+}
@@ -15,9 +15,14 @@
 package compute
 
 import (
+	"context"
+
 	"hpk/internal/compute/endpoint"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -40,6 +45,16 @@ type HostEnvironment struct {
 
 	// PauseImage is the image used for the pause container.
 	PauseImage string
+
+	// ContainerLogMaxSize is the maximum size (e.g, 10Mi) of a container log file before it is rotated.
+	ContainerLogMaxSize string
+
+	// ContainerLogMaxFiles is the maximum number of log files that can be present for a container.
+	ContainerLogMaxFiles int
+
+	// ServiceProxy indicates that ClusterIPs are reachable from the Slurm nodes (e.g, through kube-proxy).
+	// Otherwise, service variables point to the DNS names and the target ports of the services.
+	ServiceProxy bool
 }
 
 // The VirtualEnvironment create lightweight "virtual environments" that resemble "Pods" semantics.
@@ -53,6 +68,9 @@ type VirtualEnvironment struct {
 	// ConstructorFilePath points to the script for creating the virtual environment for Pod.
 	ConstructorFilePath string
 
+	// EncodedJSONPath points to the pod definition, from which the pause container reads the pod.
+	EncodedJSONPath string
+
 	// IPAddressPath is where we store the internal Pod's ip.
 	IPAddressPath string
 
@@ -78,3 +96,39 @@ var (
 
 	HPK endpoint.HPKPath
 )
+
+// Listers serve the objects that are referenced by pods from the informer caches of hpk-kubelet.
+// If a lister is not set, the objects are fetched from the API server.
+var (
+	ConfigMapLister corelisters.ConfigMapLister
+	SecretLister    corelisters.SecretLister
+	ServiceLister   corelisters.ServiceLister
+)
+
+// GetConfigMap returns a configMap from the informer cache, or from the API server if there is no cache.
+func GetConfigMap(ctx context.Context, namespace, name string) (*corev1.ConfigMap, error) {
+	if ConfigMapLister != nil {
+		return ConfigMapLister.ConfigMaps(namespace).Get(name)
+	}
+
+	var configMap corev1.ConfigMap
+	if err := K8SClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &configMap); err != nil {
+		return nil, err
+	}
+
+	return &configMap, nil
+}
+
+// GetSecret returns a secret from the informer cache, or from the API server if there is no cache.
+func GetSecret(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	if SecretLister != nil {
+		return SecretLister.Secrets(namespace).Get(name)
+	}
+
+	var secret corev1.Secret
+	if err := K8SClient.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+		return nil, err
+	}
+
+	return &secret, nil
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 
 	"errors"
+	"hpk/internal/compute/metrics"
 	"hpk/pkg/crdtools"
 
 	corev1 "k8s.io/api/core/v1"
@@ -58,6 +59,8 @@ func SystemPanic(err error, errFormat string, errArgs ...any) {
 
 	DefaultLogger.Error(werr, "SystemERROR")
 
+	metrics.SystemPanic()
+
 	//[TODO:] reinstate it after debugging
 	//panic(werr)
 }
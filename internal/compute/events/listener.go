@@ -16,6 +16,7 @@ package events
 
 import (
 	"context"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"sync"
@@ -23,6 +24,7 @@ import (
 	"errors"
 	"hpk/internal/compute"
 	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/metrics"
 
 	"github.com/fsnotify/fsnotify"
 	corev1 "k8s.io/api/core/v1"
@@ -80,7 +82,7 @@ func (h *EventHandler) Push(event fsnotify.Event) {
 
 type PodControl struct {
 	UpdateStatus         func(pod *corev1.Pod)
-	LoadFromDisk         func(podRef client.ObjectKey) (*corev1.Pod, error)
+	UpdateOnDisk         func(podRef client.ObjectKey, update func(pod *corev1.Pod) error) error
 	NotifyVirtualKubelet func(pod *corev1.Pod)
 }
 
@@ -108,8 +110,8 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 
 					return
 				case event := <-h.Queue:
-					// filter events other than creations.
-					if !event.Op.Has(fsnotify.Create) {
+					// filter events other than creations, except for control files that are updated in-place.
+					if !(event.Op.Has(fsnotify.Create) || (event.Op.Has(fsnotify.Write) && isMutableControlFile(event.Name))) {
 						compute.DefaultLogger.Info("SLURM: omit non-create event", "details", event)
 
 						// return from select
@@ -146,12 +148,6 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 						/*-- Sbatch failed. Pod should fail immediately without other checks --*/
 						logger.Info("[Slurm] -> Pod initialization error", "op", event.Op, "file", file)
 
-						// load local pod
-						pod, err := control.LoadFromDisk(podkey)
-						if err != nil {
-							compute.SystemPanic(err, "pod '%s' does not exist", podkey)
-						}
-
 						// get failure reason
 						sysErrFile := compute.HPK.Pod(podkey).SysErrorFilePath()
 
@@ -163,11 +159,32 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 						// FIXME: print only the last few lined
 						logger.Info("[SYSERROR]", "details", string(reason))
 
-						// set the pod as failed
-						compute.PodError(pod, "SYSERROR", "Pod creation has failed")
+						// set the pod as failed, on the local copy, so that the next events see the failure
+						var (
+							failed     *corev1.Pod
+							terminated bool
+						)
+
+						if err := control.UpdateOnDisk(podkey, func(pod *corev1.Pod) error {
+							terminated = !isTerminal(pod.Status.Phase)
+
+							compute.PodError(pod, "SYSERROR", "Pod creation has failed")
+
+							failed = pod
+
+							return nil
+						}); err != nil {
+							compute.SystemPanic(err, "failed to save pod '%s'", podkey)
+
+							continue
+						}
+
+						if terminated {
+							metrics.PodTerminated(podkey.Namespace, false)
+						}
 
 						// update the remote copy
-						control.NotifyVirtualKubelet(pod)
+						control.NotifyVirtualKubelet(failed)
 
 						continue
 
@@ -177,9 +194,20 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 					case endpoint.ExtensionJobID: // Container Started
 						logger.Info("[Slurm] -> Container Started", "op", event.Op, "file", file)
 
+						metrics.ObserveContainerID(podkey)
+
 					case endpoint.ExtensionExitCode: // Container Terminated
 						logger.Info("[Slurm] -> Container Terminated", "op", event.Op, "file", file)
 
+					case endpoint.ExtensionStarted: // Container passed its startup probe
+						logger.Info("[Slurm] -> Container Started (startup probe)", "op", event.Op, "file", file)
+
+					case endpoint.ExtensionReady: // Container changed its readiness
+						logger.Info("[Slurm] -> Container Readiness Changed", "op", event.Op, "file", file)
+
+					case endpoint.ExtensionRestartCount: // Container restarted due to failed liveness probe
+						logger.Info("[Slurm] -> Container Restarted", "op", event.Op, "file", file)
+
 					default:
 						/*-- Any other file is ignored --*/
 						compute.DefaultLogger.Info("Ignore event", "details", event)
@@ -193,9 +221,30 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 					 * Reconcile Pod and Notify Virtual Kubelet
 					 *---------------------------------------------------*/
 
-					/*-- Load Pod from reference --*/
-					pod, err := control.LoadFromDisk(podkey)
-					if err != nil {
+					/*-- Recalculate the Pod status from locally stored containers, and update the local Copy --*/
+					var (
+						pod           *corev1.Pod
+						previousPhase corev1.PodPhase
+					)
+
+					if err := control.UpdateOnDisk(podkey, func(local *corev1.Pod) error {
+						previousPhase = local.Status.Phase
+
+						if isTerminal(previousPhase) {
+							// TODO: Should I remove the watcher now, or when the pod is deleted ?
+
+							logger.Info("Ignore event since Pod is in terminal phase",
+								"event", event,
+								"phase", previousPhase,
+							)
+						}
+
+						control.UpdateStatus(local)
+
+						pod = local
+
+						return nil
+					}); errors.Is(err, fs.ErrNotExist) {
 						// Race conditions may between the deletion of a pod and Slurm events.
 						logger.Info("Omit event",
 							"reason", "pod was not found. this is probably a conflict",
@@ -203,19 +252,15 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 						)
 
 						continue
-					}
-
-					if pod.Status.Phase == corev1.PodFailed || pod.Status.Phase == corev1.PodSucceeded {
-						// TODO: Should I remove the watcher now, or when the pod is deleted ?
+					} else if err != nil {
+						compute.SystemPanic(err, "failed to save pod '%s'", podkey)
 
-						logger.Info("Ignore event since Pod is in terminal phase",
-							"event", event,
-							"phase", pod.Status.Phase,
-						)
+						continue
 					}
 
-					/*-- Recalculate the Pod status from locally stored containers --*/
-					control.UpdateStatus(pod)
+					if !isTerminal(previousPhase) && isTerminal(pod.Status.Phase) {
+						metrics.PodTerminated(podkey.Namespace, pod.Status.Phase == corev1.PodSucceeded)
+					}
 
 					/*-- Update the remote Copy --*/
 					control.NotifyVirtualKubelet(pod)
@@ -228,3 +273,18 @@ func (h *EventHandler) Listen(ctx context.Context, control PodControl) {
 		waitGroup.Wait()
 	}
 }
+
+func isTerminal(phase corev1.PodPhase) bool {
+	return phase == corev1.PodFailed || phase == corev1.PodSucceeded
+}
+
+// isMutableControlFile returns true for control files that the pause container overwrites
+// throughout the lifetime of a container, and therefore generate Write events after their creation.
+func isMutableControlFile(path string) bool {
+	switch filepath.Ext(path) {
+	case endpoint.ExtensionJobID, endpoint.ExtensionStarted, endpoint.ExtensionReady, endpoint.ExtensionRestartCount:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,89 @@
+package events
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/metrics"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestTerminatedPodIsCountedOnce(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	podKey := client.ObjectKey{Namespace: "test-events", Name: "pod"}
+
+	/*-- The pod is stored in memory, in place of the pod store --*/
+	var mu sync.Mutex
+
+	stored := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: podKey.Namespace, Name: podKey.Name},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	notified := make(chan corev1.PodPhase, 2)
+
+	control := PodControl{
+		// the container has exited, so every reconciliation finds the pod succeeded.
+		UpdateStatus: func(pod *corev1.Pod) { pod.Status.Phase = corev1.PodSucceeded },
+		UpdateOnDisk: func(_ client.ObjectKey, update func(pod *corev1.Pod) error) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			pod := stored.DeepCopy()
+
+			if err := update(pod); err != nil {
+				return err
+			}
+
+			stored = pod
+
+			return nil
+		},
+		NotifyVirtualKubelet: func(pod *corev1.Pod) { notified <- pod.Status.Phase },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := NewEventHandler(Options{MaxWorkers: 1, MaxQueueSize: 10})
+
+	go h.Listen(ctx, control)
+
+	/*-- Two control files of the terminated pod are created --*/
+	podPath := compute.HPK.Pod(podKey)
+
+	h.Push(fsnotify.Event{Name: podPath.Container("main").ExitCodePath(), Op: fsnotify.Create})
+	h.Push(fsnotify.Event{Name: podPath.Container("sidecar").ExitCodePath(), Op: fsnotify.Create})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-notified:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the events have not been handled")
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := `hpk_pods_terminated_total{namespace="test-events",outcome="success"} 1`; !strings.Contains(string(body), expected) {
+		t.Errorf("metric '%s' is missing", expected)
+	}
+}
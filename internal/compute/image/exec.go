@@ -15,6 +15,10 @@
 package image
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
 	"hpk/internal/compute"
 	"hpk/pkg/process"
 )
@@ -36,3 +40,19 @@ func (p *Image) FakerootExec(apptainerArgs []string, cmd []string) (string, erro
 
 	return string(out), err
 }
+
+// Version returns the version of Apptainer (e.g, "1.1.3"), as reported by `apptainer --version`.
+func Version(ctx context.Context) (string, error) {
+	out, err := process.ExecuteContext(ctx, compute.Environment.ApptainerBin, "--version")
+	if err != nil {
+		return "", err
+	}
+
+	// the output is in the form of "apptainer version 1.1.3".
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected version output '%s'", out)
+	}
+
+	return fields[len(fields)-1], nil
+}
@@ -18,8 +18,10 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"hpk/internal/compute"
+	"hpk/internal/compute/metrics"
 	"hpk/pkg/process"
 )
 
@@ -55,7 +57,14 @@ func Pull(imageDir string, transport Transport, imageName string) (*Image, error
 
 	// otherwise, download a fresh copy
 	compute.DefaultLogger.Info(" * Downloading image...", "image", imageName, "dir", imageDir)
-	if _, err := process.Execute(compute.Environment.ApptainerBin, "pull", "--dir", imageDir, transport.Wrap(imageName)); err != nil {
+
+	started := time.Now()
+
+	_, err = process.Execute(compute.Environment.ApptainerBin, "pull", "--dir", imageDir, transport.Wrap(imageName))
+
+	metrics.ObserveImagePull(started, err)
+
+	if err != nil {
 		return nil, fmt.Errorf("downloading has failed: %w", err)
 	}
 
@@ -0,0 +1,188 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics exports the internals of the control loop of hpk-kubelet as Prometheus metrics.
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const namespace = "hpk"
+
+// Outcomes of the measured operations.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Registry holds the metrics of HPK, along with the metrics of the Go runtime and the process.
+var Registry = prometheus.NewRegistry()
+
+var (
+	podsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pods_created_total",
+		Help:      "Number of pods that have been materialized and submitted, by outcome.",
+	}, []string{"namespace", "outcome"})
+
+	podsTerminated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pods_terminated_total",
+		Help:      "Number of pods that have reached a terminal phase, by outcome.",
+	}, []string{"namespace", "outcome"})
+
+	submissionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "slurm_submission_duration_seconds",
+		Help:      "Latency of submitting the job of a pod to Slurm.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"namespace", "outcome"})
+
+	scheduleLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "slurm_schedule_latency_seconds",
+		Help:      "Time from the submission of a job to the first container id reported by its pause container.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 14),
+	}, []string{"namespace"})
+
+	imagePullDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_pull_duration_seconds",
+		Help:      "Duration of pulling container images into the image directory.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	}, []string{"outcome"})
+
+	systemPanics = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "system_panics_total",
+		Help:      "Number of system errors that have been raised by SystemPanic.",
+	})
+
+	eventQueueCapacity = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_queue_capacity",
+		Help:      "Capacity of the queue of control file events.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		podsCreated,
+		podsTerminated,
+		submissionDuration,
+		scheduleLatency,
+		imagePullDuration,
+		systemPanics,
+		eventQueueCapacity,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
+
+// PodCreated counts a pod whose creation has either succeeded or failed.
+func PodCreated(namespace string, err error) {
+	podsCreated.WithLabelValues(namespace, outcome(err)).Inc()
+}
+
+// PodTerminated counts a pod that has reached a terminal phase.
+func PodTerminated(namespace string, succeeded bool) {
+	result := OutcomeSuccess
+	if !succeeded {
+		result = OutcomeFailure
+	}
+
+	podsTerminated.WithLabelValues(namespace, result).Inc()
+}
+
+// SystemPanic counts a system error.
+func SystemPanic() {
+	systemPanics.Inc()
+}
+
+// ObserveImagePull records the duration of an image download.
+func ObserveImagePull(started time.Time, err error) {
+	imagePullDuration.WithLabelValues(outcome(err)).Observe(time.Since(started).Seconds())
+}
+
+/*---------------------------------------------------
+ * Slurm Jobs
+ *---------------------------------------------------*/
+
+// submittedJobs keeps the submission time of jobs, until their first container has started.
+var submittedJobs sync.Map // types.NamespacedName -> time.Time
+
+// ObserveSubmission records the latency of a job submission. Successfully submitted jobs are tracked
+// until their first container id is reported.
+func ObserveSubmission(podKey types.NamespacedName, started time.Time, err error) {
+	submissionDuration.WithLabelValues(podKey.Namespace, outcome(err)).Observe(time.Since(started).Seconds())
+
+	if err == nil {
+		submittedJobs.Store(podKey, time.Now())
+	}
+}
+
+// ObserveContainerID records the time from the submission of a job to its first container id.
+// Subsequent ids of the same pod are ignored.
+func ObserveContainerID(podKey types.NamespacedName) {
+	if submitted, ok := submittedJobs.LoadAndDelete(podKey); ok {
+		scheduleLatency.WithLabelValues(podKey.Namespace).Observe(time.Since(submitted.(time.Time)).Seconds())
+	}
+}
+
+// ForgetJob stops tracking a job, e.g., because its pod has been deleted before it started.
+func ForgetJob(podKey types.NamespacedName) {
+	submittedJobs.Delete(podKey)
+}
+
+/*---------------------------------------------------
+ * Event Queue
+ *---------------------------------------------------*/
+
+var registerQueueDepth sync.Once
+
+// ObserveEventQueue exports the depth of the queue of control file events, as reported by depth.
+func ObserveEventQueue(depth func() int, capacity int) {
+	eventQueueCapacity.Set(float64(capacity))
+
+	registerQueueDepth.Do(func() {
+		Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "event_queue_depth",
+			Help:      "Number of control file events that wait to be processed.",
+		}, func() float64 {
+			return float64(depth())
+		}))
+	})
+}
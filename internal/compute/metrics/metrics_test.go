@@ -0,0 +1,78 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestScheduleLatency(t *testing.T) {
+	podKey := types.NamespacedName{Namespace: "test-schedule", Name: "pod"}
+
+	ObserveSubmission(podKey, time.Now(), nil)
+	ObserveContainerID(podKey)
+	ObserveContainerID(podKey) // sidecars and main containers must not be observed again
+
+	if got := testutil.CollectAndCount(scheduleLatency, "hpk_slurm_schedule_latency_seconds"); got != 1 {
+		t.Fatalf("expected one series, got %d", got)
+	}
+
+	failed := types.NamespacedName{Namespace: "test-schedule", Name: "failed"}
+
+	ObserveSubmission(failed, time.Now(), errors.New("sbatch failed"))
+
+	if _, ok := submittedJobs.Load(failed); ok {
+		t.Fatal("failed submissions must not be tracked")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	PodCreated("test-handler", nil)
+	PodCreated("test-handler", errors.New("failed"))
+	PodTerminated("test-handler", true)
+	ObserveEventQueue(func() int { return 3 }, 10)
+
+	if got := testutil.ToFloat64(podsCreated.WithLabelValues("test-handler", OutcomeFailure)); got != 1 {
+		t.Fatalf("expected one failed creation, got %v", got)
+	}
+
+	recorder := httptest.NewRecorder()
+	Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`hpk_pods_created_total{namespace="test-handler",outcome="success"} 1`,
+		`hpk_pods_terminated_total{namespace="test-handler",outcome="success"} 1`,
+		`hpk_event_queue_depth 3`,
+		`hpk_event_queue_capacity 10`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(string(body), expected) {
+			t.Errorf("metric '%s' is missing", expected)
+		}
+	}
+}
@@ -15,10 +15,10 @@
 package podhandler
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"hpk/internal/compute"
 	"hpk/internal/compute/endpoint"
@@ -35,7 +35,7 @@ import (
 
 // buildContainer replicates the behavior of
 // https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kuberuntime/kuberuntime_container.go
-func (h *PodHandler) buildContainer(container *corev1.Container, containerStatus *corev1.ContainerStatus) (Container, error) {
+func (h *PodHandler) buildContainer(ctx context.Context, container *corev1.Container, containerStatus *corev1.ContainerStatus) (Container, error) {
 	/*---------------------------------------------------
 	 * Determine the effective security context
 	 *---------------------------------------------------*/
@@ -45,26 +45,21 @@ func (h *PodHandler) buildContainer(container *corev1.Container, containerStatus
 	/*---------------------------------------------------
 	 * Generate Environment Variables
 	 *---------------------------------------------------*/
-	envFileTemplate, err := ParseTemplate(GenerateEnvTemplate)
+	env, err := makeEnvironmentVariables(ctx, h.Pod, container, h.podEnvVariables)
 	if err != nil {
-		compute.SystemPanic(err, "generate env template error")
+		return Container{}, fmt.Errorf("cannot resolve the environment of container '%s': %w", container.Name, err)
 	}
 
-	fields := GenerateEnvFields{
-		Variables: append(container.Env, h.podEnvVariables...),
-	}
-
-	envFileContent := strings.Builder{}
-
-	if err := envFileTemplate.Execute(&envFileContent, fields); err != nil {
-		/*-- since both the template and fields are internal to the code, the evaluation should always succeed	--*/
-		compute.SystemPanic(err, "failed to evaluate sbatch template")
+	envFileContent, skipped := EncodeEnvFile(env)
+	if len(skipped) > 0 {
+		h.logger.Info("skipping env variables that cannot be set by a shell", "container", container.Name, "names", skipped)
 	}
 
 	envfilePath := h.podDirectory.Container(container.Name).EnvFilePath()
 
-	if err := os.WriteFile(envfilePath, []byte(envFileContent.String()), endpoint.PodGlobalDirectoryPermissions); err != nil {
-		compute.SystemPanic(err, "cannot write env file for container '%s' of pod '%s'", container, h.podKey)
+	// the env file may contain secrets, so it is only readable by the owner.
+	if err := os.WriteFile(envfilePath, envFileContent, endpoint.PodSpecJsonFilePermissions); err != nil {
+		compute.SystemPanic(err, "cannot write env file for container '%s' of pod '%s'", container.Name, h.podKey)
 	}
 
 	/*---------------------------------------------------
@@ -78,7 +73,7 @@ func (h *PodHandler) buildContainer(container *corev1.Container, containerStatus
 
 		subPath := mount.SubPath
 		if mount.SubPathExpr != "" {
-			subPath, err = kubecontainer.ExpandContainerVolumeMounts(mount, h.podEnvVariables)
+			subPath, err = kubecontainer.ExpandContainerVolumeMounts(mount, env)
 			if err != nil {
 				compute.SystemPanic(err, "cannot expand env variables for container '%s' of pod '%s'", container, h.podKey)
 			}
@@ -152,6 +147,8 @@ func (h *PodHandler) buildContainer(container *corev1.Container, containerStatus
 	 *---------------------------------------------------*/
 	containerPath := h.podDirectory.Container(container.Name)
 
+	command, args := kubecontainer.ExpandContainerCommandAndArgs(container, env)
+
 	c := Container{
 		InstanceName:  containerID,
 		RunAsUser:     uid,
@@ -159,8 +156,8 @@ func (h *PodHandler) buildContainer(container *corev1.Container, containerStatus
 		ImageFilePath: img.Filepath,
 		EnvFilePath:   containerPath.EnvFilePath(),
 		Binds:         binds,
-		Command:       kubecontainer.ExpandContainerCommandOnlyStatic(container.Command, container.Env),
-		Args:          kubecontainer.ExpandContainerCommandOnlyStatic(container.Args, container.Env),
+		Command:       command,
+		Args:          args,
 		ExecutionMode: executionMode,
 		LogsPath:      containerPath.LogsPath(),
 		JobIDPath:     containerPath.IDPath(),
@@ -211,6 +208,11 @@ func SyncContainerStatuses(pod *corev1.Pod) {
 				restartCount = containerStatus.RestartCount + 1
 			}
 
+			/*-- Prefer the message that the container has left in its terminationMessagePath (or logs) --*/
+			if custom := terminationMessage(podDir.Container(containerStatus.Name), lookupContainer(pod, containerStatus.Name), exitCode); custom != "" {
+				message = custom
+			}
+
 			// set current status to terminate.
 			containerStatus.State.Waiting = nil
 			containerStatus.State.Running = nil
@@ -236,14 +238,53 @@ func SyncContainerStatuses(pod *corev1.Pod) {
 			// increase the restart counter.
 			containerStatus.RestartCount = restartCount
 
+			// a terminated container is neither started nor ready.
+			started := false
+			containerStatus.Started = &started
+			containerStatus.Ready = false
+
 			return
 		}
 
 		jobIDPath := podDir.Container(containerStatus.Name).IDPath()
 		jobID, jobIDExists := readStringFromFile(jobIDPath)
 
-		/*-- Presence of Job ID indicated Running state (need to be set only once)--*/
+		/*-- Presence of Job ID indicated Running state --*/
 		if jobIDExists {
+			/*-- The presence of a restart counter indicates that the container was restarted,
+			either due to a failed liveness probe, or because it is a sidecar --*/
+			restartCount, restartCountExists := readIntFromFile(podDir.Container(containerStatus.Name).RestartCountPath())
+
+			if restartCountExists && int32(restartCount) > containerStatus.RestartCount {
+				if containerStatus.State.Running != nil {
+					reason, exitCode := lastTermination(podDir.Container(containerStatus.Name))
+
+					message := "Container failed liveness probe, will be restarted"
+					if reason != "Unhealthy" {
+						message = fmt.Sprintf("Container has exited (%s), will be restarted", HumanReadableCode(int(exitCode)))
+					}
+
+					/*-- Prefer the message that the previous instance has left, as for terminated containers --*/
+					if custom := previousTerminationMessage(podDir.Container(containerStatus.Name), lookupContainer(pod, containerStatus.Name), int(exitCode)); custom != "" {
+						message = custom
+					}
+
+					containerStatus.LastTerminationState = corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{
+							ExitCode:    exitCode,
+							Reason:      reason,
+							Message:     message,
+							StartedAt:   containerStatus.State.Running.StartedAt,
+							FinishedAt:  metav1.Now(),
+							ContainerID: containerStatus.ContainerID,
+						},
+					}
+				}
+
+				containerStatus.RestartCount = int32(restartCount)
+				containerStatus.State.Running = nil
+			}
+
 			if containerStatus.State.Running == nil {
 				slurm.SetContainerStatusID(containerStatus, jobID)
 
@@ -252,13 +293,18 @@ func SyncContainerStatuses(pod *corev1.Pod) {
 					StartedAt: metav1.Now(), // fixme: we should get this info from the file's ctime
 				}
 				containerStatus.State.Terminated = nil
-
-				/*-- todo: since we do not support probes, make everything to look ok --*/
-				started := true
-				containerStatus.Started = &started
-				containerStatus.Ready = true
 			}
 
+			/*-- Started and Ready reflect the results of the probes, as reported by the pause container --*/
+			container := lookupContainer(pod, containerStatus.Name)
+
+			started := readBoolFromFile(podDir.Container(containerStatus.Name).StartedPath(),
+				container == nil || container.StartupProbe == nil)
+			containerStatus.Started = &started
+
+			containerStatus.Ready = started && readBoolFromFile(podDir.Container(containerStatus.Name).ReadinessPath(),
+				container == nil || container.ReadinessProbe == nil)
+
 			return
 		}
 
@@ -281,4 +327,77 @@ func SyncContainerStatuses(pod *corev1.Pod) {
 	for i := 0; i < len(pod.Status.ContainerStatuses); i++ {
 		handleStatus(&pod.Status.ContainerStatuses[i])
 	}
+
+	/*-- Ephemeral containers are added to a running pod, so their statuses may not exist yet --*/
+	for _, ephemeral := range pod.Spec.EphemeralContainers {
+		exists := false
+
+		for _, containerStatus := range pod.Status.EphemeralContainerStatuses {
+			if containerStatus.Name == ephemeral.Name {
+				exists = true
+				break
+			}
+		}
+
+		if !exists {
+			pod.Status.EphemeralContainerStatuses = append(pod.Status.EphemeralContainerStatuses, corev1.ContainerStatus{
+				Name:  ephemeral.Name,
+				Image: ephemeral.Image,
+			})
+		}
+	}
+
+	for i := 0; i < len(pod.Status.EphemeralContainerStatuses); i++ {
+		handleStatus(&pod.Status.EphemeralContainerStatuses[i])
+	}
+}
+
+// lastTermination returns the reason and the exit code of the last instance of a restarted container, as written by
+// the pause container. Without the file, the container is assumed to have been killed by its liveness probe.
+func lastTermination(containerPath endpoint.ContainerPath) (string, int32) {
+	content, ok := readStringFromFile(containerPath.LastTerminationPath())
+	if !ok {
+		return "Unhealthy", 137
+	}
+
+	var (
+		reason   string
+		exitCode int32
+	)
+
+	if _, err := fmt.Sscanf(content, "%s %d", &reason, &exitCode); err != nil {
+		return "Unhealthy", 137
+	}
+
+	return reason, exitCode
+}
+
+// IsSidecar returns true for init containers with restartPolicy Always, which run alongside the main containers.
+func IsSidecar(container *corev1.Container) bool {
+	return container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways
+}
+
+// lookupContainer returns the spec of the (init or ephemeral) container with the given name, or nil if it is not found.
+func lookupContainer(pod *corev1.Pod, name string) *corev1.Container {
+	for i, container := range pod.Spec.InitContainers {
+		if container.Name == name {
+			return &pod.Spec.InitContainers[i]
+		}
+	}
+
+	for i, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return &pod.Spec.Containers[i]
+		}
+	}
+
+	for _, ephemeral := range pod.Spec.EphemeralContainers {
+		if ephemeral.Name == name {
+			container := corev1.Container(ephemeral.EphemeralContainerCommon)
+
+			return &container
+		}
+	}
+
+	return nil
 }
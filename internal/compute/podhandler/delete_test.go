@@ -0,0 +1,217 @@
+package podhandler
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/podstore"
+	"hpk/internal/compute/slurm"
+	"hpk/pkg/filenotify"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestWaitForTermination(t *testing.T) {
+	gracePeriod := int64(10)
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+		Spec: corev1.PodSpec{
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers:                    []corev1.Container{{Name: "main"}, {Name: "sidecar"}},
+		},
+	}
+
+	/*-- The wait ends once the process has exited, before the grace period --*/
+	cmd := exec.Command("sh", "-c", "trap 'sleep 0.5; exit 0' TERM; while true; do sleep 0.1; done")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = cmd.Wait() }()
+
+	pid := strconv.Itoa(cmd.Process.Pid)
+
+	if _, err := exec.Command("kill", "-TERM", pid).CombinedOutput(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+
+	waitForTermination(compute.DefaultLogger, pod, func() bool { return processExited(pid) })
+
+	if !processExited(pid) {
+		t.Error("returned before the process has exited")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %v for a process that exits within a second", elapsed)
+	}
+
+	/*-- Containers have exited once every started container has an exit code --*/
+	savedHPK := compute.HPK
+	compute.HPK = endpoint.HPK(t.TempDir())
+	defer func() { compute.HPK = savedHPK }()
+
+	podPath := compute.HPK.Pod(client.ObjectKeyFromObject(pod))
+	if err := os.MkdirAll(podPath.ControlFileDir(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(path string) {
+		if err := os.WriteFile(path, []byte("0"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !containersExited(pod) {
+		t.Error("containers that have not started are not running")
+	}
+
+	write(podPath.Container("main").IDPath())
+
+	if containersExited(pod) {
+		t.Error("a started container without an exit code is running")
+	}
+
+	write(podPath.Container("main").ExitCodePath())
+
+	if !containersExited(pod) {
+		t.Error("expected all the containers to have exited")
+	}
+}
+
+func TestDeletePodInBackground(t *testing.T) {
+	savedHPK, savedPods := compute.HPK, Pods
+	compute.HPK, Pods = endpoint.HPK(t.TempDir()), podstore.New()
+
+	defer func() { compute.HPK, Pods = savedHPK, savedPods }()
+
+	/*-- The job ignores SIGTERM, so it outlives the grace period --*/
+	job := exec.Command("sh", "-c", "trap '' TERM; while true; do sleep 0.1; done")
+	if err := job.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		_ = job.Process.Kill()
+		_ = job.Wait()
+	}()
+
+	gracePeriod := int64(1)
+	name := fmt.Sprintf("delete-%d", job.Process.Pid)
+
+	pidFilePath := fmt.Sprintf("/tmp/default_%s/.pid", name)
+	if err := os.MkdirAll(filepath.Dir(pidFilePath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(filepath.Dir(pidFilePath))
+
+	if err := os.WriteFile(pidFilePath, []byte(strconv.Itoa(job.Process.Pid)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+		Spec: corev1.PodSpec{
+			TerminationGracePeriodSeconds: &gracePeriod,
+			Containers:                    []corev1.Container{{Name: "main"}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "main",
+				Ready: true,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}},
+		},
+	}
+
+	slurm.SetPodID(pod, slurm.JobIDTypeProcess, pidFilePath)
+
+	podKey := client.ObjectKeyFromObject(pod)
+	podPath := compute.HPK.Pod(podKey)
+
+	for _, dir := range []string{podPath.ControlFileDir(), podPath.JobDir()} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Pods.Save(pod); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := filenotify.NewPollingWatcher(time.Second)
+	defer watcher.Close()
+
+	if err := watcher.Add(podPath.ControlFileDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	notified := make(chan *corev1.Pod, 2)
+
+	/*-- DeletePod returns without waiting for the job --*/
+	start := time.Now()
+
+	if !DeletePod(podKey, watcher, func(pod *corev1.Pod) { notified <- pod }) {
+		t.Fatal("the pod has not been deleted")
+	}
+
+	if !DeletePod(podKey, watcher, func(pod *corev1.Pod) { notified <- pod }) {
+		t.Fatal("the pod has not been deleted again")
+	}
+
+	if elapsed := time.Since(start); elapsed >= time.Duration(gracePeriod)*time.Second {
+		t.Errorf("DeletePod has waited for %v", elapsed)
+	}
+
+	/*-- Once the grace period is over, the terminal status is reported and the pod is removed --*/
+	var terminated *corev1.Pod
+
+	select {
+	case terminated = <-notified:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the terminal status has not been reported")
+	}
+
+	if terminated.Status.Phase != corev1.PodFailed {
+		t.Errorf("got phase %s, want Failed", terminated.Status.Phase)
+	}
+
+	for _, status := range terminated.Status.ContainerStatuses {
+		if status.State.Terminated == nil || status.Ready {
+			t.Errorf("container %s is not terminated: %+v", status.Name, status.State)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for _, err := Pods.Get(podKey); !errors.Is(err, fs.ErrNotExist); _, err = Pods.Get(podKey) {
+		if time.Now().After(deadline) {
+			t.Fatal("the pod has not been removed")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(podPath.String()); !os.IsNotExist(err) {
+		t.Errorf("the pod directory has not been removed: %v", err)
+	}
+
+	if len(notified) != 0 {
+		t.Error("the terminal status has been reported more than once")
+	}
+}
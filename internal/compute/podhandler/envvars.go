@@ -15,88 +15,574 @@
 package podhandler
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"math"
 	"net"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"hpk/internal/compute"
+	"hpk/pkg/crdtools"
+	"hpk/pkg/expansion"
+	"hpk/pkg/fieldpath"
 	corev1 "k8s.io/api/core/v1"
 
 	// discoveryv1 "k8s.io/api/discovery/v1"
+	k8errors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-// FromServices builds environment variables that a container is started with,
-// which tell the container where to find the services it may need, which are
-// provided as an argument.
-func FromServices(ctx context.Context, namespace string) []corev1.EnvVar {
+// The IPs of a pod are assigned by the network namespace that hpk-pause creates on the Slurm node.
+// Until then, variables that refer to them hold placeholders, which hpk-pause replaces with the actual values.
+const (
+	// PodIPPlaceholder stands for status.podIP.
+	PodIPPlaceholder = "__HPK_POD_IP__"
+
+	// PodIPsPlaceholder stands for status.podIPs.
+	PodIPsPlaceholder = "__HPK_POD_IPS__"
+
+	// legacyPodIPValue is the value with which MutatePod replaces fieldRefs to status.podIP.
+	legacyPodIPValue = ".status.podIP"
+)
+
+// makeEnvironmentVariables resolves the environment of a container, following the order of the kubelet:
+// first the envFrom sources, then the env entries, whose values may refer to previous variables via $(VAR).
+// Service variables can be referenced, and are appended unless they are overridden by the container.
+// Ref: https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go
+func makeEnvironmentVariables(ctx context.Context, pod *corev1.Pod, container *corev1.Container, serviceEnv []corev1.EnvVar) ([]corev1.EnvVar, error) {
+	var (
+		result  []corev1.EnvVar
+		indices = map[string]int{}
+
+		configMaps = map[string]*corev1.ConfigMap{}
+		secrets    = map[string]*corev1.Secret{}
+
+		tmpEnv = map[string]string{}
+	)
+
+	set := func(name, value string) {
+		tmpEnv[name] = value
+
+		if i, ok := indices[name]; ok {
+			result[i].Value = value
+			return
+		}
+
+		indices[name] = len(result)
+		result = append(result, corev1.EnvVar{Name: name, Value: value})
+	}
+
+	serviceEnvMap := map[string]string{}
+	for _, env := range serviceEnv {
+		serviceEnvMap[env.Name] = env.Value
+	}
+
+	mappingFunc := expansion.MappingFuncFor(tmpEnv, serviceEnvMap)
+
 	/*---------------------------------------------------
-	 * Get all Service resources from master
+	 * Environment from ConfigMaps and Secrets
 	 *---------------------------------------------------*/
-	var serviceList corev1.ServiceList
+	for _, envFrom := range container.EnvFrom {
+		var data map[string]string
 
-	if err := compute.K8SClient.List(ctx, &serviceList, &client.ListOptions{
-		LabelSelector: labels.Everything(),
-	}); err != nil {
+		switch {
+		case envFrom.ConfigMapRef != nil:
+			ref := envFrom.ConfigMapRef
+			optional := ref.Optional != nil && *ref.Optional
+
+			configMap, err := getConfigMap(ctx, pod.GetNamespace(), ref.Name, configMaps)
+			if err != nil {
+				if k8errors.IsNotFound(err) && optional {
+					continue
+				}
+
+				return nil, fmt.Errorf("couldn't get configMap '%s/%s': %w", pod.GetNamespace(), ref.Name, err)
+			}
+
+			data = configMap.Data
+
+		case envFrom.SecretRef != nil:
+			ref := envFrom.SecretRef
+			optional := ref.Optional != nil && *ref.Optional
+
+			secret, err := getSecret(ctx, pod.GetNamespace(), ref.Name, secrets)
+			if err != nil {
+				if k8errors.IsNotFound(err) && optional {
+					continue
+				}
+
+				return nil, fmt.Errorf("couldn't get secret '%s/%s': %w", pod.GetNamespace(), ref.Name, err)
+			}
+
+			data = make(map[string]string, len(secret.Data))
+			for k, v := range secret.Data {
+				data[k] = string(v)
+			}
+
+		default:
+			continue
+		}
+
+		// the kubelet iterates over maps. Sorting the keys makes the result reproducible.
+		for _, k := range sortedKeys(data) {
+			name := envFrom.Prefix + k
+
+			// invalid keys are skipped, as in the kubelet.
+			if errs := validation.IsEnvVarName(name); len(errs) != 0 {
+				compute.DefaultLogger.Info("skipping invalid env name", "name", name, "container", container.Name)
+				continue
+			}
+
+			set(name, data[k])
+		}
+	}
+
+	/*---------------------------------------------------
+	 * Environment from the Container Specification
+	 *---------------------------------------------------*/
+	for _, envVar := range container.Env {
+		runtimeVal := envVar.Value
+
+		switch {
+		case runtimeVal == legacyPodIPValue:
+			runtimeVal = PodIPPlaceholder
+
+		case runtimeVal != "":
+			runtimeVal = expansion.Expand(runtimeVal, mappingFunc)
+
+		case envVar.ValueFrom != nil:
+			value, found, err := resolveValueFrom(ctx, pod, container, envVar.ValueFrom, configMaps, secrets)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve env '%s': %w", envVar.Name, err)
+			}
+
+			// optional references to missing keys leave the variable unset.
+			if !found {
+				continue
+			}
+
+			runtimeVal = value
+		}
+
+		set(envVar.Name, runtimeVal)
+	}
+
+	/*---------------------------------------------------
+	 * Environment from Services
+	 *---------------------------------------------------*/
+	for _, env := range serviceEnv {
+		if _, exists := tmpEnv[env.Name]; !exists {
+			set(env.Name, env.Value)
+		}
+	}
+
+	return result, nil
+}
+
+// resolveValueFrom returns the value of a valueFrom source. If an optional reference is missing, found is false.
+func resolveValueFrom(ctx context.Context, pod *corev1.Pod, container *corev1.Container, source *corev1.EnvVarSource,
+	configMaps map[string]*corev1.ConfigMap, secrets map[string]*corev1.Secret,
+) (value string, found bool, err error) {
+	switch {
+	case source.FieldRef != nil:
+		value, err := podFieldSelectorRuntimeValue(pod, source.FieldRef)
+
+		return value, err == nil, err
+
+	case source.ResourceFieldRef != nil:
+		value, err := containerResourceRuntimeValue(pod, container, source.ResourceFieldRef)
+
+		return value, err == nil, err
+
+	case source.ConfigMapKeyRef != nil:
+		ref := source.ConfigMapKeyRef
+		optional := ref.Optional != nil && *ref.Optional
+
+		configMap, err := getConfigMap(ctx, pod.GetNamespace(), ref.Name, configMaps)
+		if err != nil {
+			if k8errors.IsNotFound(err) && optional {
+				return "", false, nil
+			}
+
+			return "", false, fmt.Errorf("couldn't get configMap '%s/%s': %w", pod.GetNamespace(), ref.Name, err)
+		}
+
+		value, ok := configMap.Data[ref.Key]
+		if !ok {
+			if optional {
+				return "", false, nil
+			}
+
+			return "", false, fmt.Errorf("couldn't find key '%s' in configMap '%s/%s'", ref.Key, pod.GetNamespace(), ref.Name)
+		}
+
+		return value, true, nil
+
+	case source.SecretKeyRef != nil:
+		ref := source.SecretKeyRef
+		optional := ref.Optional != nil && *ref.Optional
+
+		secret, err := getSecret(ctx, pod.GetNamespace(), ref.Name, secrets)
+		if err != nil {
+			if k8errors.IsNotFound(err) && optional {
+				return "", false, nil
+			}
+
+			return "", false, fmt.Errorf("couldn't get secret '%s/%s': %w", pod.GetNamespace(), ref.Name, err)
+		}
+
+		value, ok := secret.Data[ref.Key]
+		if !ok {
+			if optional {
+				return "", false, nil
+			}
+
+			return "", false, fmt.Errorf("couldn't find key '%s' in secret '%s/%s'", ref.Key, pod.GetNamespace(), ref.Name)
+		}
+
+		return string(value), true, nil
+
+	default:
+		return "", true, nil
+	}
+}
+
+// podFieldSelectorRuntimeValue returns the value of a fieldRef.
+// The IPs of the pod are returned as placeholders, since they are not known before the pod runs.
+func podFieldSelectorRuntimeValue(pod *corev1.Pod, fs *corev1.ObjectFieldSelector) (string, error) {
+	switch fs.FieldPath {
+	case "spec.nodeName":
+		return pod.Spec.NodeName, nil
+	case "spec.serviceAccountName":
+		return pod.Spec.ServiceAccountName, nil
+	case "status.hostIP":
+		return pod.Status.HostIP, nil
+	case "status.hostIPs":
+		ips := make([]string, 0, len(pod.Status.HostIPs))
+		for _, ip := range pod.Status.HostIPs {
+			ips = append(ips, ip.IP)
+		}
+
+		if len(ips) == 0 && pod.Status.HostIP != "" {
+			ips = append(ips, pod.Status.HostIP)
+		}
+
+		return strings.Join(ips, ","), nil
+	case "status.podIP":
+		return PodIPPlaceholder, nil
+	case "status.podIPs":
+		return PodIPsPlaceholder, nil
+	}
+
+	return fieldpath.ExtractFieldPathAsString(pod, fs.FieldPath)
+}
+
+// containerResourceRuntimeValue returns the value of a resourceFieldRef, rounded up to the divisor.
+// Unlike the kubelet, unset limits are not defaulted to the allocatable resources of the node, since the node is
+// selected by Slurm. Instead, the requests are used, if any.
+func containerResourceRuntimeValue(pod *corev1.Pod, container *corev1.Container, fs *corev1.ResourceFieldSelector) (string, error) {
+	target := container
+
+	if fs.ContainerName != "" && fs.ContainerName != container.Name {
+		target = lookupContainer(pod, fs.ContainerName)
+		if target == nil {
+			return "", fmt.Errorf("container '%s' not found in pod", fs.ContainerName)
+		}
+	}
+
+	kind, name, _ := strings.Cut(fs.Resource, ".")
+	resourceName := corev1.ResourceName(name)
+
+	var (
+		quantity resource.Quantity
+		ok       bool
+	)
+
+	switch kind {
+	case "limits":
+		if quantity, ok = target.Resources.Limits[resourceName]; !ok {
+			quantity = target.Resources.Requests[resourceName]
+		}
+	case "requests":
+		quantity = target.Resources.Requests[resourceName]
+	default:
+		return "", fmt.Errorf("unsupported container resource: %v", fs.Resource)
+	}
+
+	divisor := resource.MustParse("1")
+	if !fs.Divisor.IsZero() {
+		divisor = fs.Divisor
+	}
+
+	switch {
+	case resourceName == corev1.ResourceCPU:
+		return fmt.Sprint(int64(math.Ceil(float64(quantity.MilliValue()) / float64(divisor.MilliValue())))), nil
+	case resourceName == corev1.ResourceMemory,
+		resourceName == corev1.ResourceEphemeralStorage,
+		strings.HasPrefix(name, corev1.ResourceHugePagesPrefix):
+		return fmt.Sprint(int64(math.Ceil(float64(quantity.Value()) / float64(divisor.Value())))), nil
+	}
+
+	return "", fmt.Errorf("unsupported container resource: %v", fs.Resource)
+}
+
+// getConfigMap returns a configMap from the informer cache, and memoizes it for the lifetime of the cache map.
+func getConfigMap(ctx context.Context, namespace, name string, cache map[string]*corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	if configMap, ok := cache[name]; ok {
+		return configMap, nil
+	}
+
+	configMap, err := compute.GetConfigMap(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	cache[name] = configMap
+
+	return configMap, nil
+}
+
+// getSecret returns a secret from the informer cache, and memoizes it for the lifetime of the cache map.
+func getSecret(ctx context.Context, namespace, name string, cache map[string]*corev1.Secret) (*corev1.Secret, error) {
+	if secret, ok := cache[name]; ok {
+		return secret, nil
+	}
+
+	secret, err := compute.GetSecret(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	cache[name] = secret
+
+	return secret, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+/*---------------------------------------------------
+ * Env Files
+ *---------------------------------------------------*/
+
+// shellVariableName matches the names that can be assigned in an env file, which is evaluated by a shell.
+var shellVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EncodeEnvFile encodes the variables in the format of apptainer env files.
+// Every value is single-quoted, so that quotes, newlines, and $ are preserved verbatim.
+// Variables whose names cannot be assigned by a shell are skipped, and returned to the caller.
+func EncodeEnvFile(envs []corev1.EnvVar) (data []byte, skipped []string) {
+	var buf bytes.Buffer
+
+	for _, env := range envs {
+		if !shellVariableName.MatchString(env.Name) {
+			skipped = append(skipped, env.Name)
+			continue
+		}
+
+		buf.WriteString(env.Name)
+		buf.WriteString("='")
+		buf.WriteString(strings.ReplaceAll(env.Value, "'", `'\''`))
+		buf.WriteString("'\n")
+	}
+
+	return buf.Bytes(), skipped
+}
+
+// DecodeEnvFile decodes an env file, as produced by EncodeEnvFile.
+func DecodeEnvFile(data []byte) ([]corev1.EnvVar, error) {
+	var envs []corev1.EnvVar
+
+	for len(data) > 0 {
+		name, rest, ok := bytes.Cut(data, []byte("='"))
+		if !ok || !shellVariableName.Match(name) {
+			return nil, fmt.Errorf("malformed env file entry '%s'", truncate(string(data), 32))
+		}
+
+		var value strings.Builder
+
+		for {
+			end := bytes.IndexByte(rest, '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated value for env '%s'", name)
+			}
+
+			value.Write(rest[:end])
+			rest = rest[end+1:]
+
+			// an escaped quote closes the quoting, appends a quote, and opens the quoting again.
+			if bytes.HasPrefix(rest, []byte(`\''`)) {
+				value.WriteByte('\'')
+				rest = rest[3:]
+
+				continue
+			}
+
+			break
+		}
+
+		if len(rest) > 0 && rest[0] != '\n' {
+			return nil, fmt.Errorf("unexpected content after env '%s'", name)
+		}
+
+		envs = append(envs, corev1.EnvVar{Name: string(name), Value: value.String()})
+		data = bytes.TrimPrefix(rest, []byte("\n"))
+	}
+
+	return envs, nil
+}
+
+// masterServiceNamespace is the namespace of the services that are exposed to all pods.
+const masterServiceNamespace = metav1.NamespaceDefault
+
+// FromServices builds environment variables that a container is started with,
+// which tell the container where to find the services it may need.
+// As in the kubelet, the master services are always exposed, whereas the services in the namespace
+// of the pod are exposed only if enableServiceLinks is set.
+// Ref: https://github.com/kubernetes/kubernetes/blob/master/pkg/kubelet/kubelet_pods.go
+func FromServices(ctx context.Context, pod *corev1.Pod) []corev1.EnvVar {
+	/*---------------------------------------------------
+	 * Get all Service resources from the informer cache
+	 *---------------------------------------------------*/
+	services, err := listServices(ctx)
+	if err != nil {
 		compute.SystemPanic(err, "failed to list services when setting up env vars")
+
+		return nil
 	}
 
-	var services []*corev1.Service
+	enableServiceLinks := pod.Spec.EnableServiceLinks == nil || *pod.Spec.EnableServiceLinks
 
-	for i, service := range serviceList.Items {
-		// We always want to add environment variable for master services
-		// from the master service namespace, even if enableServiceLinks is false.
-		// We also add environment variables for other services in the same
-		// namespace, if enableServiceLinks is true.
-		if service.GetNamespace() == namespace ||
-			service.GetNamespace() == metav1.NamespaceDefault {
-			services = append(services, &serviceList.Items[i])
+	serviceMap := map[string]*corev1.Service{}
+
+	for _, service := range services {
+		// ignore services where ClusterIP is "None" or empty, as in the kubelet.
+		if !crdtools.IsServiceIPSet(service) {
+			continue
+		}
+
+		serviceName := service.GetName()
+
+		switch {
+		case service.GetNamespace() == masterServiceNamespace && serviceName == "kubernetes":
+			// We always want to add environment variables for master services
+			// from the master service namespace, even if enableServiceLinks is false.
+			if _, exists := serviceMap[serviceName]; !exists {
+				serviceMap[serviceName] = service
+			}
+		case service.GetNamespace() == pod.GetNamespace() && enableServiceLinks:
+			serviceMap[serviceName] = service
 		}
 	}
 
+	mappedServices := make([]*corev1.Service, 0, len(serviceMap))
+	for _, name := range sortedKeys(serviceMap) {
+		mappedServices = append(mappedServices, serviceMap[name])
+	}
+
 	/*---------------------------------------------------
 	 * Extract Environment Variables
 	 *---------------------------------------------------*/
 	var result []corev1.EnvVar
-	for _, service := range services {
+	for _, service := range mappedServices {
 		// some headless services do not have ports.
 		if len(service.Spec.Ports) == 0 {
 			continue
 		}
 
+		host, port := serviceAddress(service)
+
 		// Host
 		name := makeEnvVariableName(service.Name) + "_SERVICE_HOST"
-		if service.GetNamespace() == metav1.NamespaceDefault && service.GetName() == "kubernetes" {
-			// because kubernetes is not managed by HPK, we must create the entry manually.
-			service.Spec.ClusterIP = compute.Environment.KubeMasterHost
-		} else {
-			// Look it up by DNS name.
-			service.Spec.ClusterIP = service.GetName()
-		}
-		result = append(result, corev1.EnvVar{Name: name, Value: service.Spec.ClusterIP})
+		result = append(result, corev1.EnvVar{Name: name, Value: host})
 
 		// First port - give it the backwards-compatible name.
 		name = makeEnvVariableName(service.Name) + "_SERVICE_PORT"
-		result = append(result, corev1.EnvVar{Name: name, Value: service.Spec.Ports[0].TargetPort.String()})
+		result = append(result, corev1.EnvVar{Name: name, Value: port(&service.Spec.Ports[0])})
 
 		// All named ports (only the first may be unnamed, checked in validation).
 		for i := range service.Spec.Ports {
 			sp := &service.Spec.Ports[i]
 			if sp.Name != "" {
 				pn := name + "_" + makeEnvVariableName(sp.Name)
-				result = append(result, corev1.EnvVar{Name: pn, Value: sp.TargetPort.String()})
+				result = append(result, corev1.EnvVar{Name: pn, Value: port(sp)})
 			}
 		}
 
 		// Docker-compatible vars.
-		result = append(result, makeLinkVariables(service)...)
+		result = append(result, makeLinkVariables(service, host, port)...)
 	}
 	return result
 }
 
+// serviceAddress returns the host and the port through which the pods can reach a service.
+// With a service proxy, these are the ClusterIP and the port of the service, as in the kubelet.
+// Without it, ClusterIPs are not routable from the Slurm nodes. Instead, services are reached through their DNS
+// names, which resolve to the endpoints, and therefore through the target ports. The API server, which is not
+// managed by HPK, is reached directly.
+func serviceAddress(service *corev1.Service) (host string, port func(*corev1.ServicePort) string) {
+	if compute.Environment.ServiceProxy {
+		return service.Spec.ClusterIP, func(sp *corev1.ServicePort) string {
+			return strconv.Itoa(int(sp.Port))
+		}
+	}
+
+	targetPort := func(sp *corev1.ServicePort) string {
+		// an unset target port defaults to the port. A named target port refers to the ports of the backing
+		// containers, which may differ between pods, so the port of the service is used instead.
+		if sp.TargetPort.Type == intstr.String || sp.TargetPort.IntValue() == 0 {
+			return strconv.Itoa(int(sp.Port))
+		}
+
+		return strconv.Itoa(sp.TargetPort.IntValue())
+	}
+
+	if service.GetNamespace() == masterServiceNamespace && service.GetName() == "kubernetes" {
+		return compute.Environment.KubeMasterHost, targetPort
+	}
+
+	return fmt.Sprintf("%s.%s.svc.cluster.local", service.GetName(), service.GetNamespace()), targetPort
+}
+
+// listServices returns the services of all namespaces.
+func listServices(ctx context.Context) ([]*corev1.Service, error) {
+	if compute.ServiceLister != nil {
+		return compute.ServiceLister.List(labels.Everything())
+	}
+
+	var serviceList corev1.ServiceList
+
+	if err := compute.K8SClient.List(ctx, &serviceList, &client.ListOptions{
+		LabelSelector: labels.Everything(),
+	}); err != nil {
+		return nil, err
+	}
+
+	services := make([]*corev1.Service, len(serviceList.Items))
+	for i := range serviceList.Items {
+		services[i] = &serviceList.Items[i]
+	}
+
+	return services, nil
+}
+
 func makeEnvVariableName(str string) string {
 	// TODO: If we simplify to "all names are DNS1123Subdomains" this
 	// will need two tweaks:
@@ -105,7 +591,7 @@ func makeEnvVariableName(str string) string {
 	return strings.ToUpper(strings.Replace(str, "-", "_", -1))
 }
 
-func makeLinkVariables(service *corev1.Service) []corev1.EnvVar {
+func makeLinkVariables(service *corev1.Service, host string, port func(*corev1.ServicePort) string) []corev1.EnvVar {
 	prefix := makeEnvVariableName(service.Name)
 	all := []corev1.EnvVar{}
 
@@ -117,7 +603,7 @@ func makeLinkVariables(service *corev1.Service) []corev1.EnvVar {
 			protocol = string(sp.Protocol)
 		}
 
-		hostPort := net.JoinHostPort(service.Spec.ClusterIP, sp.TargetPort.String())
+		hostPort := net.JoinHostPort(host, port(sp))
 
 		if i == 0 {
 			// Docker special-cases the first port.
@@ -138,11 +624,11 @@ func makeLinkVariables(service *corev1.Service) []corev1.EnvVar {
 			},
 			{
 				Name:  portPrefix + "_PORT",
-				Value: sp.TargetPort.String(),
+				Value: port(sp),
 			},
 			{
 				Name:  portPrefix + "_ADDR",
-				Value: service.Spec.ClusterIP,
+				Value: host,
 			},
 		}...)
 	}
@@ -0,0 +1,256 @@
+package podhandler
+
+import (
+	"context"
+	"testing"
+
+	"hpk/internal/compute"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMakeEnvironmentVariables(t *testing.T) {
+	configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	_ = configMaps.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"},
+		Data:       map[string]string{"MODE": "fast", "bad=key": "x"},
+	})
+	_ = secrets.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "creds"},
+		Data:       map[string][]byte{"password": []byte("it's\nsecret")},
+	})
+
+	compute.ConfigMapLister = corelisters.NewConfigMapLister(configMaps)
+	compute.SecretLister = corelisters.NewSecretLister(secrets)
+	defer func() {
+		compute.ConfigMapLister, compute.SecretLister = nil, nil
+	}()
+
+	optional := true
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Labels: map[string]string{"app": "web"}},
+		Spec:       corev1.PodSpec{NodeName: "hpk-kubelet"},
+	}
+
+	container := &corev1.Container{
+		Name: "main",
+		EnvFrom: []corev1.EnvFromSource{
+			{Prefix: "CFG_", ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "config"}}},
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &optional}},
+		},
+		Env: []corev1.EnvVar{
+			{Name: "POD_NAME", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			{Name: "APP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['app']"}}},
+			{Name: "NODE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}}},
+			{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+			{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}, Key: "password",
+			}}},
+			{Name: "OPTIONAL", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "creds"}, Key: "nothing", Optional: &optional,
+			}}},
+			{Name: "MEMORY_MB", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{
+				Resource: "limits.memory", Divisor: resource.MustParse("1Mi"),
+			}}},
+			{Name: "URL", Value: "http://$(POD_IP):8080/$(CFG_MODE)/$(API_SERVICE_HOST)/$(UNDEFINED)"},
+			{Name: "CFG_MODE", Value: "slow"},
+		},
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1500Ki")},
+		},
+	}
+
+	serviceEnv := []corev1.EnvVar{
+		{Name: "API_SERVICE_HOST", Value: "api"},
+		{Name: "NODE", Value: "overridden"},
+	}
+
+	env, err := makeEnvironmentVariables(context.Background(), pod, container, serviceEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []corev1.EnvVar{
+		{Name: "CFG_MODE", Value: "slow"},
+		{Name: "POD_NAME", Value: "test"},
+		{Name: "APP", Value: "web"},
+		{Name: "NODE", Value: "hpk-kubelet"},
+		{Name: "POD_IP", Value: PodIPPlaceholder},
+		{Name: "PASSWORD", Value: "it's\nsecret"},
+		{Name: "MEMORY_MB", Value: "2"},
+		{Name: "URL", Value: "http://" + PodIPPlaceholder + ":8080/fast/api/$(UNDEFINED)"},
+		{Name: "API_SERVICE_HOST", Value: "api"},
+	}
+
+	if len(env) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+
+	for i := range expected {
+		if env[i] != expected[i] {
+			t.Errorf("entry %d: expected %v, got %v", i, expected[i], env[i])
+		}
+	}
+
+	/*-- Missing references that are not optional fail the container --*/
+	container.Env = []corev1.EnvVar{
+		{Name: "MISSING", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "config"}, Key: "nothing",
+		}}},
+	}
+
+	if _, err := makeEnvironmentVariables(context.Background(), pod, container, nil); err == nil {
+		t.Errorf("expected error for missing key")
+	}
+}
+
+func TestEnvFileRoundTrip(t *testing.T) {
+	envs := []corev1.EnvVar{
+		{Name: "PLAIN", Value: "value"},
+		{Name: "QUOTES", Value: `it's "quoted" ''`},
+		{Name: "MULTILINE", Value: "first\nsecond\n"},
+		{Name: "SHELL", Value: "$(HOME) $HOME `id`"},
+		{Name: "EMPTY", Value: ""},
+		{Name: "not.valid", Value: "skipped"},
+	}
+
+	data, skipped := EncodeEnvFile(envs)
+	if len(skipped) != 1 || skipped[0] != "not.valid" {
+		t.Errorf("unexpected skipped variables %v", skipped)
+	}
+
+	decoded, err := DecodeEnvFile(data)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	if len(decoded) != len(envs)-1 {
+		t.Fatalf("expected %d variables, got %v", len(envs)-1, decoded)
+	}
+
+	for i := range decoded {
+		if decoded[i] != envs[i] {
+			t.Errorf("expected %v, got %v", envs[i], decoded[i])
+		}
+	}
+
+	if _, err := DecodeEnvFile([]byte("BROKEN='value")); err == nil {
+		t.Errorf("expected error for unterminated value")
+	}
+}
+
+func TestFromServices(t *testing.T) {
+	services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+
+	_ = services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kubernetes"},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.1", Ports: []corev1.ServicePort{
+			{Name: "https", Port: 443, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt32(6443)},
+		}},
+	})
+	_ = services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "web-app"},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.20", Ports: []corev1.ServicePort{
+			{Port: 80, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt32(8080)},
+		}},
+	})
+	_ = services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "named"},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.40", Ports: []corev1.ServicePort{
+			{Name: "http", Port: 8000, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromString("http")},
+		}},
+	})
+	_ = services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "headless"},
+		Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone, Ports: []corev1.ServicePort{{Port: 80}}},
+	})
+	_ = services.Add(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "remote"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.30", Ports: []corev1.ServicePort{{Port: 80}}},
+	})
+
+	compute.ServiceLister = corelisters.NewServiceLister(services)
+	compute.Environment.KubeMasterHost = "master.example.org"
+	defer func() {
+		compute.ServiceLister = nil
+		compute.Environment = compute.HostEnvironment{}
+	}()
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "pod"}}
+
+	lookup := func(env []corev1.EnvVar, name string) (string, bool) {
+		for _, e := range env {
+			if e.Name == name {
+				return e.Value, true
+			}
+		}
+
+		return "", false
+	}
+
+	/*-- With a service proxy, the variables match the kubelet --*/
+	compute.Environment.ServiceProxy = true
+
+	env := FromServices(context.Background(), pod)
+
+	for name, expected := range map[string]string{
+		"KUBERNETES_SERVICE_HOST":       "10.96.0.1",
+		"KUBERNETES_SERVICE_PORT":       "443",
+		"KUBERNETES_SERVICE_PORT_HTTPS": "443",
+		"WEB_APP_SERVICE_HOST":          "10.96.0.20",
+		"WEB_APP_SERVICE_PORT":          "80",
+		"WEB_APP_PORT":                  "tcp://10.96.0.20:80",
+		"WEB_APP_PORT_80_TCP_ADDR":      "10.96.0.20",
+	} {
+		if value, _ := lookup(env, name); value != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, value)
+		}
+	}
+
+	for _, name := range []string{"HEADLESS_SERVICE_HOST", "REMOTE_SERVICE_HOST"} {
+		if _, ok := lookup(env, name); ok {
+			t.Errorf("unexpected variable %s", name)
+		}
+	}
+
+	/*-- Without a service proxy, services are reached through DNS and target ports --*/
+	compute.Environment.ServiceProxy = false
+
+	env = FromServices(context.Background(), pod)
+
+	for name, expected := range map[string]string{
+		"KUBERNETES_SERVICE_HOST": "master.example.org",
+		"KUBERNETES_SERVICE_PORT": "6443",
+		"WEB_APP_SERVICE_HOST":    "web-app.test.svc.cluster.local",
+		"WEB_APP_SERVICE_PORT":    "8080",
+		"NAMED_SERVICE_PORT":      "8000",
+		"NAMED_SERVICE_PORT_HTTP": "8000",
+		"NAMED_PORT":              "tcp://named.test.svc.cluster.local:8000",
+	} {
+		if value, _ := lookup(env, name); value != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, value)
+		}
+	}
+
+	/*-- Without service links, only the master services are exposed --*/
+	disabled := false
+	pod.Spec.EnableServiceLinks = &disabled
+
+	env = FromServices(context.Background(), pod)
+
+	if _, ok := lookup(env, "WEB_APP_SERVICE_HOST"); ok {
+		t.Errorf("unexpected service link with enableServiceLinks=false")
+	}
+
+	if _, ok := lookup(env, "KUBERNETES_SERVICE_HOST"); !ok {
+		t.Errorf("expected the master service with enableServiceLinks=false")
+	}
+}
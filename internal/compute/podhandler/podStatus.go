@@ -27,7 +27,6 @@ import (
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -74,6 +73,12 @@ func UpdateStatusFromRuntime(pod *corev1.Pod) {
 	/*-- A Pod that is initializing is in the Pending state --*/
 	if pod.Status.Phase == corev1.PodPending {
 		for _, initContainer := range pod.Status.InitContainerStatuses {
+			/*-- Sidecars keep running alongside the main containers. They are ready once started --*/
+			if spec := lookupContainer(pod, initContainer.Name); spec != nil && IsSidecar(spec) &&
+				initContainer.State.Running != nil && initContainer.Started != nil && *initContainer.Started {
+				continue
+			}
+
 			if initContainer.State.Terminated == nil {
 				/*-- Still Initializing: at least one init container is still running --*/
 				return
@@ -89,12 +94,10 @@ func UpdateStatusFromRuntime(pod *corev1.Pod) {
 
 		/*-- Pod is Ready: all init containers have completed successfully --*/
 		crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
-			Type:   corev1.PodInitialized,
-			Status: corev1.ConditionTrue,
-			// LastProbeTime:      metav1.Time{},
-			LastTransitionTime: metav1.Now(),
-			Reason:             "Initialized",
-			Message:            "all init containers in the pod have started successfully",
+			Type:    corev1.PodInitialized,
+			Status:  corev1.ConditionTrue,
+			Reason:  "Initialized",
+			Message: "all init containers in the pod have started successfully",
 		})
 
 		/*--
@@ -155,25 +158,56 @@ func UpdateStatusFromRuntime(pod *corev1.Pod) {
 				status.Reason = "Running"
 				status.Message = "at least one pod is still running"
 
+				/*-- Readiness is driven by the probes of the running containers --*/
+				var unready []string
+
+				for _, containerStatus := range status.ContainerStatuses {
+					if containerStatus.State.Running != nil && !containerStatus.Ready {
+						unready = append(unready, containerStatus.Name)
+					}
+				}
+
+				for _, containerStatus := range status.InitContainerStatuses {
+					if spec := lookupContainer(pod, containerStatus.Name); spec != nil && IsSidecar(spec) && !containerStatus.Ready {
+						unready = append(unready, containerStatus.Name)
+					}
+				}
+
+				if len(unready) > 0 {
+					message := fmt.Sprintf("containers with unready status: [%s]", strings.Join(unready, " "))
+
+					crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
+						Type:    corev1.ContainersReady,
+						Status:  corev1.ConditionFalse,
+						Reason:  "ContainersNotReady",
+						Message: message,
+					})
+
+					crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
+						Type:    corev1.PodReady,
+						Status:  corev1.ConditionFalse,
+						Reason:  "ContainersNotReady",
+						Message: message,
+					})
+
+					return
+				}
+
 				/*-- ContainersReady: all containers in the pod are ready. --*/
 				crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
-					Type:   corev1.ContainersReady,
-					Status: corev1.ConditionTrue,
-					// LastProbeTime:      metav1.Time{},
-					LastTransitionTime: metav1.Now(),
-					Reason:             "ContainersReady",
-					Message:            " all containers in the pod are ready.",
+					Type:    corev1.ContainersReady,
+					Status:  corev1.ConditionTrue,
+					Reason:  "ContainersReady",
+					Message: " all containers in the pod are ready.",
 				})
 
 				/*-- PodReady: the pod is able to service requests and should be added to the
 				  load balancing pools of all matching services. --*/
 				crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
-					Type:   corev1.PodReady,
-					Status: corev1.ConditionTrue,
-					// LastProbeTime:      metav1.Time{},
-					LastTransitionTime: metav1.Now(),
-					Reason:             "PodReady",
-					Message:            "the pod is able to service requests",
+					Type:    corev1.PodReady,
+					Status:  corev1.ConditionTrue,
+					Reason:  "PodReady",
+					Message: "the pod is able to service requests",
 				})
 			},
 		},
@@ -223,77 +257,42 @@ func UpdateStatusFromRuntime(pod *corev1.Pod) {
 
 func setTerminationConditions(pod *corev1.Pod) {
 	crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
-		Type:   corev1.ContainersReady,
-		Status: corev1.ConditionFalse,
-		// LastProbeTime:      metav1.Time{},
-		LastTransitionTime: metav1.Now(),
-		Reason:             "ContainersUnready",
-		Message:            "Pod Has been Successfully Terminated.",
+		Type:    corev1.ContainersReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  "ContainersUnready",
+		Message: "Pod Has been Successfully Terminated.",
 	})
 
 	crdtools.SetPodStatusCondition(&pod.Status.Conditions, corev1.PodCondition{
-		Type:   corev1.PodReady,
-		Status: corev1.ConditionFalse,
-		// LastProbeTime:      metav1.Time{},
-		LastTransitionTime: metav1.Now(),
-		Reason:             "PodUnready",
-		Message:            "Pod Has been Successfully Terminated.",
+		Type:    corev1.PodReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  "PodUnready",
+		Message: "Pod Has been Successfully Terminated.",
 	})
 }
 
 func podWithExplicitlyUnsupportedFields(logger logr.Logger, pod *corev1.Pod) bool {
-	var unsupportedFields []string
-
 	/*---------------------------------------------------
-	 * Unsupported Pod-Level Fields
+	 * Ignored Fields
 	 *---------------------------------------------------*/
-	if pod.GetNamespace() == "kube-system" {
-		unsupportedFields = append(unsupportedFields, ".Meta.Namespace == 'kube-system'")
-	}
-
 	if pod.Spec.Affinity != nil {
 		logger.Info("Ignore .Spec.Affinity")
-		// unsupportedFields = append(unsupportedFields, ".Spec.Affinity")
-	}
-
-	if pod.Spec.DNSConfig != nil {
-		unsupportedFields = append(unsupportedFields, ".Spec.DNSConfig")
 	}
 
 	if pod.Spec.SecurityContext != nil {
 		logger.Info("Ignore .Spec.SecurityContext")
-		//	unsupportedFields = append(unsupportedFields, ".Spec.SecurityContext")
 	}
 
-	/*---------------------------------------------------
-	 * Unsupported Container-Level Fields
-	 *---------------------------------------------------*/
 	for i, container := range pod.Spec.Containers {
 		if container.SecurityContext != nil {
 			logger.Info(fmt.Sprintf("Ignore .Spec.Containers[%d].SecurityContext", i))
-			// unsupportedFields = append(unsupportedFields, fmt.Sprintf(".Spec.Containers[%d].SecurityContext", i))
-		}
-
-		if container.StartupProbe != nil {
-			logger.Info(fmt.Sprintf("Ignore .Spec.Containers[%d].StartupProbe", i))
-			// unsupportedFields = append(unsupportedFields, fmt.Sprintf(".Spec.Containers[%d].StartupProbe", i))
-		}
-
-		if container.LivenessProbe != nil {
-			logger.Info(fmt.Sprintf("Ignore .Spec.Containers[%d].LivenessProbe", i))
-			// unsupportedFields = append(unsupportedFields, fmt.Sprintf(".Spec.Containers[%d].LivenessProbe", i))
-		}
-
-		if container.ReadinessProbe != nil {
-			logger.Info(fmt.Sprintf("Ignore .Spec.Containers[%d].ReadinessProbe", i))
-			// unsupportedFields = append(unsupportedFields, fmt.Sprintf(".Spec.Containers[%d].ReadinessProbe", i))
 		}
 	}
 
 	/*---------------------------------------------------
 	 * Summary of Unsupported Fields
 	 *---------------------------------------------------*/
-	if len(unsupportedFields) > 0 {
+	if unsupportedFields := UnsupportedFields(pod); len(unsupportedFields) > 0 {
 		compute.PodError(pod, compute.ReasonUnsupportedFeatures, "UnsupportedFeatures: %s", strings.Join(unsupportedFields, ","))
 
 		return true
@@ -302,6 +301,45 @@ func podWithExplicitlyUnsupportedFields(logger logr.Logger, pod *corev1.Pod) boo
 	return false
 }
 
+// UnsupportedFields returns the fields of the pod that HPK does not support, including the volumes that it
+// cannot mount. It is used both by the admission webhook and on the creation of the pod.
+func UnsupportedFields(pod *corev1.Pod) []string {
+	var unsupportedFields []string
+
+	/*---------------------------------------------------
+	 * Unsupported Pod-Level Fields
+	 *---------------------------------------------------*/
+	if pod.GetNamespace() == "kube-system" {
+		unsupportedFields = append(unsupportedFields, ".Meta.Namespace == 'kube-system'")
+	}
+
+	if pod.Spec.DNSConfig != nil {
+		unsupportedFields = append(unsupportedFields, ".Spec.DNSConfig")
+	}
+
+	// .Spec.Affinity and the SecurityContexts are ignored.
+
+	/*---------------------------------------------------
+	 * Unsupported Volumes
+	 *---------------------------------------------------*/
+	for i, vol := range pod.Spec.Volumes {
+		switch {
+		case vol.EmptyDir != nil, vol.ConfigMap != nil, vol.Secret != nil, vol.DownwardAPI != nil,
+			vol.HostPath != nil, vol.PersistentVolumeClaim != nil:
+		case vol.Projected != nil:
+			for j, source := range vol.Projected.Sources {
+				if source.Secret == nil && source.ConfigMap == nil && source.DownwardAPI == nil && source.ServiceAccountToken == nil {
+					unsupportedFields = append(unsupportedFields, fmt.Sprintf(".Spec.Volumes[%d].Projected.Sources[%d]", i, j))
+				}
+			}
+		default:
+			unsupportedFields = append(unsupportedFields, fmt.Sprintf(".Spec.Volumes[%d] (%s)", i, vol.Name))
+		}
+	}
+
+	return unsupportedFields
+}
+
 // HumanReadableCode translated the exit into a human-readable form.
 // Source: https://komodor.com/learn/exit-codes-in-containers-and-kubernetes-the-complete-guide/
 func HumanReadableCode(code int) string {
@@ -348,6 +386,22 @@ func readStringFromFile(filepath string) (string, bool) {
 	return strings.TrimSuffix(string(out), "\n"), true
 }
 
+// readBoolFromFile returns the boolean value stored in the file, or the defaultValue if the file does not exist.
+func readBoolFromFile(filepath string, defaultValue bool) bool {
+	out, exists := readStringFromFile(filepath)
+	if !exists {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(strings.TrimSpace(out))
+	if err != nil {
+		compute.DefaultLogger.Error(err, "cannot decode content to bool", "path", filepath)
+		return defaultValue
+	}
+
+	return value
+}
+
 func readIntFromFile(filepath string) (int, bool) {
 	out, err := os.ReadFile(filepath)
 	if os.IsNotExist(err) {
@@ -21,11 +21,17 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"hpk/internal/compute"
 	"hpk/internal/compute/endpoint"
 	"hpk/internal/compute/image"
+	"hpk/internal/compute/metrics"
+	"hpk/internal/compute/podstore"
 	"hpk/internal/compute/runtime"
 	"hpk/internal/compute/slurm"
 	"hpk/pkg/filenotify"
@@ -33,21 +39,25 @@ import (
 
 	"errors"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/json"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
-	CustomSlurmFlags = "slurm.hpk.io/flags"
+	// CustomSlurmFlags holds raw sbatch flags. It is accepted only if the Slurm policy allows it.
+	CustomSlurmFlags = slurm.AnnotationFlags
 )
 
-// LoadPodFromKey waits LoadPodFromFile with filePath discovery.
-func LoadPodFromKey(podRef client.ObjectKey) (*corev1.Pod, error) {
-	filePath := compute.HPK.Pod(podRef).EncodedJSONPath()
+// Pods holds the state of the pods of the provider.
+var Pods = podstore.New()
 
-	return LoadPodFromFile(filePath)
+// LoadPodFromKey returns a copy of the pod from the pod store.
+func LoadPodFromKey(podRef client.ObjectKey) (*corev1.Pod, error) {
+	return Pods.Get(podRef)
 }
 
 // LoadPodFromFile will read, decode, and return a Pod from a file.
@@ -74,24 +84,15 @@ func LoadPodFromFile(filePath string) (*corev1.Pod, error) {
 	return &pod, nil
 }
 
+// SavePodToFile updates the pod in the pod store, which writes it through to its pod.crd file.
 func SavePodToFile(_ context.Context, pod *corev1.Pod) error {
-	if pod == nil {
-		return fmt.Errorf("empty pod")
-	}
-
-	podRef := client.ObjectKeyFromObject(pod)
-	filePath := compute.HPK.Pod(podRef).EncodedJSONPath()
-
-	podDef, err := json.Marshal(pod)
-	if err != nil {
-		return fmt.Errorf("failed encoding pod: %w", err)
-	}
-
-	if err := os.WriteFile(filePath, podDef, endpoint.PodSpecJsonFilePermissions); err != nil {
-		compute.SystemPanic(err, "failed to write file path '%s'", filePath)
-	}
+	return Pods.Save(pod)
+}
 
-	return nil
+// UpdatePodInFile applies update to the pod in the pod store, which writes it through to its pod.crd file.
+// Concurrent updates of the same pod are serialized, so that none of them is lost.
+func UpdatePodInFile(_ context.Context, podRef client.ObjectKey, update func(pod *corev1.Pod) error) error {
+	return Pods.Update(podRef, update)
 }
 
 /*
@@ -101,10 +102,19 @@ DeletePod may be called multiple times for the same pod.
 Notice that by using the reference, we operate on the local copy instead of the remote. This serves two purposes:
 1) We can extract updated information from .spec (Kubernetes only fetches .Status)
 2) We can have "fresh" information that is not yet propagated to Kubernetes
+
+The job is stopped asynchronously, within the termination grace period of the pod. Once it has exited, the terminal
+status of the pod is passed to notify, and the pod is removed.
 */
-func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher) bool {
+func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher, notify func(pod *corev1.Pod)) bool {
 	logger := compute.DefaultLogger.WithValues("pod", podKey)
 
+	if _, terminating := terminations.Load(podKey); terminating {
+		logger.Info(" * Pod is already being terminated")
+
+		return true
+	}
+
 	localPod, err := LoadPodFromKey(podKey)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
@@ -117,6 +127,9 @@ func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher) bool {
 		compute.SystemPanic(err, "failed to load pod")
 	}
 
+	// the job has exited, unless it is stopped below.
+	exited := func() bool { return true }
+
 	/*---------------------------------------------------
 	 * Cancel Slurm Job or Kill Direct Process
 	 *---------------------------------------------------*/
@@ -132,7 +145,7 @@ func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher) bool {
 				logger.Info(" * Failed to read PID from file", "path", pidFilePath, "err", err)
 				// If we can't read the PID file, just proceed with cleanup
 				// (the process may have already terminated)
-				goto remove_pod
+				goto terminate_pod
 			}
 
 			// Now kill the process using the PID we read from the file
@@ -141,13 +154,15 @@ func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher) bool {
 				if errors.Is(err, slurm.ErrInvalidJob) {
 					logger.Info(" * No such process", "pid", pid, "pod", podKey)
 					// the process does not exist, so it can be considered as deleted.
-					goto remove_pod
+					goto terminate_pod
 				}
 
 				compute.SystemPanic(err, "failed to kill process '%s' (%s). out: '%s'", pid, podKey, out)
 			}
 
 			logger.Info(" * Process is terminated", "pid", pid, "pod", podKey, "out", out)
+
+			exited = func() bool { return processExited(pid) }
 		} else {
 			// This is a SLURM job ID
 			out, err := slurm.CancelJob(jobID)
@@ -156,7 +171,7 @@ func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher) bool {
 					logger.Info(" * No such Slurm job", "job", jobID, "pod", podKey)
 
 					// the job does not exist, so it can be considered as deleted.
-					goto remove_pod
+					goto terminate_pod
 				}
 
 				if errors.Is(err, slurm.ErrRety) {
@@ -169,19 +184,60 @@ func DeletePod(podKey client.ObjectKey, watcher filenotify.FileWatcher) bool {
 			}
 
 			logger.Info(" * Slurm job is cancelled", "job", jobID, "pod", podKey, "out", out)
+
+			exited = func() bool { return containersExited(localPod) }
 		}
 	}
 
+	/*---------------------------------------------------
+	 * Wait for the Job in the Background
+	 *---------------------------------------------------*/
+terminate_pod:
+	if _, terminating := terminations.LoadOrStore(podKey, struct{}{}); terminating {
+		return true
+	}
+
+	// the pod sync workers of the virtual kubelet must not be held for the grace period of the pod.
+	go func() {
+		defer terminations.Delete(podKey)
+
+		waitForTermination(logger, localPod, exited)
+
+		/*-- Report the terminal status, for the pod to be removed from Kubernetes --*/
+		var terminated *corev1.Pod
+
+		if err := Pods.Update(podKey, func(pod *corev1.Pod) error {
+			UpdateStatusFromRuntime(pod)
+			setTerminalStatus(pod)
+
+			terminated = pod
+
+			return nil
+		}); err != nil {
+			compute.SystemPanic(err, "failed to save the terminal status of pod '%s'", podKey)
+		} else {
+			notify(terminated)
+		}
+
+		removePod(logger, podKey, watcher)
+	}()
+
+	return true
+}
+
+// terminations holds the pods that are being terminated in the background, by key.
+var terminations sync.Map
+
+// removePod removes the watcher and the directory of a pod whose job has exited, along with the pod itself.
+func removePod(logger logr.Logger, podKey client.ObjectKey, watcher filenotify.FileWatcher) {
 	/*---------------------------------------------------
 	 * Remove watcher for Pod Directory
 	 *---------------------------------------------------*/
-remove_pod:
 	podDir := compute.HPK.Pod(podKey)
 
-	// because fswatch does not work recursively, we cannot have the container directories nested within the pod.
-	// instead, we use a flat directory in the format "podir/containername.{jid,stdout,stdour,...}"
-	if err := watcher.Remove(podDir.String()); err != nil {
-		compute.SystemPanic(err, "deregister watcher for path '%s' has failed", podDir)
+	// the watcher is set on the control files of the pod, either by CreatePod or by the recovery on startup.
+	if err := watcher.Remove(podDir.ControlFileDir()); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+		compute.SystemPanic(err, "deregister watcher for path '%s' has failed", podDir.ControlFileDir())
 	}
 
 	logger.Info(" * Pod Watcher has been removed.")
@@ -218,6 +274,8 @@ remove_pod:
 
 	logger.Info(" * Pod directory is removed")
 
+	Pods.Delete(podKey)
+
 	/*---------------------------------------------------
 	 * Garbage Collect Namespace
 	 *---------------------------------------------------*/
@@ -227,6 +285,114 @@ remove_pod:
 
 		logger.Info(" * Namespace directory is removed")
 	}
+}
+
+// setTerminalStatus terminates the containers of a deleted pod that are still reported as running, e.g, because
+// they have not exited within the grace period, and sets the phase of the pod from the exit codes of its containers.
+func setTerminalStatus(pod *corev1.Pod) {
+	terminate := func(statuses []corev1.ContainerStatus) {
+		for i := range statuses {
+			status := &statuses[i]
+
+			status.Ready = false
+
+			if status.State.Terminated != nil {
+				continue
+			}
+
+			status.State = corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{
+					ExitCode:   137,
+					Reason:     "ContainerStatusUnknown",
+					Message:    "The container could not be located when the pod was deleted",
+					FinishedAt: metav1.Now(),
+				},
+			}
+		}
+	}
+
+	terminate(pod.Status.InitContainerStatuses)
+	terminate(pod.Status.ContainerStatuses)
+	terminate(pod.Status.EphemeralContainerStatuses)
+
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+
+	pod.Status.Phase = corev1.PodSucceeded
+
+	// pods whose containers have not started have failed.
+	if len(pod.Status.ContainerStatuses) == 0 {
+		pod.Status.Phase = corev1.PodFailed
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated.ExitCode != 0 {
+			pod.Status.Phase = corev1.PodFailed
+		}
+	}
+}
+
+// terminationPollInterval is how often the termination of a deleted pod checks whether its job has exited.
+const terminationPollInterval = 500 * time.Millisecond
+
+// waitForTermination waits until the job of the pod has exited, or until the termination grace period of the
+// pod has elapsed. hpk-pause stops the containers within the grace period, and still writes to the pod
+// directory in the meantime, so the directory must not be removed before then.
+func waitForTermination(logger logr.Logger, pod *corev1.Pod, exited func() bool) {
+	gracePeriod := corev1.DefaultTerminationGracePeriodSeconds * time.Second
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+
+	deadline := time.Now().Add(gracePeriod)
+
+	for !exited() {
+		if time.Now().After(deadline) {
+			logger.Info(" * Job has not exited within the grace period", "gracePeriod", gracePeriod)
+
+			return
+		}
+
+		time.Sleep(terminationPollInterval)
+	}
+}
+
+// processExited returns true if the process does not exist anymore.
+func processExited(pid string) bool {
+	id, err := strconv.Atoi(strings.TrimSpace(pid))
+	if err != nil {
+		return true
+	}
+
+	return syscall.Kill(id, 0) != nil
+}
+
+// containersExited returns true if all the containers of the pod that have been started have also exited.
+// Slurm jobs may run on other nodes, so their containers are tracked through the control files.
+func containersExited(pod *corev1.Pod) bool {
+	podDir := compute.HPK.Pod(client.ObjectKeyFromObject(pod))
+
+	var containers []corev1.Container
+
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+
+	for _, ephemeral := range pod.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container(ephemeral.EphemeralContainerCommon))
+	}
+
+	for _, container := range containers {
+		containerPath := podDir.Container(container.Name)
+
+		if _, err := os.Stat(containerPath.IDPath()); err != nil {
+			continue
+		}
+
+		if _, err := os.Stat(containerPath.ExitCodePath()); err != nil {
+			return false
+		}
+	}
 
 	return true
 }
@@ -242,7 +408,12 @@ type PodHandler struct {
 	logger logr.Logger
 }
 
-func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatcher, useTmp bool) {
+// CreatePod prepares the environment of the pod and submits it to Slurm. If the partition is set, the job
+// is submitted to it, so that it runs on the nodes that the virtual node of the pod represents.
+// The Slurm options of the pod are validated against the slurm.DefaultPolicy.
+//
+// If the pod cannot be created, it is marked as failed, and the error is returned.
+func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatcher, useTmp bool, partition slurm.Partition) error {
 	/*---------------------------------------------------
 	 * Prepare the Pod Execution Environment
 	 *---------------------------------------------------*/
@@ -254,7 +425,15 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 		podKey:          podKey,
 		podDirectory:    compute.HPK.Pod(podKey),
 		logger:          logger,
-		podEnvVariables: FromServices(ctx, pod.GetNamespace()),
+		podEnvVariables: FromServices(ctx, pod),
+	}
+
+	// the options are validated before anything is materialized, as they are given by the user.
+	jobOptions, err := slurm.DefaultPolicy.JobOptions(pod, partition)
+	if err != nil {
+		compute.PodError(pod, "SlurmOptionsError", "invalid Slurm options: %v", err)
+
+		return err
 	}
 
 	for _, env := range h.podEnvVariables {
@@ -313,7 +492,7 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 		if err := h.mountVolumeSource(ctx, vol); err != nil {
 			compute.PodError(pod, "VolumeError", "%v", err)
 
-			return
+			return err
 		}
 	}
 
@@ -329,11 +508,11 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 		initContainer := &pod.Spec.InitContainers[i]
 		initContainerStatus := &pod.Status.InitContainerStatuses[i]
 
-		c, err := h.buildContainer(initContainer, initContainerStatus)
+		c, err := h.buildContainer(ctx, initContainer, initContainerStatus)
 		if err != nil {
-			compute.PodError(pod, "InitContainerError", "failed to materialize pod.Spec.InitContainers[%d]", i)
+			compute.PodError(pod, "InitContainerError", "failed to materialize pod.Spec.InitContainers[%d]: %v", i, err)
 
-			return
+			return err
 		}
 
 		initContainers = append(initContainers, c)
@@ -346,11 +525,11 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 		container := &pod.Spec.Containers[i]
 		containerStatus := &pod.Status.ContainerStatuses[i]
 
-		c, err := h.buildContainer(container, containerStatus)
+		c, err := h.buildContainer(ctx, container, containerStatus)
 		if err != nil {
-			compute.PodError(pod, "MainContainerError", "failed to materialize pod.Spec.Containers[%d]", i)
+			compute.PodError(pod, "MainContainerError", "failed to materialize pod.Spec.Containers[%d]: %v", i, err)
 
-			return
+			return err
 		}
 
 		containers = append(containers, c)
@@ -391,11 +570,6 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 	/*---------------------------------------------------
 	 * Prepare Fields for Sbatch Templates
 	 *---------------------------------------------------*/
-	var customFlags []string
-	if flags, hasFlags := h.Pod.GetAnnotations()[CustomSlurmFlags]; hasFlags {
-		customFlags = strings.Split(flags, " ")
-	}
-
 	scriptTemplate, err := ParseTemplate(HostScriptTemplate)
 	if err != nil {
 		compute.SystemPanic(err, "sbatch template error")
@@ -414,6 +588,8 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 	pod.Annotations["enableCgroupV2"] = fmt.Sprintf("%t", compute.Environment.EnableCgroupV2)
 	pod.Annotations["workingDirectory"] = compute.Environment.WorkingDirectory
 	pod.Annotations["kubeDNS"] = compute.Environment.KubeDNS
+	pod.Annotations["containerLogMaxSize"] = compute.Environment.ContainerLogMaxSize
+	pod.Annotations["containerLogMaxFiles"] = strconv.Itoa(compute.Environment.ContainerLogMaxFiles)
 
 	// Set annotations from VirtualEnvironment
 	pod.Annotations["cgroupFilePath"] = h.podDirectory.CgroupFilePath()
@@ -433,6 +609,7 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 			PodDirectory:        h.podDirectory.String(),
 			CgroupFilePath:      h.podDirectory.CgroupFilePath(),
 			ConstructorFilePath: h.podDirectory.ConstructorFilePath(),
+			EncodedJSONPath:     h.podDirectory.EncodedJSONPath(),
 			IPAddressPath:       h.podDirectory.IPAddressPath(),
 			StdoutPath:          h.podDirectory.StdoutPath(),
 			StderrPath:          h.podDirectory.StderrPath(),
@@ -441,7 +618,7 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 		InitContainers:  initContainers,
 		Containers:      containers,
 		ResourceRequest: resources.ResourceListToStruct(resourceRequest),
-		CustomFlags:     customFlags,
+		Slurm:           jobOptions,
 		RunSlurm:        compute.Environment.RunSlurm,
 		UseTmp:          useTmp,
 	}); err != nil {
@@ -457,32 +634,57 @@ func CreatePod(ctx context.Context, pod *corev1.Pod, watcher filenotify.FileWatc
 
 	logger.Info(" * Slurm script has been generated")
 
+	// the pause container reads the pod definition from the job directory, so it must exist before the submission.
+	if err := SavePodToFile(ctx, h.Pod); err != nil {
+		compute.SystemPanic(err, "failed to persistent pod")
+	}
+
 	/*---------------------------------------------------
 	 * Submit job to Slurm, and store the JobID
 	 *---------------------------------------------------*/
+	submitted := time.Now()
+
 	jobID, err := slurm.SubmitJobWithRunSlurm(scriptFilePath, compute.Environment.RunSlurm)
+
+	metrics.ObserveSubmission(h.podKey, submitted, err)
+
 	if err != nil {
-		compute.SystemPanic(err, "failed to submit job")
-		//[TODO:] update pod status with insufficient resources
+		logger.Error(err, " * Slurm job submission has failed")
+
+		compute.PodError(pod, "SubmissionError", "failed to submit job: %v", err)
+
+		if err := UpdatePodInFile(ctx, h.podKey, func(stored *corev1.Pod) error {
+			compute.PodError(stored, "SubmissionError", "failed to submit job: %v", err)
+
+			return nil
+		}); err != nil {
+			compute.SystemPanic(err, "failed to persistent pod")
+		}
+
+		return err
 	}
 
 	logger.Info(" * Slurm job has been submitted", "jobID", jobID)
 
 	// update pod with the job id (use JobIDTypeProcess for non-SLURM mode, JobIDTypeSlurm for SLURM mode)
-	if compute.Environment.RunSlurm {
-		slurm.SetPodID(h.Pod, slurm.JobIDTypeSlurm, jobID)
-	} else {
+	idType, id := slurm.JobIDTypeSlurm, jobID
+
+	if !compute.Environment.RunSlurm {
 		// In non-SLURM mode, we need to store a reference to the .pid file location
 		// The actual PID will be read from the file when needed
-		pidFilePath := fmt.Sprintf("/tmp/%s_%s/.pid", h.Pod.Namespace, h.Pod.Name)
-		slurm.SetPodID(h.Pod, slurm.JobIDTypeProcess, pidFilePath)
-	}
-	if err != nil {
-		compute.SystemPanic(err, "failed to set job id for pod")
+		idType, id = slurm.JobIDTypeProcess, fmt.Sprintf("/tmp/%s_%s/.pid", h.Pod.Namespace, h.Pod.Name)
 	}
 
-	// needed for subsequent GetPod()
-	if err := SavePodToFile(ctx, h.Pod); err != nil {
+	slurm.SetPodID(h.Pod, idType, id)
+
+	// needed for subsequent GetPod(). Only the job id is stored, as the job may have already changed the status.
+	if err := UpdatePodInFile(ctx, h.podKey, func(stored *corev1.Pod) error {
+		slurm.SetPodID(stored, idType, id)
+
+		return nil
+	}); err != nil {
 		compute.SystemPanic(err, "failed to persistent pod")
 	}
+
+	return nil
 }
@@ -24,12 +24,12 @@ import (
 	"text/template"
 
 	"hpk/internal/compute"
+	"hpk/internal/compute/slurm"
 	"hpk/pkg/process"
 	"hpk/pkg/resources"
 
 	"al.essio.dev/pkg/shellescape"
 	"github.com/Masterminds/sprig"
-	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -250,7 +250,7 @@ function handle_init_containers() {
 	echo "[Virtual] Spawning InitContainer: {{$container.InstanceName}}"
 	 
 	{{- if $container.EnvFilePath}}
-	sh -c {{$container.EnvFilePath}} > /scratch/{{$container.InstanceName}}.env
+	sed "s/` + PodIPPlaceholder + `/$(ip route get 1 | sed -n 's/.*src \([0-9.]\+\).*/\1/p')/g" {{$container.EnvFilePath}} > /scratch/{{$container.InstanceName}}.env
 	{{- end}}
 
 	# Mark the beginning of an init job (all get the shell's pid).  
@@ -292,7 +292,7 @@ function handle_containers() {
 	####################
 
 	{{- if $container.EnvFilePath}}
-	sh -c {{$container.EnvFilePath}} > /scratch/{{$container.InstanceName}}.env
+	sed "s/` + PodIPPlaceholder + `/$(ip route get 1 | sed -n 's/.*src \([0-9.]\+\).*/\1/p')/g" {{$container.EnvFilePath}} > /scratch/{{$container.InstanceName}}.env
 	{{- end}}
 
 	$(apptainer {{ $container.ExecutionMode }} \
@@ -370,9 +370,43 @@ const HostScriptTemplate = `#!/bin/bash
 #SBATCH --job-name={{.Pod.Name}}
 #SBATCH --output={{.VirtualEnv.StdoutPath}}
 #SBATCH --error={{.VirtualEnv.StderrPath}}
+{{- with .Slurm}}
+{{- if .Partition}}
+#SBATCH --partition={{.Partition}}
+{{end}}
+
+{{- if .Account}}
+#SBATCH --account={{.Account}}
+{{end}}
+
+{{- if .QOS}}
+#SBATCH --qos={{.QOS}}
+{{end}}
+
+{{- if .TimeLimit}}
+#SBATCH --time={{.TimeLimit}}
+{{end}}
+
+{{- if .Constraint}}
+#SBATCH --constraint={{.Constraint}}
+{{end}}
+
+{{- if .Reservation}}
+#SBATCH --reservation={{.Reservation}}
+{{end}}
+
+{{- if .Exclusive}}
+#SBATCH --exclusive
+{{end}}
+
+{{- if .Nice}}
+#SBATCH --nice={{.Nice}}
+{{end}}
+
 {{- range $index, $flag := .CustomFlags}}
 #SBATCH {{$flag}}
 {{end}}
+{{- end}}
 
 #SBATCH --signal=B:TERM@60 # tells the controller
                            # to send SIGTERM to the job 60 secs
@@ -454,11 +488,10 @@ exec {{$.HostEnv.ApptainerBin}} exec --nv --containall --net --fakeroot --scratc
 --apply-cgroups {{.VirtualEnv.CgroupFilePath}} 		\
 {{- end}}
 --env PARENT=${PPID}								\
---bind $HOME/.hpk-master/kubernetes:/k8s-data			\
 --bind /etc/apptainer/apptainer.conf				\
 --bind $HOME,/tmp									\
 --hostname {{truncate .Pod.Name 63}}							\
-{{$.PauseImageFilePath}} /usr/local/bin/hpk-pause -namespace {{.Pod.Namespace}} -pod {{.Pod.Name}} ||
+{{$.PauseImageFilePath}} /usr/local/bin/hpk-pause -namespace {{.Pod.Namespace}} -pod {{.Pod.Name}} -pod-spec {{.VirtualEnv.EncodedJSONPath}} ||
 echo "[HOST] **SYSTEMERROR** hpk-pause exited with code $?" | tee {{.VirtualEnv.SysErrorFilePath}}
 
 #### END SECTION: Host Environment ####
@@ -485,8 +518,9 @@ type JobFields struct {
 	// ResourceRequest are reserved resources for the job.
 	ResourceRequest resources.ResourceList
 
-	// CustomFlags are flags given by the user via 'slurm.hpk.io/flags' annotations
-	CustomFlags []string
+	// Slurm are the options of the job, given by the user via 'slurm.hpk.io' annotations
+	// and by the partition of the virtual node to which the pod is scheduled.
+	Slurm slurm.JobOptions
 
 	// RunSlurm indicates whether to run the job under slurm control or via apptainer directly.
 	RunSlurm bool
@@ -533,24 +567,6 @@ type Container struct {
 	ExitCodePath string
 }
 
-// GenerateEnvTemplate is used to generate environment variables.
-// This is needed for variables that consume information from the downward API (like .status.podIP)
-const GenerateEnvTemplate = `#!/bin/bash
-
-{{- range $index, $variable := .Variables}}
-{{- if eq $variable.Value ".status.podIP"}}
-echo {{$variable.Name}}=$(ip route get 1 | sed -n 's/.*src \([0-9.]\+\).*/\1/p')
-{{ else }}
-echo {{$variable.Name}}=\''{{$variable.Value}}'\'
-{{- end}}
-{{- end}}
-`
-
-// GenerateEnvFields provide the inputs to GenerateEnvTemplate.
-type GenerateEnvFields = struct {
-	Variables []corev1.EnvVar
-}
-
 // ValidateScript runs the bash -n <filename.sh> to validate the generated script.
 func ValidateScript(filepath string) error {
 	_, err := process.Execute("bash", "-n", filepath)
@@ -0,0 +1,145 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podhandler
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"hpk/internal/compute/endpoint"
+	kubecontainer "hpk/pkg/container"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Limits of the termination messages, as in the kubelet.
+const (
+	// MaxTerminationMessageLength is the maximum size of a message read from the terminationMessagePath.
+	MaxTerminationMessageLength = 4 * 1024
+
+	// maxTerminationMessageLogLength and maxTerminationMessageLogLines bound the log tail that
+	// is used as message by FallbackToLogsOnError.
+	maxTerminationMessageLogLength = 2 * 1024
+	maxTerminationMessageLogLines  = 80
+
+	// terminationMessageLogWindow is the size of the end of the log file that is scanned for the tail.
+	terminationMessageLogWindow = 64 * 1024
+)
+
+// terminationMessage returns the message that a terminated container has written to its terminationMessagePath.
+// If the message is empty, the container has failed, and its policy is FallbackToLogsOnError, the tail of the
+// log is returned instead. An empty string is returned if there is no message.
+func terminationMessage(containerPath endpoint.ContainerPath, container *corev1.Container, exitCode int) string {
+	return readTerminationMessage(containerPath.TerminationMessagePath(), containerPath.LogsPath(), container, exitCode)
+}
+
+// previousTerminationMessage is the terminationMessage of the previous instance of a restarted container,
+// whose message and logs are kept by the pause container before the restart.
+func previousTerminationMessage(containerPath endpoint.ContainerPath, container *corev1.Container, exitCode int) string {
+	return readTerminationMessage(containerPath.PreviousTerminationMessagePath(), containerPath.PreviousLogsPath(), container, exitCode)
+}
+
+func readTerminationMessage(messagePath string, logsPath string, container *corev1.Container, exitCode int) string {
+	if container == nil {
+		return ""
+	}
+
+	if container.TerminationMessagePath != "" {
+		if message := readFileHead(messagePath, MaxTerminationMessageLength); message != "" {
+			return message
+		}
+	}
+
+	if exitCode != 0 && container.TerminationMessagePolicy == corev1.TerminationMessageFallbackToLogsOnError {
+		return logTail(logsPath, maxTerminationMessageLogLines, maxTerminationMessageLogLength)
+	}
+
+	return ""
+}
+
+// readFileHead returns up to maxBytes from the beginning of a file, or an empty string if the file cannot be read.
+func readFileHead(path string, maxBytes int64) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxBytes))
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// logTail returns the last lines of a container log (in the CRI format), bounded by both maxLines and maxBytes.
+func logTail(path string, maxLines int, maxBytes int) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	/*-- Scan only the end of the log. The first line of the window may be incomplete, so it is dropped --*/
+	info, err := f.Stat()
+	if err != nil {
+		return ""
+	}
+
+	var window io.Reader = f
+
+	if info.Size() > terminationMessageLogWindow {
+		if _, err := f.Seek(info.Size()-terminationMessageLogWindow, io.SeekStart); err != nil {
+			return ""
+		}
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			return ""
+		}
+
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+
+		window = bytes.NewReader(data)
+	}
+
+	var decoded strings.Builder
+	if err := kubecontainer.ReadLogs(window, &decoded, &kubecontainer.LogOptions{}); err != nil {
+		return ""
+	}
+
+	/*-- Keep the last lines --*/
+	lines := strings.SplitAfter(decoded.String(), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) > maxLines {
+		lines = lines[len(lines)-maxLines:]
+	}
+
+	tail := strings.Join(lines, "")
+
+	if len(tail) > maxBytes {
+		tail = tail[len(tail)-maxBytes:]
+	}
+
+	return tail
+}
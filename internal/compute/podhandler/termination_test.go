@@ -0,0 +1,115 @@
+package podhandler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"hpk/internal/compute/endpoint"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestTerminationMessage(t *testing.T) {
+	podPath := endpoint.HPK(t.TempDir()).Pod(client.ObjectKey{Namespace: "default", Name: "test"})
+	containerPath := podPath.Container("main")
+
+	if err := os.MkdirAll(podPath.LogDir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	var logs strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&logs, "2023-05-01T10:00:00.000000000Z stdout F line-%d\n", i)
+	}
+
+	if err := os.WriteFile(containerPath.LogsPath(), []byte(logs.String()), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	container := &corev1.Container{
+		Name:                     "main",
+		TerminationMessagePath:   "/dev/termination-log",
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	/*-- Without a message, failed containers fall back to the tail of their logs --*/
+	tail := terminationMessage(containerPath, container, 1)
+	if lines := strings.Split(strings.TrimSuffix(tail, "\n"), "\n"); len(lines) != maxTerminationMessageLogLines ||
+		lines[len(lines)-1] != "line-99" {
+		t.Errorf("unexpected log tail %q", tail)
+	}
+
+	if message := terminationMessage(containerPath, container, 0); message != "" {
+		t.Errorf("expected no message for successful container, got %q", message)
+	}
+
+	/*-- The message of the container is preferred, and it is capped --*/
+	long := strings.Repeat("x", MaxTerminationMessageLength+10)
+	if err := os.WriteFile(containerPath.TerminationMessagePath(), []byte(long), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if message := terminationMessage(containerPath, container, 1); len(message) != MaxTerminationMessageLength {
+		t.Errorf("expected a message of %d bytes, got %d", MaxTerminationMessageLength, len(message))
+	}
+}
+
+func TestPreviousTerminationMessage(t *testing.T) {
+	podPath := endpoint.HPK(t.TempDir()).Pod(client.ObjectKey{Namespace: "default", Name: "test"})
+	containerPath := podPath.Container("main")
+
+	if err := os.MkdirAll(podPath.LogDir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	container := &corev1.Container{
+		Name:                     "main",
+		TerminationMessagePath:   "/dev/termination-log",
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+
+	/*-- The previous instance falls back to its own logs, rather than those of the running instance --*/
+	for path, line := range map[string]string{containerPath.PreviousLogsPath(): "previous", containerPath.LogsPath(): "current"} {
+		if err := os.WriteFile(path, []byte("2023-05-01T10:00:00.000000000Z stdout F "+line+"\n"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if message := previousTerminationMessage(containerPath, container, 137); message != "previous\n" {
+		t.Errorf("got message %q, want the log tail of the previous instance", message)
+	}
+
+	/*-- The message of the previous instance is preferred --*/
+	if err := os.WriteFile(containerPath.PreviousTerminationMessagePath(), []byte("deadlock"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if message := previousTerminationMessage(containerPath, container, 137); message != "deadlock" {
+		t.Errorf("got message %q, want the message of the previous instance", message)
+	}
+}
+
+func TestLastTermination(t *testing.T) {
+	podPath := endpoint.HPK(t.TempDir()).Pod(client.ObjectKey{Namespace: "default", Name: "test"})
+	containerPath := podPath.Container("main")
+
+	if err := os.MkdirAll(podPath.ControlFileDir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	/*-- Without a record, the container is assumed to have failed its liveness probe --*/
+	if reason, exitCode := lastTermination(containerPath); reason != "Unhealthy" || exitCode != 137 {
+		t.Errorf("got (%s, %d), want (Unhealthy, 137)", reason, exitCode)
+	}
+
+	if err := os.WriteFile(containerPath.LastTerminationPath(), []byte("Error 2"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if reason, exitCode := lastTermination(containerPath); reason != "Error" || exitCode != 2 {
+		t.Errorf("got (%s, %d), want (Error, 2)", reason, exitCode)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package podhandler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/volume/configmap"
+	"hpk/internal/compute/volume/downwardapi"
+	"hpk/internal/compute/volume/projected"
+	"hpk/internal/compute/volume/secret"
+
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// refreshLock serializes the refreshes, since the atomic writer of a volume must not be used concurrently.
+var refreshLock sync.Mutex
+
+// RefreshConfigMapVolumes re-projects the configMap into the volumes of the running pods that reference it.
+func RefreshConfigMapVolumes(ctx context.Context, configMap *corev1.ConfigMap) {
+	refreshPods(ctx, configMap.GetNamespace(), func(vol corev1.Volume) bool {
+		if vol.ConfigMap != nil {
+			return vol.ConfigMap.Name == configMap.GetName()
+		}
+
+		if vol.Projected != nil {
+			for _, source := range vol.Projected.Sources {
+				if source.ConfigMap != nil && source.ConfigMap.Name == configMap.GetName() {
+					return true
+				}
+			}
+		}
+
+		return false
+	})
+}
+
+// RefreshSecretVolumes re-projects the secret into the volumes of the running pods that reference it.
+func RefreshSecretVolumes(ctx context.Context, secret *corev1.Secret) {
+	refreshPods(ctx, secret.GetNamespace(), func(vol corev1.Volume) bool {
+		if vol.Secret != nil {
+			return vol.Secret.SecretName == secret.GetName()
+		}
+
+		if vol.Projected != nil {
+			for _, source := range vol.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == secret.GetName() {
+					return true
+				}
+			}
+		}
+
+		return false
+	})
+}
+
+// RefreshDownwardAPIVolumes re-projects the fields of the pod into its downwardAPI volumes, including
+// the projected ones. The pod is expected to carry the new labels and annotations.
+func RefreshDownwardAPIVolumes(ctx context.Context, pod *corev1.Pod) error {
+	return RefreshVolumes(ctx, pod, func(vol corev1.Volume) bool {
+		if vol.DownwardAPI != nil {
+			return true
+		}
+
+		if vol.Projected != nil {
+			for _, source := range vol.Projected.Sources {
+				if source.DownwardAPI != nil {
+					return true
+				}
+			}
+		}
+
+		return false
+	})
+}
+
+// refreshPods refreshes the matching volumes of the pods in the namespace that are not terminated.
+// Pods are in the store only after their volumes have been mounted, so a refresh never races with the setup.
+func refreshPods(ctx context.Context, namespace string, match func(corev1.Volume) bool) {
+	for _, pod := range Pods.List() {
+		if pod.GetNamespace() != namespace ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		if err := RefreshVolumes(ctx, pod, match); err != nil {
+			compute.DefaultLogger.Info("failed to refresh volumes", "pod", client.ObjectKeyFromObject(pod), "error", err)
+		}
+	}
+}
+
+// RefreshVolumes re-projects the configMap, secret, projected and downwardAPI volumes of the pod for which match
+// returns true. The files are replaced atomically, so the containers see either the old or the new payload.
+//
+// As in upstream, volumes that are mounted with subPath are not updated: the bind mount pins the file that
+// existed when the container started.
+func RefreshVolumes(ctx context.Context, pod *corev1.Pod, match func(corev1.Volume) bool) error {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+
+	var errs []error
+
+	for _, vol := range pod.Spec.Volumes {
+		if !match(vol) {
+			continue
+		}
+
+		dir := filepath.Join(compute.HPK.Pod(client.ObjectKeyFromObject(pod)).VolumeDir(), vol.Name)
+
+		if _, err := os.Stat(dir); err != nil {
+			// the volume is not materialized in the pod directory, or it has already been removed.
+			continue
+		}
+
+		var err error
+
+		switch {
+		case vol.ConfigMap != nil:
+			mounter := configmap.VolumeMounter{Volume: vol, Pod: *pod, Logger: compute.DefaultLogger}
+			err = mounter.Refresh(ctx, dir)
+
+		case vol.Secret != nil:
+			mounter := secret.VolumeMounter{Volume: vol, Pod: *pod, Logger: compute.DefaultLogger}
+			err = mounter.Refresh(ctx, dir)
+
+		case vol.DownwardAPI != nil:
+			mounter := downwardapi.VolumeMounter{Volume: vol, Pod: *pod, Logger: compute.DefaultLogger}
+			err = mounter.Refresh(ctx, dir)
+
+		case vol.Projected != nil:
+			mounter := projected.VolumeMounter{Volume: vol, Pod: *pod, Logger: compute.DefaultLogger}
+			err = mounter.Refresh(ctx, dir)
+
+		default:
+			continue
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("volume '%s': %w", vol.Name, err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
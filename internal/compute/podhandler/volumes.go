@@ -17,7 +17,6 @@ package podhandler
 import (
 	"context"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"time"
@@ -25,6 +24,7 @@ import (
 	"errors"
 	"hpk/internal/compute/endpoint"
 	"hpk/internal/compute/volume/configmap"
+	"hpk/internal/compute/volume/downwardapi"
 	"hpk/internal/compute/volume/emptydir"
 	"hpk/internal/compute/volume/hostpath"
 	"hpk/internal/compute/volume/projected"
@@ -34,7 +34,6 @@ import (
 	mounter "k8s.io/utils/mount"
 
 	"hpk/internal/compute"
-	"hpk/pkg/fieldpath"
 
 	"github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
@@ -145,7 +144,21 @@ func (h *PodHandler) mountVolumeSource(ctx context.Context, vol corev1.Volume) e
 		/*---------------------------------------------------
 		 * Downward API
 		 *---------------------------------------------------*/
-		h.DownwardAPIVolumeSource(ctx, vol)
+		downwardAPIDir := filepath.Join(h.podDirectory.VolumeDir(), vol.Name)
+
+		if err := os.MkdirAll(downwardAPIDir, endpoint.PodGlobalDirectoryPermissions); err != nil {
+			compute.SystemPanic(err, "cannot create dir '%s'", downwardAPIDir)
+		}
+
+		mounter := downwardapi.VolumeMounter{
+			Volume: vol,
+			Pod:    *h.Pod,
+			Logger: h.logger,
+		}
+
+		if err := mounter.SetUpAt(ctx, downwardAPIDir); err != nil {
+			return fmt.Errorf("failed to mount DownwardAPI '%s': %w", vol.Name, err)
+		}
 
 		h.logger.Info("  * DownwardAPI Volume is mounted", "name", vol.Name)
 
@@ -225,31 +238,7 @@ func (h *PodHandler) mountVolumeSource(ctx context.Context, vol corev1.Volume) e
 		return nil
 
 	default:
-		logrus.Warn(vol)
-
-		panic("It seems I have missed a Volume type")
-	}
-}
-
-func (h *PodHandler) DownwardAPIVolumeSource(ctx context.Context, vol corev1.Volume) {
-	downApiDir := filepath.Join(h.podDirectory.VolumeDir(), vol.Name)
-
-	if err := os.MkdirAll(downApiDir, endpoint.PodGlobalDirectoryPermissions); err != nil {
-		compute.SystemPanic(err, "cannot create dir '%s'", downApiDir)
-	}
-
-	for _, item := range vol.DownwardAPI.Items {
-		itemPath := filepath.Join(downApiDir, item.Path)
-		value, err := fieldpath.ExtractFieldPathAsString(h.Pod, item.FieldRef.FieldPath)
-		if err != nil {
-			compute.PodError(h.Pod, compute.ReasonSpecError, "%v", err)
-
-			return
-		}
-
-		if err := os.WriteFile(itemPath, []byte(value), fs.FileMode(*vol.Projected.DefaultMode)); err != nil {
-			compute.SystemPanic(err, "cannot write config map file '%s'", itemPath)
-		}
+		return fmt.Errorf("volume '%s' is of unsupported type", vol.Name)
 	}
 }
 
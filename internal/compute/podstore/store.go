@@ -0,0 +1,303 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package podstore keeps the pods of hpk-kubelet in memory, and persists them to their pod.crd files.
+package podstore
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/endpoint"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Store is the single source of truth for the state of the pods.
+//
+// Every change is written through to the pod.crd file of the pod, which is read by the pause container,
+// and from which the store is loaded after a restart. Reads are served from memory, without touching
+// the (often shared) working directory.
+//
+// The store hands out copies, so that callers can modify the pods without holding any lock. Modifications
+// of the stored pods are made through Update, which serializes them per pod.
+type Store struct {
+	lock sync.RWMutex
+	pods map[client.ObjectKey]*entry
+
+	// loaded is set once the working directory has been loaded. From then on, a pod that
+	// is not in memory does not exist, and there is no need to look for it on the disk.
+	loaded bool
+}
+
+// entry serializes the writes of a single pod, so that the file and the memory always agree.
+type entry struct {
+	lock sync.Mutex
+	pod  *corev1.Pod
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{pods: make(map[client.ObjectKey]*entry)}
+}
+
+// Load reads the pods of the working directory into the store. Pods without a valid description are skipped.
+func (s *Store) Load() error {
+	pods := make(map[client.ObjectKey]*entry)
+
+	if err := compute.HPK.WalkPodDirectories(func(path endpoint.PodPath) error {
+		pod, err := readPod(path.EncodedJSONPath())
+		if err != nil {
+			compute.DefaultLogger.Info("Ignore Corrupted Pod Dir", "path", path, "error", err)
+
+			// continue with the rest of pods
+			return nil
+		}
+
+		pods[client.ObjectKeyFromObject(pod)] = &entry{pod: pod}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to traverse pods: %w", err)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pods = pods
+	s.loaded = true
+
+	return nil
+}
+
+// Get returns a copy of the pod. The error wraps fs.ErrNotExist if the pod does not exist.
+func (s *Store) Get(key client.ObjectKey) (*corev1.Pod, error) {
+	e, err := s.entryOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.pod == nil {
+		return nil, fmt.Errorf("pod '%s': %w", key, fs.ErrNotExist)
+	}
+
+	return e.pod.DeepCopy(), nil
+}
+
+// entryOf returns the entry of the pod. Before the store is loaded, the pod is looked up on the disk.
+func (s *Store) entryOf(key client.ObjectKey) (*entry, error) {
+	s.lock.RLock()
+	e, ok := s.pods[key]
+	loaded := s.loaded
+	s.lock.RUnlock()
+
+	if ok {
+		return e, nil
+	}
+
+	if loaded {
+		return nil, fmt.Errorf("pod '%s': %w", key, fs.ErrNotExist)
+	}
+
+	/*-- Before loading, fall back to the disk --*/
+	pod, err := readPod(compute.HPK.Pod(key).EncodedJSONPath())
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if e, ok := s.pods[key]; ok {
+		return e, nil
+	}
+
+	e = &entry{pod: pod}
+	s.pods[key] = e
+
+	return e, nil
+}
+
+// List returns copies of all the pods, ordered by namespace and name.
+func (s *Store) List() []*corev1.Pod {
+	s.lock.RLock()
+	entries := make([]*entry, 0, len(s.pods))
+
+	for _, e := range s.pods {
+		entries = append(entries, e)
+	}
+	s.lock.RUnlock()
+
+	pods := make([]*corev1.Pod, 0, len(entries))
+
+	for _, e := range entries {
+		e.lock.Lock()
+		if e.pod != nil {
+			pods = append(pods, e.pod.DeepCopy())
+		}
+		e.lock.Unlock()
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+
+		return pods[i].Name < pods[j].Name
+	})
+
+	return pods
+}
+
+// Save persists the pod atomically to its pod.crd file, and then updates the store with a copy of it.
+//
+// Save replaces the pod as a whole. Changes that are based on the stored pod must go through Update instead,
+// as they would otherwise overwrite the changes that are made between reading the pod and saving it.
+func (s *Store) Save(pod *corev1.Pod) error {
+	if pod == nil {
+		return fmt.Errorf("empty pod")
+	}
+
+	key := client.ObjectKeyFromObject(pod)
+
+	s.lock.Lock()
+	e, ok := s.pods[key]
+
+	if !ok {
+		defer s.lock.Unlock()
+
+		// the pod is added only once it is persisted, so that a failed write leaves nothing behind.
+		if err := writePod(compute.HPK.Pod(key).EncodedJSONPath(), pod); err != nil {
+			return err
+		}
+
+		s.pods[key] = &entry{pod: pod.DeepCopy()}
+
+		return nil
+	}
+	s.lock.Unlock()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	// the pod has been deleted after its entry was looked up.
+	if e.pod == nil {
+		return fmt.Errorf("pod '%s': %w", key, fs.ErrNotExist)
+	}
+
+	if err := writePod(compute.HPK.Pod(key).EncodedJSONPath(), pod); err != nil {
+		return err
+	}
+
+	e.pod = pod.DeepCopy()
+
+	return nil
+}
+
+// Update passes a copy of the pod to update, and then persists and stores the updated pod. The pod is held
+// throughout, so that concurrent updates are applied one after the other, each on the result of the previous.
+// If update returns an error, the pod is left unchanged, and the error is returned.
+func (s *Store) Update(key client.ObjectKey, update func(pod *corev1.Pod) error) error {
+	e, err := s.entryOf(key)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.pod == nil {
+		return fmt.Errorf("pod '%s': %w", key, fs.ErrNotExist)
+	}
+
+	pod := e.pod.DeepCopy()
+
+	if err := update(pod); err != nil {
+		return err
+	}
+
+	if err := writePod(compute.HPK.Pod(key).EncodedJSONPath(), pod); err != nil {
+		return err
+	}
+
+	e.pod = pod.DeepCopy()
+
+	return nil
+}
+
+// Delete removes the pod from the store. The files of the pod are left to the caller.
+func (s *Store) Delete(key client.ObjectKey) {
+	s.lock.Lock()
+	e, ok := s.pods[key]
+	delete(s.pods, key)
+	s.lock.Unlock()
+
+	// writes that already hold the entry are not undone, but the pending ones find the pod deleted.
+	if ok {
+		e.lock.Lock()
+		e.pod = nil
+		e.lock.Unlock()
+	}
+}
+
+func readPod(filePath string) (*corev1.Pod, error) {
+	podDef, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file path %s: %w", filePath, err)
+	}
+
+	if len(podDef) == 0 {
+		return nil, fmt.Errorf("file was empty: %s", filePath)
+	}
+
+	var pod corev1.Pod
+
+	if err := json.Unmarshal(podDef, &pod); err != nil {
+		return nil, fmt.Errorf("failed decoding file '%s': %w", filePath, err)
+	}
+
+	return &pod, nil
+}
+
+// writePod replaces the file with the encoded pod. The new contents are written to a temporary file
+// which is then renamed, so that readers (e.g, the pause container) never see a partially written pod.
+func writePod(filePath string, pod *corev1.Pod) error {
+	podDef, err := json.Marshal(pod)
+	if err != nil {
+		return fmt.Errorf("failed encoding pod: %w", err)
+	}
+
+	tmpPath := filePath + ".tmp"
+
+	if err := os.WriteFile(tmpPath, podDef, endpoint.PodSpecJsonFilePermissions); err != nil {
+		return fmt.Errorf("failed to write file path '%s': %w", tmpPath, err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		_ = os.Remove(tmpPath)
+
+		return fmt.Errorf("failed to replace file path '%s': %w", filePath, err)
+	}
+
+	return nil
+}
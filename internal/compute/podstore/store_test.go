@@ -0,0 +1,347 @@
+package podstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"testing"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/endpoint"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func newPod(t testing.TB, namespace, name string) *corev1.Pod {
+	t.Helper()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: "1"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: "busybox"}}},
+		Status:     corev1.PodStatus{Phase: corev1.PodPending},
+	}
+
+	if err := os.MkdirAll(compute.HPK.Pod(client.ObjectKeyFromObject(pod)).JobDir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	return pod
+}
+
+func TestStore(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	store := New()
+
+	pod := newPod(t, "default", "b")
+	if err := store.Save(pod); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	if err := store.Save(newPod(t, "default", "a")); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	/*-- Callers get copies --*/
+	pod.Status.Phase = corev1.PodRunning
+
+	got, err := store.Get(client.ObjectKeyFromObject(pod))
+	if err != nil || got.Status.Phase != corev1.PodPending {
+		t.Fatalf("got %v (%v), want a pending copy", got, err)
+	}
+
+	got.Status.Phase = corev1.PodFailed
+
+	if again, _ := store.Get(client.ObjectKeyFromObject(pod)); again.Status.Phase != corev1.PodPending {
+		t.Errorf("the stored pod has been modified through a copy")
+	}
+
+	/*-- Writes go through to the disk, without leftovers --*/
+	podPath := compute.HPK.Pod(client.ObjectKeyFromObject(pod))
+
+	if _, err := os.Stat(podPath.EncodedJSONPath() + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file has not been removed")
+	}
+
+	onDisk, err := readPod(podPath.EncodedJSONPath())
+	if err != nil || onDisk.Name != "b" {
+		t.Errorf("got %v (%v) from disk", onDisk, err)
+	}
+
+	/*-- A fresh store is loaded from the disk --*/
+	if err := os.MkdirAll(compute.HPK.Pod(client.ObjectKey{Namespace: "default", Name: "corrupted"}).String(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	loaded := New()
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	pods := loaded.List()
+	if len(pods) != 2 || pods[0].Name != "a" || pods[1].Name != "b" {
+		t.Fatalf("got %d pods, want [a b]", len(pods))
+	}
+
+	/*-- Once loaded, missing pods are not looked up on the disk --*/
+	if err := os.RemoveAll(podPath.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded.Delete(client.ObjectKeyFromObject(pod))
+
+	if _, err := loaded.Get(client.ObjectKeyFromObject(pod)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if len(loaded.List()) != 1 {
+		t.Errorf("deleted pod is still listed")
+	}
+}
+
+func TestStoreFallback(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	pod := newPod(t, "default", "written-elsewhere")
+	if err := writePod(compute.HPK.Pod(client.ObjectKeyFromObject(pod)).EncodedJSONPath(), pod); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	store := New()
+
+	if _, err := store.Get(client.ObjectKey{Namespace: "default", Name: "missing"}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	got, err := store.Get(client.ObjectKeyFromObject(pod))
+	if err != nil || got.Name != pod.Name {
+		t.Errorf("got %v (%v)", got, err)
+	}
+}
+
+func TestStoreConcurrency(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	store := New()
+	pod := newPod(t, "default", "concurrent")
+
+	if err := store.Save(pod); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			for j := 0; j < 20; j++ {
+				local, err := store.Get(client.ObjectKeyFromObject(pod))
+				if err != nil {
+					t.Errorf("get: %v", err)
+					return
+				}
+
+				local.ResourceVersion = fmt.Sprintf("%d-%d", i, j)
+
+				if err := store.Save(local); err != nil {
+					t.Errorf("save: %v", err)
+					return
+				}
+
+				_ = store.List()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	/*-- The memory and the disk agree on the last write --*/
+	inMemory, _ := store.Get(client.ObjectKeyFromObject(pod))
+
+	onDisk, err := readPod(compute.HPK.Pod(client.ObjectKeyFromObject(pod)).EncodedJSONPath())
+	if err != nil || onDisk.ResourceVersion != inMemory.ResourceVersion {
+		t.Errorf("disk has version %q (%v), memory has %q", onDisk.ResourceVersion, err, inMemory.ResourceVersion)
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	store := New()
+	pod := newPod(t, "default", "updated")
+	key := client.ObjectKeyFromObject(pod)
+
+	if err := store.Save(pod); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	/*-- Concurrent updates are applied on top of each other --*/
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if err := store.Update(key, func(pod *corev1.Pod) error {
+				pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+					EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: fmt.Sprintf("debugger-%d", i)},
+				})
+
+				return nil
+			}); err != nil {
+				t.Errorf("update: %v", err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	onDisk, err := readPod(compute.HPK.Pod(key).EncodedJSONPath())
+	if err != nil || len(onDisk.Spec.EphemeralContainers) != 8 {
+		t.Fatalf("got %v (%v), want all the updates", onDisk, err)
+	}
+
+	/*-- Failed updates leave the pod unchanged --*/
+	if err := store.Update(key, func(pod *corev1.Pod) error {
+		pod.Status.Phase = corev1.PodFailed
+
+		return errors.New("discarded")
+	}); err == nil {
+		t.Error("expected the error of the update")
+	}
+
+	if got, _ := store.Get(key); got.Status.Phase != corev1.PodPending {
+		t.Errorf("got phase %s, want the pod unchanged", got.Status.Phase)
+	}
+
+	/*-- Deleted pods are neither updated nor left behind by late saves --*/
+	store.Delete(key)
+
+	if err := os.RemoveAll(compute.HPK.Pod(key).String()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Update(key, func(*corev1.Pod) error { return nil }); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected not found, got %v", err)
+	}
+
+	if err := store.Save(pod); err == nil {
+		t.Error("expected an error for a pod without a directory")
+	}
+
+	if _, ok := store.pods[key]; ok {
+		t.Error("a failed save has left an entry behind")
+	}
+}
+
+/*---------------------------------------------------
+ * Benchmarks
+ *---------------------------------------------------*/
+
+const benchmarkPods = 5000
+
+// populate writes benchmarkPods pods across a few namespaces, and returns a store that is loaded from them.
+func populate(b *testing.B) (*Store, []client.ObjectKey) {
+	b.Helper()
+
+	compute.HPK = endpoint.HPK(b.TempDir())
+
+	keys := make([]client.ObjectKey, 0, benchmarkPods)
+	writer := New()
+
+	for i := 0; i < benchmarkPods; i++ {
+		pod := newPod(b, fmt.Sprintf("ns-%d", i%10), fmt.Sprintf("pod-%d", i))
+
+		if err := writer.Save(pod); err != nil {
+			b.Fatalf("save: %v", err)
+		}
+
+		keys = append(keys, client.ObjectKeyFromObject(pod))
+	}
+
+	store := New()
+	if err := store.Load(); err != nil {
+		b.Fatalf("load: %v", err)
+	}
+
+	return store, keys
+}
+
+func BenchmarkGet(b *testing.B) {
+	store, keys := populate(b)
+
+	b.Run("memory", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := store.Get(keys[i%len(keys)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("disk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := readPod(compute.HPK.Pod(keys[i%len(keys)]).EncodedJSONPath()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				if _, err := store.Get(keys[i%len(keys)]); err != nil {
+					b.Error(err)
+					return
+				}
+				i++
+			}
+		})
+	})
+}
+
+func BenchmarkList(b *testing.B) {
+	store, _ := populate(b)
+
+	b.Run("memory", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if pods := store.List(); len(pods) != benchmarkPods {
+				b.Fatalf("got %d pods", len(pods))
+			}
+		}
+	})
+
+	b.Run("disk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := New().Load(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkSave(b *testing.B) {
+	store, keys := populate(b)
+
+	pods := make([]*corev1.Pod, len(keys))
+	for i, key := range keys {
+		pods[i], _ = store.Get(key)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := store.Save(pods[i%len(pods)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
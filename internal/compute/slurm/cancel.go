@@ -67,12 +67,11 @@ func CancelJob(args string) (string, error) {
 // Returns an error if the process cannot be terminated.
 func KillProcessByPID(pid string) (string, error) {
 	/*
-	 Install trap for the signals INT and TERM to
-	 terminate the process and its children.
-	 Send SIGTERM using kill to the main process
-	 and wait for it to close gracefully.
+	 Send SIGTERM to the main process, which apptainer forwards to hpk-pause.
+	 In turn, hpk-pause runs the preStop hooks and stops the containers
+	 within the termination grace period of the pod.
 	*/
-	out, err := process.Execute("kill", "-9", pid)
+	out, err := process.Execute("kill", "-TERM", pid)
 	if err != nil {
 		outStr := string(out)
 
@@ -14,6 +14,17 @@
 
 package slurm
 
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"hpk/internal/compute"
+	"hpk/pkg/process"
+)
+
 /************************************************************
 
 			Initiate Slurm Connector
@@ -35,8 +46,66 @@ var Slurm struct {
 	SqueueCmd string
 }
 
+// PingTimeout is how long sinfo and squeue have to respond, before Slurm is considered unreachable.
+var PingTimeout = 10 * time.Second
+
+// CacheTTL is how long the responses of Slurm are reused. The virtual nodes refresh their status
+// at the same pace, and there is no need to query Slurm once for each of them.
+var CacheTTL = 5 * time.Second
+
+// cached memoizes the result of a query to Slurm for CacheTTL. Concurrent callers wait for a single query.
+type cached[T any] struct {
+	lock      sync.Mutex
+	value     T
+	err       error
+	fetchedAt time.Time
+}
+
+func (c *cached[T]) get(fetch func() (T, error)) (T, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.fetchedAt.IsZero() || time.Since(c.fetchedAt) >= CacheTTL {
+		c.value, c.err = fetch()
+		c.fetchedAt = time.Now()
+	}
+
+	return c.value, c.err
+}
+
+var pingCache cached[struct{}]
+
+// Ping returns an error if the Slurm controller does not respond to sinfo and squeue.
+// Without Slurm, there is nothing to check.
+func Ping(ctx context.Context) error {
+	if !compute.Environment.RunSlurm {
+		return nil
+	}
+
+	_, err := pingCache.get(func() (struct{}, error) {
+		return struct{}{}, ping(ctx)
+	})
+
+	return err
+}
+
+func ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
+
+	if out, err := process.ExecuteContext(ctx, Slurm.StatsCmd, "--noheader", "--summarize"); err != nil {
+		return fmt.Errorf("%s is not responding: %w (out: '%s')", Slurm.StatsCmd, err, strings.TrimSpace(string(out)))
+	}
+
+	if out, err := process.ExecuteContext(ctx, Slurm.SqueueCmd, "--noheader", "--me", "--format=%i"); err != nil {
+		return fmt.Errorf("%s is not responding: %w (out: '%s')", Slurm.SqueueCmd, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
 // ConnectionOK return true if HPK maintains connection with the Slurm manager.
 // Otherwise, it returns false.
 func ConnectionOK() bool {
-	return true
+	return Ping(context.Background()) == nil
 }
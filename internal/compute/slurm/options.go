@@ -0,0 +1,361 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// Annotations through which pods request Slurm options.
+const (
+	AnnotationPartition   = "slurm.hpk.io/partition"
+	AnnotationAccount     = "slurm.hpk.io/account"
+	AnnotationQOS         = "slurm.hpk.io/qos"
+	AnnotationTimeLimit   = "slurm.hpk.io/time-limit"
+	AnnotationConstraint  = "slurm.hpk.io/constraint"
+	AnnotationReservation = "slurm.hpk.io/reservation"
+	AnnotationExclusive   = "slurm.hpk.io/exclusive"
+	AnnotationNice        = "slurm.hpk.io/nice"
+
+	// AnnotationFlags holds raw sbatch flags, separated by spaces. It is accepted only if the policy allows it.
+	AnnotationFlags = "slurm.hpk.io/flags"
+)
+
+// MaxNice is the largest adjustment that Slurm accepts for --nice.
+const MaxNice = 2147483645
+
+// Policy is the allow-list, defined by the administrator, against which the Slurm options of the pods are validated.
+// Empty lists and zero limits do not restrict the respective option.
+type Policy struct {
+	Partitions   []string `json:"partitions,omitempty"`
+	Accounts     []string `json:"accounts,omitempty"`
+	QOS          []string `json:"qos,omitempty"`
+	Reservations []string `json:"reservations,omitempty"`
+
+	// Features are the node features that may appear in a constraint.
+	Features []string `json:"features,omitempty"`
+
+	// MaxTimeLimit is the longest time limit that a pod may request.
+	MaxTimeLimit metav1.Duration `json:"maxTimeLimit,omitempty"`
+
+	// MaxNice is the largest nice value that a pod may request. Negative values are never accepted,
+	// since they raise the priority of the job.
+	MaxNice int `json:"maxNice,omitempty"`
+
+	// DisallowExclusive rejects the pods that request exclusive nodes.
+	DisallowExclusive bool `json:"disallowExclusive,omitempty"`
+
+	// AllowCustomFlags accepts raw sbatch flags via AnnotationFlags. They bypass the validation,
+	// so they are rejected unless explicitly allowed.
+	AllowCustomFlags bool `json:"allowCustomFlags,omitempty"`
+
+	// PriorityClasses maps the priorityClassName of pods to Slurm options. Mapped options are trusted,
+	// and they are overridden by the annotations of the pod.
+	PriorityClasses map[string]PriorityClassOptions `json:"priorityClasses,omitempty"`
+}
+
+// PriorityClassOptions are the Slurm options of the pods that have a priority class.
+type PriorityClassOptions struct {
+	QOS  string `json:"qos,omitempty"`
+	Nice *int   `json:"nice,omitempty"`
+}
+
+// DefaultPolicy is the policy of hpk-kubelet. It is replaced by LoadPolicy on startup.
+var DefaultPolicy = &Policy{}
+
+// LoadPolicy reads a policy from a YAML or JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy '%s': %w", path, err)
+	}
+
+	if policy.MaxTimeLimit.Duration < 0 || policy.MaxNice < 0 {
+		return nil, fmt.Errorf("policy '%s': limits must not be negative", path)
+	}
+
+	return &policy, nil
+}
+
+// JobOptions are the validated Slurm options of a pod.
+type JobOptions struct {
+	Partition   string
+	Account     string
+	QOS         string
+	TimeLimit   string
+	Constraint  string
+	Reservation string
+	Exclusive   bool
+	Nice        *int
+
+	// CustomFlags are raw sbatch flags, given via AnnotationFlags.
+	CustomFlags []string
+}
+
+var (
+	namePattern       = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	constraintPattern = regexp.MustCompile(`^[A-Za-z0-9_.\-&|,()\[\]*]+$`)
+)
+
+// JobOptions returns the Slurm options of the pod, as requested by its annotations and its priority class.
+//
+// Pods on a partition node are always submitted to that partition, and the features of the node are added
+// to their constraint. All violations of the policy are reported together, against the offending annotations.
+func (p *Policy) JobOptions(pod *corev1.Pod, partition Partition) (JobOptions, error) {
+	var (
+		opts        JobOptions
+		errs        field.ErrorList
+		annotations = pod.GetAnnotations()
+		path        = field.NewPath("metadata", "annotations")
+	)
+
+	/*-- Defaults of the priority class --*/
+	if mapped, ok := p.PriorityClasses[pod.Spec.PriorityClassName]; ok && pod.Spec.PriorityClassName != "" {
+		opts.QOS = mapped.QOS
+		opts.Nice = mapped.Nice
+	}
+
+	/*-- Names --*/
+	names := []struct {
+		key     string
+		allowed []string
+		value   *string
+	}{
+		{AnnotationPartition, p.Partitions, &opts.Partition},
+		{AnnotationAccount, p.Accounts, &opts.Account},
+		{AnnotationQOS, p.QOS, &opts.QOS},
+		{AnnotationReservation, p.Reservations, &opts.Reservation},
+	}
+
+	for _, name := range names {
+		value, ok := annotations[name.key]
+		if !ok {
+			continue
+		}
+
+		switch {
+		case !namePattern.MatchString(value):
+			errs = append(errs, field.Invalid(path.Key(name.key), value, "must consist of alphanumeric characters, '_', '.' or '-'"))
+		case len(name.allowed) > 0 && !contains(name.allowed, value):
+			errs = append(errs, field.NotSupported(path.Key(name.key), value, name.allowed))
+		default:
+			*name.value = value
+		}
+	}
+
+	if partition.Name != "" {
+		if opts.Partition != "" && opts.Partition != partition.Name {
+			errs = append(errs, field.Invalid(path.Key(AnnotationPartition), opts.Partition,
+				fmt.Sprintf("the pod is scheduled to a node of partition '%s'", partition.Name)))
+		}
+
+		opts.Partition = partition.Name
+	}
+
+	/*-- Time Limit --*/
+	if value, ok := annotations[AnnotationTimeLimit]; ok {
+		limit, err := ParseTimeLimit(value)
+
+		switch {
+		case err != nil:
+			errs = append(errs, field.Invalid(path.Key(AnnotationTimeLimit), value, err.Error()))
+		case p.MaxTimeLimit.Duration > 0 && limit > p.MaxTimeLimit.Duration:
+			errs = append(errs, field.Invalid(path.Key(AnnotationTimeLimit), value,
+				fmt.Sprintf("must not exceed %s", FormatTimeLimit(p.MaxTimeLimit.Duration))))
+		default:
+			opts.TimeLimit = FormatTimeLimit(limit)
+		}
+	}
+
+	/*-- Constraint --*/
+	var constraints []string
+
+	if partition.Constraint() != "" {
+		constraints = append(constraints, partition.Constraint())
+	}
+
+	if value, ok := annotations[AnnotationConstraint]; ok {
+		if err := p.validateConstraint(value); err != "" {
+			errs = append(errs, field.Invalid(path.Key(AnnotationConstraint), value, err))
+		} else if len(constraints) > 0 {
+			constraints = append(constraints, "("+value+")")
+		} else {
+			constraints = append(constraints, value)
+		}
+	}
+
+	opts.Constraint = strings.Join(constraints, "&")
+
+	/*-- Exclusive --*/
+	if value, ok := annotations[AnnotationExclusive]; ok {
+		exclusive, err := strconv.ParseBool(value)
+
+		switch {
+		case err != nil:
+			errs = append(errs, field.Invalid(path.Key(AnnotationExclusive), value, "must be a boolean"))
+		case exclusive && p.DisallowExclusive:
+			errs = append(errs, field.Forbidden(path.Key(AnnotationExclusive), "exclusive nodes are not allowed"))
+		default:
+			opts.Exclusive = exclusive
+		}
+	}
+
+	/*-- Nice --*/
+	if value, ok := annotations[AnnotationNice]; ok {
+		maxNice := MaxNice
+		if p.MaxNice > 0 {
+			maxNice = p.MaxNice
+		}
+
+		nice, err := strconv.Atoi(value)
+
+		switch {
+		case err != nil:
+			errs = append(errs, field.Invalid(path.Key(AnnotationNice), value, "must be an integer"))
+		case nice < 0 || nice > maxNice:
+			errs = append(errs, field.Invalid(path.Key(AnnotationNice), value, fmt.Sprintf("must be between 0 and %d", maxNice)))
+		default:
+			opts.Nice = &nice
+		}
+	}
+
+	/*-- Custom Flags --*/
+	if value, ok := annotations[AnnotationFlags]; ok {
+		flags := strings.Fields(value)
+
+		switch {
+		case !p.AllowCustomFlags:
+			errs = append(errs, field.Forbidden(path.Key(AnnotationFlags), "custom flags are disabled, use the structured slurm.hpk.io annotations"))
+		default:
+			for _, flag := range flags {
+				if !strings.HasPrefix(flag, "-") {
+					errs = append(errs, field.Invalid(path.Key(AnnotationFlags), value, fmt.Sprintf("'%s' is not a flag", flag)))
+
+					break
+				}
+			}
+
+			opts.CustomFlags = flags
+		}
+	}
+
+	return opts, errs.ToAggregate()
+}
+
+// validateConstraint returns the reason for which the constraint is invalid, or an empty string.
+func (p *Policy) validateConstraint(constraint string) string {
+	if !constraintPattern.MatchString(constraint) {
+		return "must consist of features and the operators '&', '|', ',', '*', '(', ')', '[' and ']'"
+	}
+
+	if len(p.Features) == 0 {
+		return ""
+	}
+
+	features := strings.FieldsFunc(constraint, func(r rune) bool {
+		return strings.ContainsRune("&|,()[]*", r)
+	})
+
+	for _, feature := range features {
+		// the count of nodes that follows '*'.
+		if _, err := strconv.Atoi(feature); err == nil {
+			continue
+		}
+
+		if !contains(p.Features, feature) {
+			return fmt.Sprintf("feature '%s' is not allowed", feature)
+		}
+	}
+
+	return ""
+}
+
+// ParseTimeLimit parses a time limit in any of the formats of sbatch: "minutes", "minutes:seconds",
+// "hours:minutes:seconds", "days-hours", "days-hours:minutes" and "days-hours:minutes:seconds".
+func ParseTimeLimit(value string) (time.Duration, error) {
+	invalid := fmt.Errorf("must be in the form of [days-]hours:minutes:seconds, or minutes")
+
+	var days int64
+
+	rest := value
+
+	if before, after, found := strings.Cut(value, "-"); found {
+		d, err := strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			return 0, invalid
+		}
+
+		days, rest = int64(d), after
+	}
+
+	var parts []int64
+
+	for _, part := range strings.Split(rest, ":") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return 0, invalid
+		}
+
+		parts = append(parts, int64(n))
+	}
+
+	var hours, minutes, seconds int64
+
+	switch {
+	case len(parts) > 3:
+		return 0, invalid
+	case value != rest:
+		// with days, the first field is hours.
+		parts = append(parts, 0, 0)
+		hours, minutes, seconds = parts[0], parts[1], parts[2]
+	case len(parts) == 3:
+		hours, minutes, seconds = parts[0], parts[1], parts[2]
+	case len(parts) == 2:
+		minutes, seconds = parts[0], parts[1]
+	default:
+		minutes = parts[0]
+	}
+
+	total := ((days*24+hours)*60+minutes)*60 + seconds
+	if total <= 0 || total > math.MaxInt64/int64(time.Second) {
+		return 0, fmt.Errorf("must be positive")
+	}
+
+	return time.Duration(total) * time.Second, nil
+}
+
+// FormatTimeLimit formats the duration as "days-hours:minutes:seconds".
+func FormatTimeLimit(d time.Duration) string {
+	seconds := int64(d.Round(time.Second) / time.Second)
+
+	return fmt.Sprintf("%d-%02d:%02d:%02d", seconds/86400, seconds/3600%24, seconds/60%60, seconds%60)
+}
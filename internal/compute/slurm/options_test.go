@@ -0,0 +1,114 @@
+package slurm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func podWith(priorityClass string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "job", Annotations: annotations},
+		Spec:       corev1.PodSpec{PriorityClassName: priorityClass},
+	}
+}
+
+func TestJobOptions(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.yaml")
+
+	if err := os.WriteFile(policyFile, []byte(`
+accounts: [physics, biology]
+qos: [normal, long]
+features: [ib, v100]
+maxTimeLimit: 48h
+maxNice: 100
+disallowExclusive: true
+priorityClasses:
+  low:
+    qos: scavenger
+    nice: 50
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := LoadPolicy(policyFile)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	/*-- Valid annotations are rendered, on top of the priority class --*/
+	opts, err := policy.JobOptions(podWith("low", map[string]string{
+		AnnotationAccount:    "physics",
+		AnnotationTimeLimit:  "1-12",
+		AnnotationConstraint: "ib|v100",
+	}), Partition{Name: "gpu", Features: []string{"v100"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if opts.Partition != "gpu" || opts.Account != "physics" || opts.QOS != "scavenger" || opts.Nice == nil || *opts.Nice != 50 {
+		t.Errorf("got %+v", opts)
+	}
+
+	if opts.TimeLimit != "1-12:00:00" || opts.Constraint != "v100&(ib|v100)" {
+		t.Errorf("got time limit %q and constraint %q", opts.TimeLimit, opts.Constraint)
+	}
+
+	/*-- All violations are reported --*/
+	_, err = policy.JobOptions(podWith("", map[string]string{
+		AnnotationPartition:  "cpu",
+		AnnotationAccount:    "chemistry",
+		AnnotationQOS:        "normal\n#SBATCH --qos=high",
+		AnnotationTimeLimit:  "3-00:00:00",
+		AnnotationConstraint: "ib&a100",
+		AnnotationExclusive:  "true",
+		AnnotationNice:       "-10",
+		AnnotationFlags:      "--mem=1T",
+	}), Partition{Name: "gpu"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	for _, key := range []string{
+		AnnotationPartition, AnnotationAccount, AnnotationQOS, AnnotationTimeLimit,
+		AnnotationConstraint, AnnotationExclusive, AnnotationNice, AnnotationFlags,
+	} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("%s is not reported in: %v", key, err)
+		}
+	}
+
+	/*-- Custom flags are accepted only if allowed --*/
+	policy.AllowCustomFlags = true
+
+	opts, err = policy.JobOptions(podWith("", map[string]string{AnnotationFlags: "--mem=1G  --gres=gpu:1"}), Partition{})
+	if err != nil || len(opts.CustomFlags) != 2 {
+		t.Errorf("got %v (%v)", opts.CustomFlags, err)
+	}
+}
+
+func TestParseTimeLimit(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"30":         30 * time.Minute,
+		"30:15":      30*time.Minute + 15*time.Second,
+		"2:30:00":    150 * time.Minute,
+		"1-2":        26 * time.Hour,
+		"1-2:30":     26*time.Hour + 30*time.Minute,
+		"1-02:30:15": 26*time.Hour + 30*time.Minute + 15*time.Second,
+	} {
+		if got, err := ParseTimeLimit(value); err != nil || got != want {
+			t.Errorf("%s: got %v (%v), want %v", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "0", "1h", "1:2:3:4", "-5", "1-", "UNLIMITED"} {
+		if _, err := ParseTimeLimit(value); err == nil {
+			t.Errorf("%q: expected an error", value)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slurm
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Partition is a set of Slurm nodes to which jobs are submitted as a whole. The zero value is the entire cluster.
+type Partition struct {
+	// Name of the Slurm partition. If empty, jobs are submitted to the default partition.
+	Name string
+
+	// Features narrow the partition to the nodes that have all of them.
+	Features []string
+
+	// ExactFeatures narrows the partition further to the nodes that have no other features, so that the
+	// partitions of the distinct feature sets of the nodes do not overlap.
+	ExactFeatures bool
+}
+
+// Contains returns true if the node belongs to the partition.
+func (p Partition) Contains(node NodeInfo) bool {
+	if p.Name != "" && !contains(node.Partitions, p.Name) {
+		return false
+	}
+
+	for _, feature := range p.Features {
+		if !contains(node.Features, feature) {
+			return false
+		}
+	}
+
+	if p.ExactFeatures {
+		for _, feature := range node.Features {
+			if !contains(p.Features, feature) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Constraint returns the argument of --constraint that selects the features of the partition.
+//
+// Slurm cannot exclude features in a constraint, so jobs may also run on nodes that have additional features,
+// even if the partition has ExactFeatures. Such nodes are counted in the capacity of their own partition only.
+func (p Partition) Constraint() string {
+	return strings.Join(p.Features, "&")
+}
+
+// String returns the partition in the form of "name" or "name[feature,feature]".
+func (p Partition) String() string {
+	if len(p.Features) == 0 {
+		return p.Name
+	}
+
+	return p.Name + "[" + strings.Join(p.Features, ",") + "]"
+}
+
+// Partitions returns the partitions of the cluster, ordered by name. If byFeatures is set, every partition
+// is further split into the distinct feature sets of its nodes, each of which has ExactFeatures.
+func Partitions(ctx context.Context, byFeatures bool) ([]Partition, error) {
+	stats, err := clusterStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return partitionsOf(stats.Nodes, byFeatures), nil
+}
+
+func partitionsOf(nodes []NodeInfo, byFeatures bool) []Partition {
+	unique := make(map[string]Partition)
+
+	for _, node := range nodes {
+		var features []string
+
+		if byFeatures {
+			features = append(features, node.Features...)
+			sort.Strings(features)
+		}
+
+		for _, name := range node.Partitions {
+			partition := Partition{Name: name, Features: features, ExactFeatures: byFeatures}
+
+			unique[partition.String()] = partition
+		}
+	}
+
+	partitions := make([]Partition, 0, len(unique))
+
+	for _, partition := range unique {
+		partitions = append(partitions, partition)
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].String() < partitions[j].String()
+	})
+
+	return partitions
+}
+
+// FeatureList decodes the features of a node, which older versions of Slurm report
+// as a comma-separated string, and newer ones as a list.
+type FeatureList []string
+
+func (f *FeatureList) UnmarshalJSON(data []byte) error {
+	var list []string
+
+	if err := json.Unmarshal(data, &list); err == nil {
+		*f = list
+
+		return nil
+	}
+
+	var joined string
+
+	if err := json.Unmarshal(data, &joined); err != nil {
+		return err
+	}
+
+	*f = nil
+
+	for _, feature := range strings.Split(joined, ",") {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			*f = append(*f, feature)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package slurm
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPartitionsOf(t *testing.T) {
+	nodes := []NodeInfo{
+		{Name: "n1", Partitions: []string{"gpu", "all"}, Features: FeatureList{"v100", "ib"}},
+		{Name: "n2", Partitions: []string{"gpu", "all"}, Features: FeatureList{"ib", "v100"}},
+		{Name: "n3", Partitions: []string{"cpu", "all"}},
+		{Name: "n4", Partitions: []string{"gpu", "all"}, Features: FeatureList{"v100"}},
+	}
+
+	if got, want := partitionsOf(nodes, false), []Partition{{Name: "all"}, {Name: "cpu"}, {Name: "gpu"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	/*-- Nodes with the same features in any order share a partition --*/
+	got := partitionsOf(nodes, true)
+	want := []Partition{
+		{Name: "all", ExactFeatures: true},
+		{Name: "all", Features: []string{"ib", "v100"}, ExactFeatures: true},
+		{Name: "all", Features: []string{"v100"}, ExactFeatures: true},
+		{Name: "cpu", ExactFeatures: true},
+		{Name: "gpu", Features: []string{"ib", "v100"}, ExactFeatures: true},
+		{Name: "gpu", Features: []string{"v100"}, ExactFeatures: true},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if c := want[4].Constraint(); c != "ib&v100" {
+		t.Errorf("got constraint %q", c)
+	}
+
+	/*-- Nodes belong to a partition only if they have all of its features --*/
+	if want[4].Contains(nodes[2]) || !want[4].Contains(nodes[0]) || !(Partition{}).Contains(nodes[2]) {
+		t.Errorf("unexpected membership")
+	}
+
+	/*-- Every node belongs to exactly one of the partitions of the feature sets --*/
+	for _, node := range nodes {
+		var owners []string
+
+		for _, partition := range got {
+			if partition.Contains(node) {
+				owners = append(owners, partition.String())
+			}
+		}
+
+		if len(owners) != len(node.Partitions) {
+			t.Errorf("node %s belongs to %v", node.Name, owners)
+		}
+	}
+
+	/*-- Without exact features, a partition also contains the nodes that have more features --*/
+	if !(Partition{Name: "gpu", Features: []string{"v100"}}).Contains(nodes[0]) {
+		t.Errorf("unexpected membership")
+	}
+}
+
+func TestFeatureList(t *testing.T) {
+	for _, data := range []string{`{"active_features": "ib, v100"}`, `{"active_features": ["ib", "v100"]}`} {
+		var node NodeInfo
+
+		if err := json.Unmarshal([]byte(data), &node); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+
+		if !reflect.DeepEqual(node.Features, FeatureList{"ib", "v100"}) {
+			t.Errorf("%s: got %v", data, node.Features)
+		}
+	}
+}
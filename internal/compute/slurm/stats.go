@@ -18,6 +18,7 @@ package slurm
 import (
 	"bufio"
 	"context"
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -32,6 +33,22 @@ import (
 )
 
 func TotalResources() corev1.ResourceList {
+	resources, err := Resources(context.Background())
+	if err != nil {
+		compute.SystemPanic(err, "failed to get the resources of the cluster")
+	}
+
+	return resources
+}
+
+// Resources returns the total resources of the Slurm nodes, or of the host when jobs do not run on Slurm.
+// Unlike TotalResources, it returns an error if Slurm does not respond in time.
+func Resources(ctx context.Context) (corev1.ResourceList, error) {
+	return Partition{}.Resources(ctx)
+}
+
+// Resources returns the total resources of the Slurm nodes that belong to the partition.
+func (p Partition) Resources(ctx context.Context) (corev1.ResourceList, error) {
 	var (
 		totalCPU       resource.Quantity
 		totalMem       resource.Quantity
@@ -40,7 +57,16 @@ func TotalResources() corev1.ResourceList {
 		totalPods      resource.Quantity
 	)
 	if compute.Environment.RunSlurm {
-		for _, node := range getClusterStats().Nodes {
+		stats, err := clusterStats(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, node := range stats.Nodes {
+			if !p.Contains(node) {
+				continue
+			}
+
 			nodeResources := node.ResourceList()
 
 			if cpu := nodeResources.Cpu(); !cpu.IsZero() {
@@ -91,7 +117,7 @@ func TotalResources() corev1.ResourceList {
 		corev1.ResourceStorage:          totalStorage,
 		corev1.ResourceEphemeralStorage: totalEphemeral,
 		corev1.ResourcePods:             totalPods,
-	}
+	}, nil
 }
 
 func AllocatableResources(ctx context.Context) corev1.ResourceList {
@@ -113,6 +139,9 @@ type NodeInfo struct {
 	//[TODO: temporarily changed it to int64 due to sometimes slurm declares freememory as "-2"]
 	FreeMemory int64    `json:"free_memory"`
 	Partitions []string `json:"partitions"`
+
+	// Features are the active features of the node, which jobs can request with --constraint.
+	Features FeatureList `json:"active_features"`
 }
 
 // ResourceList converts the Slurm-reported stats into Kubernetes-Stats.
@@ -130,19 +159,30 @@ type Stats struct {
 	Nodes []NodeInfo `json:"nodes"`
 }
 
-func getClusterStats() Stats {
-	out, err := process.Execute(Slurm.StatsCmd, "--long", "--json")
-	if err != nil {
-		compute.SystemPanic(err, "stats query error. out : '%s'", out)
-	}
+var statsCache cached[Stats]
+
+func clusterStats(ctx context.Context) (Stats, error) {
+	return statsCache.get(func() (Stats, error) {
+		return queryClusterStats(ctx)
+	})
+}
+
+func queryClusterStats(ctx context.Context) (Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, PingTimeout)
+	defer cancel()
 
 	var info Stats
 
+	out, err := process.ExecuteContext(ctx, Slurm.StatsCmd, "--long", "--json")
+	if err != nil {
+		return info, fmt.Errorf("stats query error. out : '%s': %w", out, err)
+	}
+
 	if err := json.Unmarshal(out, &info); err != nil {
-		compute.SystemPanic(err, "stats decoding error")
+		return info, fmt.Errorf("stats decoding error: %w", err)
 	}
 
-	return info
+	return info, nil
 }
 
 func getTotalMemory() uint64 {
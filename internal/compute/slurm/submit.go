@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"os"
 
-	"hpk/internal/compute"
 	"hpk/pkg/process"
 )
 
@@ -54,7 +53,7 @@ func SubmitJobWithRunSlurm(scriptFile string, runSlurm bool) (string, error) {
 	}
 
 	if err != nil {
-		compute.SystemPanic(err, "job submission error. out : '%s'", out)
+		return "", fmt.Errorf("job submission error: %w. out: '%s'", err, out)
 	}
 
 	var jobID string
@@ -64,9 +63,12 @@ func SubmitJobWithRunSlurm(scriptFile string, runSlurm bool) (string, error) {
 		// Expected format: "Submitted batch job <jobid>"
 		expectedOutput := regexp.MustCompile(`Submitted batch job (?P<jid>\d+)`)
 		jid := expectedOutput.FindStringSubmatch(string(out))
+		if jid == nil {
+			return "", fmt.Errorf("no job id in the output of the submission: '%s'", out)
+		}
 
 		if _, err := strconv.Atoi(jid[1]); err != nil {
-			compute.SystemPanic(err, "Invalid JobID")
+			return "", fmt.Errorf("invalid job id '%s': %w", jid[1], err)
 		}
 
 		jobID = jid[1]
@@ -0,0 +1,42 @@
+package slurm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubmitJobErrors(t *testing.T) {
+	submitCmd := Slurm.SubmitCmd
+	defer func() { Slurm.SubmitCmd = submitCmd }()
+
+	sbatch := func(script string) string {
+		path := filepath.Join(t.TempDir(), "sbatch")
+
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	/*-- The job id is parsed from the output of sbatch --*/
+	Slurm.SubmitCmd = sbatch("echo 'Submitted batch job 42'")
+
+	if jobID, err := SubmitJobWithRunSlurm("job.sh", true); err != nil || jobID != "42" {
+		t.Errorf("got (%q, %v), want job 42", jobID, err)
+	}
+
+	/*-- Failed submissions are reported to the caller --*/
+	Slurm.SubmitCmd = sbatch("echo 'sbatch: error: invalid partition' >&2; exit 1")
+
+	if _, err := SubmitJobWithRunSlurm("job.sh", true); err == nil {
+		t.Error("expected an error for a failed submission")
+	}
+
+	Slurm.SubmitCmd = sbatch("echo 'unexpected output'")
+
+	if _, err := SubmitJobWithRunSlurm("job.sh", true); err == nil {
+		t.Error("expected an error for an output without a job id")
+	}
+}
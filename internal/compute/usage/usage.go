@@ -0,0 +1,420 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package usage measures the resources that are consumed by containers.
+//
+// The processes of a pod are only visible from the pause container, which runs in its own PID namespace and,
+// usually, on a remote Slurm node. Therefore, hpk-pause samples the usage of its containers and writes it to
+// the pod directory, from where hpk-kubelet serves it as statistics.
+package usage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the USER_HZ of /proc/<pid>/stat, which is 100 on all supported architectures.
+const clockTicks = 100
+
+// ProcRoot and CgroupRoot point to the filesystems from which the usage is read.
+var (
+	ProcRoot   = "/proc"
+	CgroupRoot = "/sys/fs/cgroup"
+)
+
+// ContainerUsage is a sample of the resources that are consumed by a container.
+type ContainerUsage struct {
+	// Timestamp is the time at which the sample was taken.
+	Timestamp time.Time `json:"timestamp"`
+
+	// StartTime is the time at which the container was started.
+	StartTime time.Time `json:"startTime,omitempty"`
+
+	// UsageCoreNanoSeconds is the cumulative CPU time consumed by the container.
+	UsageCoreNanoSeconds uint64 `json:"usageCoreNanoSeconds"`
+
+	// UsageNanoCores is the CPU usage averaged over the interval since the previous sample.
+	UsageNanoCores uint64 `json:"usageNanoCores"`
+
+	// UsageBytes is the total memory in use, including page cache.
+	UsageBytes uint64 `json:"usageBytes"`
+
+	// WorkingSetBytes is the memory that cannot be evicted, as reported by the kubelet.
+	WorkingSetBytes uint64 `json:"workingSetBytes"`
+
+	// RSSBytes is the anonymous and swap cache memory.
+	RSSBytes uint64 `json:"rssBytes"`
+}
+
+// Sample measures the usage of the container whose main process is pid.
+// If the container runs in a cgroup of its own, the usage is read from the cgroup. Otherwise, it is the sum
+// of the process tree, as read from /proc. The rate of CPU usage is computed against the previous sample, if any.
+func Sample(pid int, previous *ContainerUsage) (ContainerUsage, error) {
+	sample, err := sampleCgroup(pid)
+	if err != nil {
+		return ContainerUsage{}, err
+	}
+
+	if sample == nil {
+		if sample, err = sampleProcessTree(pid); err != nil {
+			return ContainerUsage{}, err
+		}
+	}
+
+	sample.Timestamp = time.Now()
+
+	if previous != nil && sample.UsageCoreNanoSeconds >= previous.UsageCoreNanoSeconds {
+		if elapsed := sample.Timestamp.Sub(previous.Timestamp); elapsed > 0 {
+			delta := float64(sample.UsageCoreNanoSeconds - previous.UsageCoreNanoSeconds)
+			sample.UsageNanoCores = uint64(delta / elapsed.Seconds())
+		}
+	}
+
+	return *sample, nil
+}
+
+/*---------------------------------------------------
+ * Cgroup v2
+ *---------------------------------------------------*/
+
+// sampleCgroup returns the usage of the cgroup of pid, or nil if the process shares the cgroup of the caller.
+func sampleCgroup(pid int) (*ContainerUsage, error) {
+	cgroup, err := cgroupPath(strconv.Itoa(pid))
+	if err != nil {
+		return nil, fmt.Errorf("cannot find cgroup of process %d: %w", pid, err)
+	}
+
+	self, err := cgroupPath("self")
+	if err != nil || cgroup == "" || cgroup == self {
+		return nil, nil
+	}
+
+	dir := filepath.Join(CgroupRoot, cgroup)
+
+	cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat"))
+	if err != nil {
+		return nil, nil
+	}
+
+	memoryStat, err := readKeyValues(filepath.Join(dir, "memory.stat"))
+	if err != nil {
+		return nil, nil
+	}
+
+	current, err := readUint(filepath.Join(dir, "memory.current"))
+	if err != nil {
+		return nil, nil
+	}
+
+	// the working set as computed by cadvisor.
+	workingSet := current
+	if inactive := memoryStat["inactive_file"]; inactive < workingSet {
+		workingSet -= inactive
+	} else {
+		workingSet = 0
+	}
+
+	return &ContainerUsage{
+		UsageCoreNanoSeconds: cpuStat["usage_usec"] * 1000,
+		UsageBytes:           current,
+		WorkingSetBytes:      workingSet,
+		RSSBytes:             memoryStat["anon"],
+	}, nil
+}
+
+// cgroupPath returns the cgroup v2 path of a process, or an empty string on cgroup v1.
+func cgroupPath(pid string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(ProcRoot, pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+
+	return "", nil
+}
+
+/*---------------------------------------------------
+ * Process Tree
+ *---------------------------------------------------*/
+
+// procStat holds the fields of /proc/<pid>/stat that are needed for accounting.
+type procStat struct {
+	ppid      int
+	cpuTicks  uint64
+	startTime uint64
+}
+
+// scanProcesses returns the stats of all processes, along with the children of every process.
+func scanProcesses() (map[int]procStat, map[int][]int, error) {
+	entries, err := os.ReadDir(ProcRoot)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats := map[int]procStat{}
+	children := map[int][]int{}
+
+	for _, entry := range entries {
+		p, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		// processes may exit while scanning.
+		stat, err := readProcStat(p)
+		if err != nil {
+			continue
+		}
+
+		stats[p] = stat
+		children[stat.ppid] = append(children[stat.ppid], p)
+	}
+
+	return stats, children, nil
+}
+
+// ProcessTree returns pid and all of its descendants, parents before their children.
+func ProcessTree(pid int) ([]int, error) {
+	stats, children, err := scanProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := stats[pid]; !ok {
+		return nil, fmt.Errorf("process %d does not exist", pid)
+	}
+
+	tree := []int{pid}
+
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+
+	return tree, nil
+}
+
+// sampleProcessTree returns the usage of pid and all of its descendants.
+func sampleProcessTree(pid int) (*ContainerUsage, error) {
+	stats, children, err := scanProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := stats[pid]; !ok {
+		return nil, fmt.Errorf("process %d does not exist", pid)
+	}
+
+	pageSize := uint64(os.Getpagesize())
+
+	var (
+		sample  ContainerUsage
+		cpuTick uint64
+	)
+
+	for queue := []int{pid}; len(queue) > 0; queue = queue[1:] {
+		p := queue[0]
+		queue = append(queue, children[p]...)
+
+		cpuTick += stats[p].cpuTicks
+
+		if resident, err := readResidentPages(p); err == nil {
+			sample.RSSBytes += resident * pageSize
+		}
+	}
+
+	sample.UsageCoreNanoSeconds = cpuTick * uint64(time.Second/clockTicks)
+	sample.UsageBytes = sample.RSSBytes
+	sample.WorkingSetBytes = sample.RSSBytes
+
+	if bootTime, err := readBootTime(); err == nil {
+		started := bootTime.Add(time.Duration(stats[pid].startTime) * (time.Second / clockTicks))
+		sample.StartTime = started.Truncate(time.Second)
+	}
+
+	return &sample, nil
+}
+
+// readProcStat parses /proc/<pid>/stat. The command may contain spaces and parentheses,
+// so the fields are counted from the last parenthesis.
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(filepath.Join(ProcRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	// fields start from the state, which is the 3rd field of the file.
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+
+	field := func(n int) uint64 {
+		v, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return v
+	}
+
+	return procStat{
+		ppid: int(field(4)),
+		// utime, stime, and the times of waited-for children.
+		cpuTicks:  field(14) + field(15) + field(16) + field(17),
+		startTime: field(22),
+	}, nil
+}
+
+// readResidentPages returns the resident set size of a process, in pages.
+func readResidentPages(pid int) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(ProcRoot, strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("malformed statm of process %d", pid)
+	}
+
+	return strconv.ParseUint(fields[1], 10, 64)
+}
+
+// readBootTime returns the boot time of the host, as found in /proc/stat.
+func readBootTime() (time.Time, error) {
+	values, err := readKeyValues(filepath.Join(ProcRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	btime, ok := values["btime"]
+	if !ok {
+		return time.Time{}, fmt.Errorf("boot time is not available")
+	}
+
+	return time.Unix(int64(btime), 0), nil
+}
+
+/*---------------------------------------------------
+ * Helpers
+ *---------------------------------------------------*/
+
+// readKeyValues parses files with lines in the form of "key value".
+func readKeyValues(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// DirSize returns the bytes and the inodes that are used by the files under a directory.
+// Symbolic links are not followed.
+func DirSize(root string) (usedBytes uint64, inodes uint64, err error) {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// files may be removed while walking.
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		inodes++
+
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			usedBytes += uint64(info.Size())
+		}
+
+		return nil
+	})
+
+	return usedBytes, inodes, err
+}
+
+/*---------------------------------------------------
+ * Persistence
+ *---------------------------------------------------*/
+
+// Write stores a sample atomically, so that readers never observe a partial file.
+func Write(path string, sample ContainerUsage) error {
+	data, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Read loads a sample that has been stored by Write.
+func Read(path string) (ContainerUsage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContainerUsage{}, err
+	}
+
+	var sample ContainerUsage
+
+	if err := json.Unmarshal(data, &sample); err != nil {
+		return ContainerUsage{}, fmt.Errorf("cannot decode usage file '%s': %w", path, err)
+	}
+
+	return sample, nil
+}
@@ -0,0 +1,135 @@
+package usage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeProc creates the /proc entries of a fake process.
+func writeProc(t *testing.T, root string, pid, ppid int, comm string, ticks, pages uint64) {
+	t.Helper()
+
+	dir := filepath.Join(root, fmt.Sprint(pid))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	// pid (comm) state ppid pgrp session tty tpgid flags minflt cminflt majflt cmajflt utime stime cutime cstime
+	// priority nice threads itrealvalue starttime
+	stat := fmt.Sprintf("%d (%s) S %d 1 1 0 -1 0 0 0 0 0 %d 0 0 0 20 0 1 0 500 0 0\n", pid, comm, ppid, ticks)
+
+	files := map[string]string{
+		"stat":   stat,
+		"statm":  fmt.Sprintf("1000 %d 0 0 0 0 0\n", pages),
+		"cgroup": "0::/pause\n",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+}
+
+func TestSampleProcessTree(t *testing.T) {
+	ProcRoot = t.TempDir()
+	defer func() { ProcRoot = "/proc" }()
+
+	if err := os.WriteFile(filepath.Join(ProcRoot, "stat"), []byte("cpu 1 2 3\nbtime 1700000000\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(ProcRoot, "self"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(ProcRoot, "self", "cgroup"), []byte("0::/pause\n"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	writeProc(t, ProcRoot, 10, 1, "apptainer", 100, 10)
+	writeProc(t, ProcRoot, 11, 10, "sh -c (x)", 50, 20)
+	writeProc(t, ProcRoot, 12, 11, "worker", 50, 30)
+	writeProc(t, ProcRoot, 20, 1, "unrelated", 1000, 1000)
+
+	first, err := Sample(10, nil)
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+
+	if expected := uint64(2 * time.Second); first.UsageCoreNanoSeconds != expected {
+		t.Errorf("expected %d cpu nanoseconds, got %d", expected, first.UsageCoreNanoSeconds)
+	}
+
+	if expected := uint64(60 * os.Getpagesize()); first.WorkingSetBytes != expected {
+		t.Errorf("expected %d bytes, got %d", expected, first.WorkingSetBytes)
+	}
+
+	if expected := time.Unix(1700000005, 0); !first.StartTime.Equal(expected) {
+		t.Errorf("expected start time %v, got %v", expected, first.StartTime)
+	}
+
+	/*-- The rate is computed against the previous sample --*/
+	previous := first
+	previous.Timestamp = first.Timestamp.Add(-2 * time.Second)
+	previous.UsageCoreNanoSeconds -= uint64(time.Second)
+
+	second, err := Sample(10, &previous)
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+
+	// one second of cpu over (at least) two seconds.
+	if second.UsageNanoCores == 0 || second.UsageNanoCores > uint64(time.Second)/2 {
+		t.Errorf("unexpected cpu rate %d", second.UsageNanoCores)
+	}
+
+	if _, err := Sample(99, nil); err == nil {
+		t.Errorf("expected error for missing process")
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.usage")
+
+	sample := ContainerUsage{Timestamp: time.Now().Truncate(time.Second), UsageCoreNanoSeconds: 42, WorkingSetBytes: 7}
+
+	if err := Write(path, sample); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	loaded, err := Read(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if !loaded.Timestamp.Equal(sample.Timestamp) || loaded.UsageCoreNanoSeconds != 42 || loaded.WorkingSetBytes != 7 {
+		t.Errorf("expected %+v, got %+v", sample, loaded)
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	root := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	for path, size := range map[string]int{"a": 100, "sub/b": 50} {
+		if err := os.WriteFile(filepath.Join(root, path), make([]byte, size), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	usedBytes, inodes, err := DirSize(root)
+	if err != nil {
+		t.Fatalf("size: %v", err)
+	}
+
+	if usedBytes != 150 || inodes != 4 {
+		t.Errorf("expected 150 bytes and 4 inodes, got %d and %d", usedBytes, inodes)
+	}
+}
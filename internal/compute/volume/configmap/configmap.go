@@ -43,7 +43,7 @@ type VolumeMounter struct {
 }
 
 func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
-	var configMap corev1.ConfigMap
+	var configMap *corev1.ConfigMap
 
 	source := b.Volume.ConfigMap
 	optional := source.Optional != nil && *source.Optional
@@ -56,19 +56,15 @@ func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
 
 	if err := retry.OnError(volume.NotFoundBackoff,
 		k8errors.IsNotFound, // retry condition
-		func() error { // execution
-			return compute.K8SClient.Get(ctx, key, &configMap)
+		func() (err error) { // execution
+			configMap, err = compute.GetConfigMap(ctx, key.Namespace, key.Name)
+			return err
 		}); err != nil { // error checking
 		if !(k8errors.IsNotFound(err) && optional) {
-			return fmt.Errorf("Couldn't get secret '%s': %w", key, err)
+			return fmt.Errorf("Couldn't get configMap '%s': %w", key, err)
 		}
 
-		configMap = corev1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: b.Pod.GetNamespace(),
-				Name:      source.Name,
-			},
-		}
+		configMap = b.emptyConfigMap()
 	}
 
 	// totalBytes := totalBytes(&configMap)
@@ -76,17 +72,52 @@ func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
 	/*---------------------------------------------------
 	 * Mount Resource to the host
 	 *---------------------------------------------------*/
-	payload, err := MakePayload(source.Items, &configMap, source.DefaultMode, optional)
-	if err != nil {
-		return err
-	}
-
 	if err := util.MakeNestedMountpoints(b.Volume.Name, dir, b.Pod); err != nil {
 		return err
 	}
 
 	// todo: Clean up directories if setup fails
 
+	return b.write(dir, configMap)
+}
+
+// Refresh re-projects the current contents of the configMap into a volume that is already set up.
+// Unlike SetUpAt, it does not wait for a missing configMap, and leaves the existing files in place.
+func (b *VolumeMounter) Refresh(ctx context.Context, dir string) error {
+	source := b.Volume.ConfigMap
+	optional := source.Optional != nil && *source.Optional
+
+	configMap, err := compute.GetConfigMap(ctx, b.Pod.GetNamespace(), source.Name)
+	if err != nil {
+		if !(k8errors.IsNotFound(err) && optional) {
+			return fmt.Errorf("Couldn't get configMap '%s/%s': %w", b.Pod.GetNamespace(), source.Name, err)
+		}
+
+		configMap = b.emptyConfigMap()
+	}
+
+	return b.write(dir, configMap)
+}
+
+func (b *VolumeMounter) emptyConfigMap() *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: b.Pod.GetNamespace(),
+			Name:      b.Volume.ConfigMap.Name,
+		},
+	}
+}
+
+// write projects the configMap into dir. Files are replaced atomically, and nothing is written if the payload is unchanged.
+func (b *VolumeMounter) write(dir string, configMap *corev1.ConfigMap) error {
+	source := b.Volume.ConfigMap
+	optional := source.Optional != nil && *source.Optional
+
+	payload, err := MakePayload(source.Items, configMap, source.DefaultMode, optional)
+	if err != nil {
+		return err
+	}
+
 	writerContext := fmt.Sprintf("Pod %v/%v volume %v", b.Pod.Namespace, b.Pod.Name, b.Volume.Name)
 
 	writer, err := util.NewAtomicWriter(dir, writerContext)
@@ -0,0 +1,106 @@
+package configmap
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hpk/internal/compute"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	return string(data)
+}
+
+func TestRefresh(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	compute.ConfigMapLister = corelisters.NewConfigMapLister(indexer)
+
+	defer func() { compute.ConfigMapLister = nil }()
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "settings", ResourceVersion: "1"},
+		Data:       map[string]string{"level": "info"},
+	}
+
+	if err := indexer.Add(configMap); err != nil {
+		t.Fatal(err)
+	}
+
+	mode := int32(0o644)
+	mounter := VolumeMounter{
+		Volume: corev1.Volume{
+			Name: "settings",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "settings"},
+					DefaultMode:          &mode,
+				},
+			},
+		},
+		Pod:    corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"}},
+		Logger: logr.Discard(),
+	}
+
+	dir := t.TempDir()
+
+	if err := mounter.SetUpAt(context.Background(), dir); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(dir, "level")); got != "info" {
+		t.Fatalf("got %q after setup", got)
+	}
+
+	/*-- Changes are projected through the data symlink --*/
+	updated := configMap.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Data = map[string]string{"level": "debug", "format": "json"}
+
+	if err := indexer.Update(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mounter.Refresh(context.Background(), dir); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(dir, "level")); got != "debug" {
+		t.Errorf("got %q after refresh", got)
+	}
+
+	if got := readFile(t, filepath.Join(dir, "format")); got != "json" {
+		t.Errorf("got %q for a new key", got)
+	}
+
+	if link, err := os.Readlink(filepath.Join(dir, "level")); err != nil || link != filepath.Join("..data", "level") {
+		t.Errorf("got link %q (%v), want a link through the data directory", link, err)
+	}
+
+	/*-- A deleted configMap leaves the existing files in place --*/
+	if err := indexer.Delete(updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mounter.Refresh(context.Background(), dir); err == nil {
+		t.Errorf("expected an error for a missing configMap")
+	}
+
+	if got := readFile(t, filepath.Join(dir, "level")); got != "debug" {
+		t.Errorf("got %q after a failed refresh", got)
+	}
+}
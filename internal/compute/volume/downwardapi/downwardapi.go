@@ -16,16 +16,58 @@
 package downwardapi
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 
 	volumeutil "hpk/internal/compute/volume/util"
 	"hpk/pkg/fieldpath"
+
+	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/klog/v2"
 )
 
+// VolumeMounter projects fields of the pod into the volume on the host.
+type VolumeMounter struct {
+	Volume corev1.Volume
+
+	Pod corev1.Pod
+
+	Logger logr.Logger
+}
+
+func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
+	if err := volumeutil.MakeNestedMountpoints(b.Volume.Name, dir, b.Pod); err != nil {
+		return err
+	}
+
+	return b.Refresh(ctx, dir)
+}
+
+// Refresh re-projects the fields of the pod into a volume that is already set up.
+// It is meant to be called whenever the labels or the annotations of the pod change.
+func (b *VolumeMounter) Refresh(_ context.Context, dir string) error {
+	data, err := CollectData(b.Volume.DownwardAPI.Items, &b.Pod, b.Volume.DownwardAPI.DefaultMode)
+	if err != nil {
+		return fmt.Errorf("error preparing data for downwardAPI volume '%s': %w", b.Volume.Name, err)
+	}
+
+	writerContext := fmt.Sprintf("Pod %v/%v volume %v", b.Pod.Namespace, b.Pod.Name, b.Volume.Name)
+
+	writer, err := volumeutil.NewAtomicWriter(dir, writerContext)
+	if err != nil {
+		return fmt.Errorf("Error creating atomic writer: %w", err)
+	}
+
+	if err := writer.Write(data); err != nil {
+		return fmt.Errorf("Error writing payload to dir: %w", err)
+	}
+
+	return nil
+}
+
 // CollectData collects requested downwardAPI in data map.
 // Map's key is the requested name of file to dump
 // Map's value is the (sorted) content of the field to be dumped in the file.
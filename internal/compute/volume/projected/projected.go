@@ -59,7 +59,7 @@ func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
 	if err := retry.OnError(volume.NotFoundBackoff,
 		k8errors.IsNotFound, // retry condition
 		func() error { // execution
-			data, errCollect = b.collectData(ctx)
+			data, errCollect = b.collectData(ctx, true)
 			return errCollect
 		},
 	); err != nil { // error checking
@@ -73,7 +73,27 @@ func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
 		return err
 	}
 
-	writerContext := fmt.Sprintf("pod %s volume %v", podKey, b.Volume.Name)
+	return b.write(dir, data)
+}
+
+// Refresh re-projects the current sources into a volume that is already set up. Missing sources are not waited for.
+// If any of the sources cannot be collected, the existing files are left in place.
+func (b *VolumeMounter) Refresh(ctx context.Context, dir string) error {
+	if b.Volume.Projected.DefaultMode == nil {
+		return fmt.Errorf("no defaultMode used, not even the default value for it")
+	}
+
+	data, err := b.collectData(ctx, false)
+	if err != nil {
+		return fmt.Errorf("error preparing data for project volume. volume:'%s' pod:'%s/%s': %w",
+			b.Volume.Name, b.Pod.GetNamespace(), b.Pod.GetName(), err)
+	}
+
+	return b.write(dir, data)
+}
+
+func (b *VolumeMounter) write(dir string, data map[string]util.FileProjection) error {
+	writerContext := fmt.Sprintf("pod %s/%s volume %v", b.Pod.GetNamespace(), b.Pod.GetName(), b.Volume.Name)
 	writer, err := util.NewAtomicWriter(dir, writerContext)
 	if err != nil {
 		return fmt.Errorf("Error creating atomic writer: %w", err)
@@ -89,7 +109,20 @@ func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
 	return nil
 }
 
-func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FileProjection, error) {
+// getSource runs get, and retries it while the resource is not found if wait is set.
+func getSource(wait bool, get func() error) error {
+	if !wait {
+		return get()
+	}
+
+	return retry.OnError(volume.NotFoundBackoff,
+		k8errors.IsNotFound, // retry condition
+		get,                 // execution
+	)
+}
+
+// collectData builds the payload of the volume. If wait is set, missing secrets and configMaps are waited for.
+func (b *VolumeMounter) collectData(ctx context.Context, wait bool) (map[string]util.FileProjection, error) {
 	var errlist []error
 	payload := make(map[string]util.FileProjection)
 
@@ -99,7 +132,7 @@ func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FilePr
 			/*---------------------------------------------------
 			 * Projected Secret
 			 *---------------------------------------------------*/
-			var secretAPI corev1.Secret
+			secretAPI := &corev1.Secret{}
 			secretAPI.Namespace = b.Pod.GetNamespace()
 			secretAPI.Name = source.Secret.Name
 
@@ -107,12 +140,14 @@ func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FilePr
 			key := types.NamespacedName{Namespace: b.Pod.GetNamespace(), Name: source.Secret.Name}
 
 			{ // get the resource
-				if err := retry.OnError(volume.NotFoundBackoff,
-					k8errors.IsNotFound, // retry condition
-					func() error { // execution
-						return compute.K8SClient.Get(ctx, key, &secretAPI)
-					},
-				); err != nil { // error checking
+				if err := getSource(wait, func() error {
+					obj, err := compute.GetSecret(ctx, key.Namespace, key.Name)
+					if err == nil {
+						secretAPI = obj
+					}
+
+					return err
+				}); err != nil { // error checking
 					if !(k8errors.IsNotFound(err) && optional) {
 						b.Logger.Info("Couldn't get projected.secret", "key", key)
 
@@ -122,7 +157,7 @@ func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FilePr
 				}
 			}
 
-			secretPayload, err := secret.MakePayload(source.Secret.Items, &secretAPI, b.Volume.Projected.DefaultMode, optional)
+			secretPayload, err := secret.MakePayload(source.Secret.Items, secretAPI, b.Volume.Projected.DefaultMode, optional)
 			if err != nil {
 				b.Logger.Error(err, "Couldn't get secret payload")
 				errlist = append(errlist, err)
@@ -137,7 +172,7 @@ func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FilePr
 			/*---------------------------------------------------
 			 * Projected ConfigMap
 			 *---------------------------------------------------*/
-			var configMapAPI corev1.ConfigMap
+			configMapAPI := &corev1.ConfigMap{}
 			configMapAPI.Namespace = b.Pod.GetNamespace()
 			configMapAPI.Name = source.ConfigMap.Name
 
@@ -145,12 +180,14 @@ func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FilePr
 			key := types.NamespacedName{Namespace: b.Pod.GetNamespace(), Name: source.ConfigMap.Name}
 
 			{ // get the resource
-				if err := retry.OnError(volume.NotFoundBackoff,
-					k8errors.IsNotFound, // retry condition
-					func() error { // execution
-						return compute.K8SClient.Get(ctx, key, &configMapAPI)
-					},
-				); err != nil { // error checking
+				if err := getSource(wait, func() error {
+					obj, err := compute.GetConfigMap(ctx, key.Namespace, key.Name)
+					if err == nil {
+						configMapAPI = obj
+					}
+
+					return err
+				}); err != nil { // error checking
 					if !(k8errors.IsNotFound(err) && optional) {
 						b.Logger.Info("Couldn't get projected.configmap", "key", key)
 
@@ -160,7 +197,7 @@ func (b *VolumeMounter) collectData(ctx context.Context) (map[string]util.FilePr
 				}
 			}
 
-			configMapPayload, err := configmap.MakePayload(source.ConfigMap.Items, &configMapAPI, b.Volume.Projected.DefaultMode, optional)
+			configMapPayload, err := configmap.MakePayload(source.ConfigMap.Items, configMapAPI, b.Volume.Projected.DefaultMode, optional)
 			if err != nil {
 				b.Logger.Error(err, "Couldn't get configMap payload")
 
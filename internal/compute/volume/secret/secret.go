@@ -44,7 +44,7 @@ type VolumeMounter struct {
 }
 
 func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
-	var secret corev1.Secret
+	var secret *corev1.Secret
 
 	source := b.Volume.Secret
 	optional := source.Optional != nil && *source.Optional
@@ -57,36 +57,66 @@ func (b *VolumeMounter) SetUpAt(ctx context.Context, dir string) error {
 
 	if err := retry.OnError(volume.NotFoundBackoff,
 		k8errors.IsNotFound, // retry condition
-		func() error { // execution
-			return compute.K8SClient.Get(ctx, key, &secret)
-		},
-	); err != nil { // error checking
+		func() (err error) { // execution
+			secret, err = compute.GetSecret(ctx, key.Namespace, key.Name)
+			return err
+		}); err != nil { // error checking
 		if !(k8errors.IsNotFound(err) && optional) {
 			return fmt.Errorf("Couldn't get secret '%s': %w", key, err)
 		}
 
-		secret = corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Namespace: b.Pod.GetNamespace(),
-				Name:      source.SecretName,
-			},
-		}
+		secret = b.emptySecret()
 	}
 
 	/*---------------------------------------------------
 	 * Mount Resource to the host
 	 *---------------------------------------------------*/
-	payload, err := MakePayload(source.Items, &secret, source.DefaultMode, optional)
-	if err != nil {
-		return err
-	}
-
 	if err := util.MakeNestedMountpoints(b.Volume.Name, dir, b.Pod); err != nil {
 		return err
 	}
 
 	// todo: Clean up directories if setup fails
 
+	return b.write(dir, secret)
+}
+
+// Refresh re-projects the current contents of the secret into a volume that is already set up.
+// Unlike SetUpAt, it does not wait for a missing secret, and leaves the existing files in place.
+func (b *VolumeMounter) Refresh(ctx context.Context, dir string) error {
+	source := b.Volume.Secret
+	optional := source.Optional != nil && *source.Optional
+
+	secret, err := compute.GetSecret(ctx, b.Pod.GetNamespace(), source.SecretName)
+	if err != nil {
+		if !(k8errors.IsNotFound(err) && optional) {
+			return fmt.Errorf("Couldn't get secret '%s/%s': %w", b.Pod.GetNamespace(), source.SecretName, err)
+		}
+
+		secret = b.emptySecret()
+	}
+
+	return b.write(dir, secret)
+}
+
+func (b *VolumeMounter) emptySecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: b.Pod.GetNamespace(),
+			Name:      b.Volume.Secret.SecretName,
+		},
+	}
+}
+
+// write projects the secret into dir. Files are replaced atomically, and nothing is written if the payload is unchanged.
+func (b *VolumeMounter) write(dir string, secret *corev1.Secret) error {
+	source := b.Volume.Secret
+	optional := source.Optional != nil && *source.Optional
+
+	payload, err := MakePayload(source.Items, secret, source.DefaultMode, optional)
+	if err != nil {
+		return err
+	}
+
 	writerContext := fmt.Sprintf("Pod %v/%v volume %v", b.Pod.Namespace, b.Pod.Name, b.Volume.Name)
 
 	writer, err := util.NewAtomicWriter(dir, writerContext)
@@ -9,24 +9,41 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-func MutatePVC(ctx context.Context, review *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhmutating.MutatorResult, error) {
-	// we are only interested in newly created PVCs.
-	if review.Operation != kwhmodel.OperationCreate {
-		return &kwhmutating.MutatorResult{}, nil
-	}
+const (
+	// SelectedNodeAnnotation is the node on which the provisioner creates the volume of a PVC with delayed binding.
+	SelectedNodeAnnotation = "volume.kubernetes.io/selected-node"
 
-	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
-	if !ok {
-		return &kwhmutating.MutatorResult{}, nil
-	}
+	// BindPVCLabel opts a PVC in to be bound to HPK by MutatePVC, when set to "true".
+	BindPVCLabel = "hpk.io/bind-pvc"
+)
 
-	// Mutate our object with the required annotations.
-	if pvc.Annotations == nil {
-		pvc.Annotations = make(map[string]string)
-	}
-	pvc.Annotations["volume.kubernetes.io/selected-node"] = "hpk-kubelet"
+// MutatePVC returns a mutator that binds the newly created PVCs to the given node, so that
+// provisioners with WaitForFirstConsumer semantics can provision them for HPK.
+func MutatePVC(nodeName string) kwhmutating.MutatorFunc {
+	return func(ctx context.Context, review *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhmutating.MutatorResult, error) {
+		// we are only interested in newly created PVCs.
+		if review.Operation != kwhmodel.OperationCreate {
+			return &kwhmutating.MutatorResult{}, nil
+		}
+
+		pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+		if !ok {
+			return &kwhmutating.MutatorResult{}, nil
+		}
+
+		// the PVC is already bound, either by the user or by the scheduler.
+		if _, ok := pvc.Annotations[SelectedNodeAnnotation]; ok {
+			return &kwhmutating.MutatorResult{}, nil
+		}
+
+		// Mutate our object with the required annotations.
+		if pvc.Annotations == nil {
+			pvc.Annotations = make(map[string]string)
+		}
+		pvc.Annotations[SelectedNodeAnnotation] = nodeName
 
-	return &kwhmutating.MutatorResult{MutatedObject: pvc}, nil
+		return &kwhmutating.MutatorResult{MutatedObject: pvc}, nil
+	}
 }
 
 // MutatePod is used to modify Pods requests before they arrive to the Virtual Kubelet Framework.
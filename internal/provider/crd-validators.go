@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	PodHandler "hpk/internal/compute/podhandler"
+	"hpk/internal/compute/slurm"
+
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	kwhvalidating "github.com/slok/kubewebhook/v2/pkg/webhook/validating"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ValidatePod rejects the pods that HPK cannot run, so that users get an error at admission time
+// rather than a failed pod. The Slurm options of the pod are validated against slurm.DefaultPolicy.
+func ValidatePod(ctx context.Context, review *kwhmodel.AdmissionReview, obj metav1.Object) (*kwhvalidating.ValidatorResult, error) {
+	// only new pods are validated, as the spec of existing pods is mostly immutable.
+	if review.Operation != kwhmodel.OperationCreate {
+		return &kwhvalidating.ValidatorResult{Valid: true}, nil
+	}
+
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return &kwhvalidating.ValidatorResult{Valid: true}, nil
+	}
+
+	var reasons []string
+
+	if unsupportedFields := PodHandler.UnsupportedFields(pod); len(unsupportedFields) > 0 {
+		reasons = append(reasons, fmt.Sprintf("UnsupportedFeatures: %s", strings.Join(unsupportedFields, ",")))
+	}
+
+	// the partition is checked once the pod is scheduled, since the node is not yet known.
+	if _, err := slurm.DefaultPolicy.JobOptions(pod, slurm.Partition{}); err != nil {
+		reasons = append(reasons, fmt.Sprintf("invalid Slurm options: %v", err))
+	}
+
+	if len(reasons) > 0 {
+		return &kwhvalidating.ValidatorResult{Valid: false, Message: strings.Join(reasons, "; ")}, nil
+	}
+
+	return &kwhvalidating.ValidatorResult{Valid: true}, nil
+}
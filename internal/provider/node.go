@@ -18,115 +18,319 @@ import (
 	"context"
 	"fmt"
 	"runtime"
+	"sync"
+	"syscall"
+	"time"
 
+	"hpk/internal/compute"
+	"hpk/internal/compute/image"
 	"hpk/internal/compute/slurm"
 	"hpk/pkg/version"
 
 	"github.com/matishsiao/goInfo"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-// NewVirtualNode builds a kubernetes node object from a provider
-// This is a temporary solution until node stuff actually split off from the provider interface itself.
-func (v *VirtualK8S) NewVirtualNode(ctx context.Context, nodename string, taint *corev1.Taint) *corev1.Node {
-	taints := make([]corev1.Taint, 0)
+// Labels of the virtual nodes that represent Slurm partitions.
+const (
+	// PartitionLabel holds the name of the partition.
+	PartitionLabel = "slurm.hpk.io/partition"
 
-	if taint != nil {
-		taints = append(taints, *taint)
+	// FeatureLabelPrefix is followed by each of the features that the nodes of the partition have.
+	FeatureLabelPrefix = "feature.slurm.hpk.io/"
+)
+
+// VirtualNode is the provider of a single Kubernetes node. The virtual nodes share the pods and the control files
+// of their VirtualK8S, and each of them handles the pods that are scheduled to it.
+type VirtualNode struct {
+	*VirtualK8S
+
+	// Name of the node in Kubernetes.
+	Name string
+
+	// Partition where the pods of the node are submitted. The zero value is the entire cluster.
+	Partition slurm.Partition
+
+	// node is the last status of the node, which is refreshed by NotifyNodeStatus.
+	nodeLock sync.RWMutex
+	node     *corev1.Node
+
+	// updatedPod is the callback of the pod controller of the node.
+	updatedPod func(*corev1.Pod)
+}
+
+// NewVirtualNode builds a virtual node for the partition, and registers it to the provider.
+// The taints are applied to the node in addition to those of the partition.
+func (v *VirtualK8S) NewVirtualNode(ctx context.Context, nodename string, partition slurm.Partition, taints []corev1.Taint) *VirtualNode {
+	labels := map[string]string{
+		"kubernetes.io/hostname": nodename,
+		"kubernetes.io/role":     "agent",
+		"kubernetes.io/os":       runtime.GOOS,
+		"kubernetes.io/arch":     runtime.GOARCH,
+	}
+
+	// names that are not valid in labels are not published, but the pods of the node are still submitted to them.
+	if partition.Name != "" && len(validation.IsValidLabelValue(partition.Name)) == 0 {
+		labels[PartitionLabel] = partition.Name
+	}
+
+	for _, feature := range partition.Features {
+		if key := FeatureLabelPrefix + feature; len(validation.IsQualifiedName(key)) == 0 {
+			labels[key] = "true"
+		}
 	}
 
-	return &corev1.Node{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: nodename,
-			Labels: map[string]string{
-				"kubernetes.io/hostname": nodename,
-				"kubernetes.io/role":     "agent",
-				"kubernetes.io/os":       runtime.GOOS,
-				"kubernetes.io/arch":     runtime.GOARCH,
+	n := &VirtualNode{
+		VirtualK8S: v,
+		Name:       nodename,
+		Partition:  partition,
+		node: &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   nodename,
+				Labels: labels,
+			},
+			Spec: corev1.NodeSpec{
+				Taints: append(make([]corev1.Taint, 0, len(taints)), taints...),
 			},
-		},
-		Spec: corev1.NodeSpec{
-			Taints: taints,
-		},
-		Status: corev1.NodeStatus{
-			NodeInfo:        v.NodeSystemInfo(ctx),
-			Addresses:       v.NodeAddresses(ctx),
-			DaemonEndpoints: v.NodeDaemonEndpoints(ctx),
-			Conditions:      NodeConditions(ctx),
-			Phase: func() corev1.NodePhase {
-				if slurm.ConnectionOK() {
-					return corev1.NodeRunning
-				}
-				return corev1.NodePending
-			}(),
-			Capacity:    slurm.TotalResources(),
-			Allocatable: slurm.AllocatableResources(ctx),
 		},
 	}
+
+	n.ConfigureNode(ctx, n.node)
+
+	v.nodesLock.Lock()
+	v.nodes[nodename] = n
+	v.nodesLock.Unlock()
+
+	return n
+}
+
+// Node returns the Kubernetes object of the node.
+func (n *VirtualNode) Node() *corev1.Node {
+	n.nodeLock.RLock()
+	defer n.nodeLock.RUnlock()
+
+	return n.node.DeepCopy()
 }
 
 // ConfigureNode enables a provider to configure the node object that
 // will be used for Kubernetes.
-func (v *VirtualK8S) ConfigureNode(ctx context.Context, node *corev1.Node) {
+//
+// It is also called periodically to refresh the status of the node. Values that cannot be
+// refreshed (e.g, the capacity while Slurm does not respond) keep their previous state.
+func (n *VirtualNode) ConfigureNode(ctx context.Context, node *corev1.Node) {
 	/*---------------------------------------------------
 	 * Preamble used for Request tracing on the logs
 	 *---------------------------------------------------*/
-	v.Logger.Info("[K8s] -> ConfigureNode")
-	defer v.Logger.Info("[K8s] <- ConfigureNode")
+	n.Logger.V(1).Info("[K8s] -> ConfigureNode", "node", n.Name)
+	defer n.Logger.V(1).Info("[K8s] <- ConfigureNode", "node", n.Name)
+
+	node.Status.NodeInfo = n.NodeSystemInfo(ctx)
+	node.Status.Addresses = n.NodeAddresses(ctx)
+	node.Status.DaemonEndpoints = n.NodeDaemonEndpoints(ctx)
+
+	/*-- Capacity --*/
+	if resources, err := n.Partition.Resources(ctx); err != nil {
+		n.Logger.Info("failed to refresh the node resources", "node", n.Name, "error", err)
+	} else {
+		node.Status.Capacity = resources
+		node.Status.Allocatable = resources.DeepCopy()
+	}
+
+	/*-- Conditions --*/
+	conditions := n.NodeConditions(ctx)
 
-	panic("not yet supported")
+	node.Status.Conditions = mergeConditions(node.Status.Conditions, conditions)
+
+	if isReady(conditions) {
+		node.Status.Phase = corev1.NodeRunning
+	} else {
+		node.Status.Phase = corev1.NodePending
+	}
 }
 
-// NodeConditions creates a slice of node conditions representing a
-// kubelet in perfect health. These four conditions are the ones which virtual-kubelet
-// sets as Unknown when a Ping fails.
-func NodeConditions(_ context.Context) []corev1.NodeCondition {
-	// NodeConditions returns a list of conditions (Ready, OutOfDisk, etc), for updates to the node status
-	// within Kubernetes.
-	// TODO: Make this configurable
-	return []corev1.NodeCondition{
-		{
-			Type:               corev1.NodeReady,
-			Status:             corev1.ConditionTrue,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletPending",
-			Message:            "kubelet is pending.",
-		},
-		{
-			Type:               corev1.NodeMemoryPressure,
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasSufficientMemory",
-			Message:            "kubelet has sufficient memory available",
-		},
+// Ping reports that hpk-kubelet is alive. The health of Slurm is reported through the Ready condition
+// instead, so that the node is marked as not ready rather than unreachable.
+func (n *VirtualNode) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// NotifyNodeStatus refreshes the status of the node every NodeStatusUpdateInterval, and passes it to cb.
+func (n *VirtualNode) NotifyNodeStatus(ctx context.Context, cb func(*corev1.Node)) {
+	interval := n.NodeStatusUpdateInterval
+	if interval <= 0 {
+		interval = DefaultNodeStatusUpdateInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			node := n.Node()
+
+			n.ConfigureNode(ctx, node)
+
+			n.nodeLock.Lock()
+			n.node = node
+			n.nodeLock.Unlock()
+
+			cb(node.DeepCopy())
+		}
+	}()
+}
+
+// GetPods returns the pods that are scheduled to the node. The pod controller of the node deletes
+// the pods that it does not know of, so the pods of the other nodes must not be returned.
+func (n *VirtualNode) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
+	pods, err := n.VirtualK8S.GetPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := pods[:0]
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == n.Name {
+			scheduled = append(scheduled, pod)
+		}
+	}
+
+	return scheduled, nil
+}
+
+// NotifyPods instructs the notifier to call the passed in function when
+// the status of a pod that is scheduled to the node changes.
+//
+// NotifyPods must not block the caller since it is only used to register the callback.
+// The callback passed into `NotifyPods` may block when called.
+func (n *VirtualNode) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
+	n.Logger.Info("[K8s] -> NotifyPods", "node", n.Name)
+	defer n.Logger.Info("[K8s] <- NotifyPods", "node", n.Name)
+
+	n.nodesLock.Lock()
+	n.updatedPod = f
+	n.nodesLock.Unlock()
+
+	/*-- the control files of all the nodes are handled together --*/
+	n.listenOnce.Do(func() {
+		n.listen(ctx)
+	})
+
+	/*-- reconcile the pods that have changed while hpk-kubelet was down --*/
+	go n.recoverPods(ctx, n.Name, f)
+}
+
+// NodeConditions returns the conditions of the node. Ready follows the responsiveness of Slurm,
+// and DiskPressure the free space of the working directory. The transition times are set to now.
+func (v *VirtualK8S) NodeConditions(ctx context.Context) []corev1.NodeCondition {
+	now := metav1.Now()
+
+	ready := corev1.NodeCondition{
+		Type:    corev1.NodeReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  "KubeletReady",
+		Message: "HPK is successfully connected to Slurm",
+	}
+
+	if err := slurm.Ping(ctx); err != nil {
+		ready.Status = corev1.ConditionFalse
+		ready.Reason = "SlurmNotResponding"
+		ready.Message = err.Error()
+	}
+
+	diskPressure := corev1.NodeCondition{
+		Type:    corev1.NodeDiskPressure,
+		Status:  corev1.ConditionFalse,
+		Reason:  "KubeletHasNoDiskPressure",
+		Message: "kubelet has no disk pressure",
+	}
+
+	if free, err := freeDiskRatio(compute.HPK.String()); err != nil {
+		diskPressure.Status = corev1.ConditionUnknown
+		diskPressure.Reason = "KubeletDiskStatsFailed"
+		diskPressure.Message = err.Error()
+	} else if free < v.DiskPressureThreshold {
+		diskPressure.Status = corev1.ConditionTrue
+		diskPressure.Reason = "KubeletHasDiskPressure"
+		diskPressure.Message = fmt.Sprintf("%.1f%% of the working directory is free, below the threshold of %.1f%%",
+			free*100, v.DiskPressureThreshold*100)
+	}
+
+	conditions := []corev1.NodeCondition{
+		ready,
 		{
-			Type:               corev1.NodeDiskPressure,
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasNoDiskPressure",
-			Message:            "kubelet has no disk pressure",
+			Type:    corev1.NodeMemoryPressure,
+			Status:  corev1.ConditionFalse,
+			Reason:  "KubeletHasSufficientMemory",
+			Message: "kubelet has sufficient memory available",
 		},
+		diskPressure,
 		{
-			Type:               corev1.NodePIDPressure,
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "KubeletHasNoPIDPressure",
-			Message:            "kubelet has no PID pressure",
+			Type:    corev1.NodePIDPressure,
+			Status:  corev1.ConditionFalse,
+			Reason:  "KubeletHasNoPIDPressure",
+			Message: "kubelet has no PID pressure",
 		},
 		{
-			Type:               corev1.NodeNetworkUnavailable,
-			Status:             corev1.ConditionFalse,
-			LastHeartbeatTime:  metav1.Now(),
-			LastTransitionTime: metav1.Now(),
-			Reason:             "RouteCreated",
-			Message:            "RouteController created a route",
+			Type:    corev1.NodeNetworkUnavailable,
+			Status:  corev1.ConditionFalse,
+			Reason:  "RouteCreated",
+			Message: "RouteController created a route",
 		},
 	}
+
+	for i := range conditions {
+		conditions[i].LastHeartbeatTime = now
+		conditions[i].LastTransitionTime = now
+	}
+
+	return conditions
+}
+
+// mergeConditions returns the current conditions, keeping the transition time of those whose status is unchanged.
+func mergeConditions(previous, current []corev1.NodeCondition) []corev1.NodeCondition {
+	for i, condition := range current {
+		for _, old := range previous {
+			if old.Type == condition.Type && old.Status == condition.Status {
+				current[i].LastTransitionTime = old.LastTransitionTime
+			}
+		}
+	}
+
+	return current
+}
+
+func isReady(conditions []corev1.NodeCondition) bool {
+	for _, condition := range conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// freeDiskRatio returns the fraction of the filesystem at path that is available to unprivileged users.
+func freeDiskRatio(path string) (float64, error) {
+	var stat syscall.Statfs_t
+
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("statfs '%s': %w", path, err)
+	}
+
+	if stat.Blocks == 0 {
+		return 1, nil
+	}
+
+	return float64(stat.Bavail) / float64(stat.Blocks), nil
 }
 
 func (v *VirtualK8S) NodeAddresses(_ context.Context) []corev1.NodeAddress {
@@ -154,7 +358,7 @@ func (v *VirtualK8S) NodeDaemonEndpoints(_ context.Context) corev1.NodeDaemonEnd
 	}
 }
 
-func (v *VirtualK8S) NodeSystemInfo(_ context.Context) corev1.NodeSystemInfo {
+func (v *VirtualK8S) NodeSystemInfo(ctx context.Context) corev1.NodeSystemInfo {
 	// goinfo.GetInfo may crash sometimes. use this method to recover and continue.
 	defer func() {
 		if r := recover(); r != nil {
@@ -185,8 +389,32 @@ func (v *VirtualK8S) NodeSystemInfo(_ context.Context) corev1.NodeSystemInfo {
 		OSImage:                 "hpk",
 		KubeProxyVersion:        version.K8sVersion,
 		KubeletVersion:          v.InitConfig.BuildVersion,
-		ContainerRuntimeVersion: "apptainer://1.1.3", // fixme: find it automatically
+		ContainerRuntimeVersion: "apptainer://" + v.apptainerVersion(ctx),
 		OperatingSystem:         operatingSystem,
 		Architecture:            architecture,
 	}
 }
+
+// apptainerVersion detects the version of Apptainer. If the detection fails, the last detected version is kept.
+func (v *VirtualK8S) apptainerVersion(ctx context.Context) string {
+	ctx, cancel := context.WithTimeout(ctx, slurm.PingTimeout)
+	defer cancel()
+
+	v.versionLock.Lock()
+	defer v.versionLock.Unlock()
+
+	detected, err := image.Version(ctx)
+	if err != nil {
+		v.Logger.Info("failed to detect the version of apptainer", "error", err)
+
+		if v.runtimeVersion == "" {
+			return "unknown"
+		}
+
+		return v.runtimeVersion
+	}
+
+	v.runtimeVersion = detected
+
+	return detected
+}
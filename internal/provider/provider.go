@@ -15,26 +15,29 @@
 package provider
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"hpk/internal/compute/control"
 	"hpk/internal/compute/endpoint"
 	"hpk/internal/compute/events"
+	"hpk/internal/compute/metrics"
 	PodHandler "hpk/internal/compute/podhandler"
 	"hpk/internal/compute/runtime"
 	"hpk/internal/compute/slurm"
 	"hpk/pkg/container"
 
 	"github.com/sirupsen/logrus"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/remotecommand"
 	statsv1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
 
 	"hpk/internal/compute"
@@ -47,13 +50,15 @@ import (
 	"github.com/virtual-kubelet/virtual-kubelet/errdefs"
 	vkapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
 // InitConfig is the config passed to initialize a registered provider.
 type InitConfig struct {
+	NodeName string
+
 	InternalIP string
 	DaemonPort int32
 
@@ -66,8 +71,18 @@ type InitConfig struct {
 	UseTmp bool
 
 	PauseImage string
+
+	// NodeStatusUpdateInterval is how often the status of the node is refreshed.
+	NodeStatusUpdateInterval time.Duration
+
+	// DiskPressureThreshold is the fraction of free space in the working directory, below which
+	// the node reports DiskPressure.
+	DiskPressureThreshold float64
 }
 
+// DefaultNodeStatusUpdateInterval is used when InitConfig.NodeStatusUpdateInterval is not set.
+const DefaultNodeStatusUpdateInterval = 10 * time.Second
+
 // VirtualK8S implements the virtual-kubelet provider interface and stores pods in memory.
 type VirtualK8S struct {
 	InitConfig
@@ -75,7 +90,23 @@ type VirtualK8S struct {
 	Logger logr.Logger
 
 	fileWatcher filenotify.FileWatcher
-	updatedPod  func(*corev1.Pod)
+
+	// startTime is reported as the start time of the node.
+	startTime time.Time
+
+	// dirSizes memoizes the sizes of volumes and images, which are reported in the statistics.
+	dirSizes dirSizeCache
+
+	// nodes are the virtual nodes, by name. Each pod is notified to the node to which it is scheduled.
+	nodesLock sync.RWMutex
+	nodes     map[string]*VirtualNode
+
+	// listenOnce starts the handling of control files, which is shared by all the virtual nodes.
+	listenOnce sync.Once
+
+	// runtimeVersion is the last detected version of Apptainer.
+	runtimeVersion string
+	versionLock    sync.Mutex
 }
 
 // NewVirtualK8S reads a kubeconfig file and sets up a client to interact
@@ -152,13 +183,17 @@ func NewVirtualK8S(config InitConfig) (*VirtualK8S, error) {
 		)
 	}
 
+	/*---------------------------------------------------
+	 * Load the Pods into memory
+	 *---------------------------------------------------*/
+	if err := PodHandler.Pods.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load pods: %w", err)
+	}
+
 	/*---------------------------------------------------
 	 * Set fsnotify watchers for Pods
 	 *---------------------------------------------------*/
-	if err := compute.HPK.WalkPodDirectories(func(path endpoint.PodPath) error {
-		// register the watcher
-		return watcher.Add(path.String())
-	}); err != nil {
+	if err := restoreWatchers(watcher); err != nil {
 		return nil, fmt.Errorf("failed to restore watchers: %w", err)
 	}
 
@@ -166,6 +201,8 @@ func NewVirtualK8S(config InitConfig) (*VirtualK8S, error) {
 		InitConfig:  config,
 		Logger:      logger,
 		fileWatcher: watcher,
+		startTime:   time.Now(),
+		nodes:       make(map[string]*VirtualNode),
 	}, nil
 }
 
@@ -210,9 +247,14 @@ func (v *VirtualK8S) CreatePod(ctx context.Context, pod *corev1.Pod) error {
 	go func() {
 		// acknowledge the creation request and do the creation in the background.
 		// if the creation fails, the pod should be marked as failed and returned to the provider.
-		PodHandler.CreatePod(ctx, pod, v.fileWatcher, v.UseTmp)
+		err := PodHandler.CreatePod(ctx, pod, v.fileWatcher, v.UseTmp, v.partitionOf(pod.Spec.NodeName))
+		if err != nil {
+			logger.Error(err, "Pod creation has failed")
+		}
+
+		metrics.PodCreated(pod.GetNamespace(), err)
 
-		v.updatedPod(pod)
+		v.notifyPod(pod)
 	}()
 
 	/*
@@ -235,51 +277,76 @@ func (v *VirtualK8S) UpdatePod(ctx context.Context, pod *corev1.Pod) error {
 
 	defer logger.Info("[K8s] <- UpdatePod")
 
-	/*---------------------------------------------------
-	 * Ensure that received pod is newer than the local
-	 *---------------------------------------------------*/
-	localPod, err := PodHandler.LoadPodFromKey(podKey)
-	if err != nil {
-		return errdefs.NotFoundf("object not found")
-	}
+	/*-- Update the local status of Pod, holding it against concurrent events --*/
+	err := PodHandler.UpdatePodInFile(ctx, podKey, func(localPod *corev1.Pod) error {
+		/*---------------------------------------------------
+		 * Ensure that received pod is newer than the local
+		 *---------------------------------------------------*/
+		if localPod.ResourceVersion >= pod.ResourceVersion {
+			// The received pod is old, so we can safely discard it
+			logger.Info("Discard update since its ResourceVersion is older than the local")
 
-	if localPod.ResourceVersion >= pod.ResourceVersion {
-		// The received pod is old, so we can safely discard it
-		logger.Info("Discard update since its ResourceVersion is older than the local")
+			return errUpdateDiscarded
+		}
 
-		return nil
-	}
+		/*---------------------------------------------------
+		 * Propagate labels and annotations to downwardAPI volumes
+		 *---------------------------------------------------*/
+		if !equality.Semantic.DeepEqual(localPod.Labels, pod.Labels) ||
+			!equality.Semantic.DeepEqual(localPod.Annotations, pod.Annotations) {
+			// the rest of the fields are taken from the local pod, which holds the status of the running job.
+			view := localPod.DeepCopy()
+			view.Labels = pod.Labels
+			view.Annotations = pod.Annotations
+
+			if err := PodHandler.RefreshDownwardAPIVolumes(ctx, view); err != nil {
+				logger.Info("failed to refresh downwardAPI volumes", "error", err)
+			}
+		}
 
-	if !slurm.HasJobID(pod) {
-		// If the pod has not a received a job id, it means that it still being in the Slurm queue.
-		logger.Info("Discard update because job is still in the queue")
-		return nil
-	}
+		if !slurm.HasJobID(pod) {
+			// If the pod has not a received a job id, it means that it still being in the Slurm queue.
+			logger.Info("Discard update because job is still in the queue")
 
-	/*---------------------------------------------------
-	 * Identify any intermediate actions that must taken
-	 *---------------------------------------------------*/
-	if metaDiff := pretty.Diff(localPod.ObjectMeta.Annotations, pod.ObjectMeta.Annotations); len(metaDiff) > 0 {
-		/* ... */
-		logrus.Warn("DIFFERENCES ", metaDiff)
-	}
+			return errUpdateDiscarded
+		}
 
-	if specDiff := pretty.Diff(localPod.Spec, pod.Spec); len(specDiff) > 0 {
-		/* ... */
-	}
+		/*---------------------------------------------------
+		 * Identify any intermediate actions that must taken
+		 *---------------------------------------------------*/
+		if metaDiff := pretty.Diff(localPod.ObjectMeta.Annotations, pod.ObjectMeta.Annotations); len(metaDiff) > 0 {
+			/* ... */
+			logrus.Warn("DIFFERENCES ", metaDiff)
+		}
 
-	if statusDiff := pretty.Diff(localPod.Status, pod.Status); len(statusDiff) > 0 {
-		/* ... */
-	}
+		if specDiff := pretty.Diff(localPod.Spec, pod.Spec); len(specDiff) > 0 {
+			/* ... */
+		}
+
+		if statusDiff := pretty.Diff(localPod.Status, pod.Status); len(statusDiff) > 0 {
+			/* ... */
+		}
 
-	/*-- Update the local status of Pod --*/
-	if err := PodHandler.SavePodToFile(ctx, pod); err != nil {
+		*localPod = *pod.DeepCopy()
+
+		return nil
+	})
+
+	switch {
+	case errors.Is(err, errUpdateDiscarded):
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		return errdefs.NotFoundf("object not found")
+	case err != nil:
 		compute.SystemPanic(err, "failed to set job id for pod '%s'", podKey)
 	}
 
 	return nil
 }
 
+// errUpdateDiscarded leaves the local pod unchanged, when the update is not to be applied.
+var errUpdateDiscarded = errors.New("update is discarded")
+
 // DeletePod takes a Kubernetes Pod and deletes it from the provider. Once a pod is deleted, the provider is
 // expected to call the NotifyPods callback with a terminal pod status where all the containers are in a terminal
 // state, as well as the pod. DeletePod may be called multiple times for the same pod.
@@ -289,7 +356,9 @@ func (v *VirtualK8S) DeletePod(ctx context.Context, pod *corev1.Pod) error {
 
 	logger.Info("[K8s] -> DeletePod")
 
-	if !PodHandler.DeletePod(podKey, v.fileWatcher) {
+	metrics.ForgetJob(podKey)
+
+	if !PodHandler.DeletePod(podKey, v.fileWatcher, v.notifyPod) {
 		logger.Info("[K8s] <- DeletePod (POD NOT FOUND)")
 
 		return errdefs.NotFoundf("object not found")
@@ -357,56 +426,14 @@ func (v *VirtualK8S) GetPods(ctx context.Context) ([]*corev1.Pod, error) {
 	v.Logger.Info("[K8s] -> GetPods")
 	defer v.Logger.Info("[K8s] <- GetPods")
 
-	/*---------------------------------------------------
-	 * Iterate the filesystem and extract local pods
-	 *---------------------------------------------------*/
-	var pods []*corev1.Pod
-
-	if err := compute.HPK.WalkPodDirectories(func(path endpoint.PodPath) error {
-		encodedPod, err := os.ReadFile(path.EncodedJSONPath())
-		if err != nil {
-			v.Logger.Info("Ignore Corrupted Pod Dir", "path", path, "error", err)
-
-			// continue with the rest of pods
-			return nil
-		}
-
-		var pod corev1.Pod
-
-		if err := json.Unmarshal(encodedPod, &pod); err != nil {
-			return fmt.Errorf("cannot decode pod description file '%s': %w", path, err)
-		}
-
-		/*-- return only the pods that are known to be running --*/
-		if pod.Status.Phase == corev1.PodRunning {
-			pods = append(pods, &pod)
-		}
-
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("failed to traverse pods: %w", err)
-	}
-
-	return pods, nil
+	/*-- the pods are served from memory, without traversing the filesystem --*/
+	return PodHandler.Pods.List(), nil
 }
 
-// NotifyPods instructs the notifier to call the passed in function when
-// the pod status changes. It should be called when a pod's status changes.
-//
-// The provided pointer to a Pod is guaranteed to be used in a read-only
-// fashion. The provided pod's PodStatus should be up-to-date when
-// this function is called.
-//
-// NotifyPods must not block the caller since it is only used to register the callback.
-// The callback passed into `NotifyPods` may block when called.
-func (v *VirtualK8S) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
-	v.Logger.Info("[K8s] -> NotifyPods")
-	defer v.Logger.Info("[K8s] <- NotifyPods")
-
-	/*---------------------------------------------------
-	 * Listen for Slurm Events caused by Pods.
-	 *---------------------------------------------------*/
-	v.updatedPod = f
+// listen handles the control files of all the pods, and notifies the virtual nodes of the changes.
+func (v *VirtualK8S) listen(ctx context.Context) {
+	v.Logger.Info("[K8s] -> Listen for pod events")
+	defer v.Logger.Info("[K8s] <- Listen for pod events")
 
 	/*-- start event handler --*/
 	eh := events.NewEventHandler(events.Options{
@@ -414,15 +441,19 @@ func (v *VirtualK8S) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
 		MaxQueueSize: 20,
 	})
 
+	metrics.ObserveEventQueue(func() int { return len(eh.Queue) }, cap(eh.Queue))
+
 	go eh.Listen(ctx, events.PodControl{
 		UpdateStatus: PodHandler.UpdateStatusFromRuntime,
-		LoadFromDisk: PodHandler.LoadPodFromKey,
+		UpdateOnDisk: func(podRef client.ObjectKey, update func(pod *corev1.Pod) error) error {
+			return PodHandler.UpdatePodInFile(ctx, podRef, update)
+		},
 		NotifyVirtualKubelet: func(pod *corev1.Pod) {
 			if pod == nil {
 				panic("this should not happen")
 			}
 
-			f(pod)
+			v.notifyPod(pod)
 
 			v.Logger.Info(" * K8s status is synchronized",
 				"version", pod.ResourceVersion,
@@ -455,14 +486,120 @@ func (v *VirtualK8S) NotifyPods(ctx context.Context, f func(*corev1.Pod)) {
 	}()
 }
 
+// notifyPod passes the pod to the virtual node to which it is scheduled.
+func (v *VirtualK8S) notifyPod(pod *corev1.Pod) {
+	var notify func(*corev1.Pod)
+
+	v.nodesLock.RLock()
+	if node, ok := v.nodes[pod.Spec.NodeName]; ok {
+		notify = node.updatedPod
+	}
+	v.nodesLock.RUnlock()
+
+	if notify == nil {
+		v.Logger.Info("Drop update of pod scheduled to an unknown node",
+			"obj", client.ObjectKeyFromObject(pod),
+			"node", pod.Spec.NodeName,
+		)
+
+		return
+	}
+
+	notify(pod)
+}
+
+// partitionOf returns the Slurm partition of the virtual node. Unknown nodes stand for the entire cluster.
+func (v *VirtualK8S) partitionOf(nodeName string) slurm.Partition {
+	v.nodesLock.RLock()
+	defer v.nodesLock.RUnlock()
+
+	if node, ok := v.nodes[nodeName]; ok {
+		return node.Partition
+	}
+
+	return slurm.Partition{}
+}
+
+// PortForward proxies the stream of `kubectl port-forward` to the given port of the pod.
+// hpk-kubelet can route to the pod IPs, so the stream is forwarded over a plain TCP connection.
 func (v *VirtualK8S) PortForward(ctx context.Context, namespace, pod string, port int32, stream io.ReadWriteCloser) error {
 	podKey := client.ObjectKey{Namespace: namespace, Name: pod}
 	logger := v.Logger.WithValues("obj", podKey)
 
-	logger.Info("[K8s] receive PortForward", "pod", pod)
+	logger.Info("[K8s] -> PortForward", "port", port)
+	defer logger.Info("[K8s] <- PortForward", "port", port)
 
-	// in newer virtual-kubelet version we have support for port-forwarding
-	return nil
+	defer stream.Close()
+
+	podIP, err := lookupPodIP(podKey)
+	if err != nil {
+		return err
+	}
+
+	return proxyStream(ctx, stream, net.JoinHostPort(podIP, strconv.Itoa(int(port))))
+}
+
+// lookupPodIP returns the IP of the pod, as reported in its status, or as announced by hpk-pause in the .ip control file.
+func lookupPodIP(podKey client.ObjectKey) (string, error) {
+	if pod, err := PodHandler.LoadPodFromKey(podKey); err == nil {
+		if fields := strings.Fields(pod.Status.PodIP); len(fields) > 0 {
+			return fields[0], nil
+		}
+	}
+
+	// the status may not have been updated yet.
+	data, err := os.ReadFile(compute.HPK.Pod(podKey).IPAddressPath())
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// the control file may hold multiple addresses. Use the first one, as in the pod status.
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", errdefs.NotFoundf("pod '%s' has no IP address yet", podKey)
+	}
+
+	return fields[0], nil
+}
+
+// proxyStream copies data between the stream and a TCP connection to the given address, until the
+// connection is closed by the remote end or the context is cancelled.
+func proxyStream(ctx context.Context, stream io.ReadWriteCloser, address string) error {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("cannot connect to '%s': %w", address, err)
+	}
+	defer conn.Close()
+
+	/*-- Forward the data of the client, and signal the end of its input --*/
+	go func() {
+		_, _ = io.Copy(conn, stream)
+
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.CloseWrite()
+		}
+	}()
+
+	/*-- Forward the data of the pod, until the remote end closes the connection --*/
+	copied := make(chan error, 1)
+
+	go func() {
+		_, err := io.Copy(stream, conn)
+		copied <- err
+	}()
+
+	select {
+	case err := <-copied:
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return fmt.Errorf("port forwarding to '%s' has failed: %w", address, err)
+		}
+
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
 }
 
 /************************************************************
@@ -471,11 +608,32 @@ func (v *VirtualK8S) PortForward(ctx context.Context, namespace, pod string, por
 
 ************************************************************/
 
-func (v *VirtualK8S) GetStatsSummary(context.Context) (*statsv1alpha1.Summary, error) {
+// GetStatsSummary returns the resource usage of the node and its pods, for `kubectl top` and metrics-server.
+// The usage of containers is sampled by their pause containers, and the sizes of volumes are measured locally.
+func (v *VirtualK8S) GetStatsSummary(ctx context.Context) (*statsv1alpha1.Summary, error) {
 	v.Logger.Info("[K8s] -> GetStatsSummary")
 	defer v.Logger.Info("[K8s] <- GetStatsSummary")
 
-	panic("not yet supported")
+	pods, err := v.GetPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	summary := &statsv1alpha1.Summary{
+		Pods: make([]statsv1alpha1.PodStats, 0, len(pods)),
+	}
+
+	for _, pod := range pods {
+		summary.Pods = append(summary.Pods, v.podStats(pod, now))
+	}
+
+	summary.Node = v.nodeStats(summary.Pods, now)
+
+	v.dirSizes.prune(now)
+
+	return summary, nil
 }
 
 // GetContainerLogs retrieves the logs of a container by name from the provider.
@@ -486,125 +644,112 @@ func (v *VirtualK8S) GetContainerLogs(ctx context.Context, namespace, podName, c
 	logger.Info("[K8s] -> GetContainerLogs", "container", containerName)
 	defer logger.Info("[K8s] <- GetContainerLogs", "container", containerName)
 
-	logfilePath := compute.HPK.Pod(podKey).Container(containerName).LogsPath()
+	containerPath := compute.HPK.Pod(podKey).Container(containerName)
+
+	logfilePath := containerPath.LogsPath()
+	if opts.Previous {
+		logfilePath = containerPath.PreviousLogsPath()
+	}
+
+	logOpts := &container.LogOptions{
+		Timestamps: opts.Timestamps,
+		Tail:       int64(opts.Tail),
+		LimitBytes: int64(opts.LimitBytes),
+	}
+
+	switch {
+	case opts.SinceSeconds > 0:
+		logOpts.Since = time.Now().Add(-time.Duration(opts.SinceSeconds) * time.Second)
+	case !opts.SinceTime.IsZero():
+		logOpts.Since = opts.SinceTime
+	}
 
 	/*---------------------------------------------------
 	 * Log Streaming (With Follow)
 	 *---------------------------------------------------*/
-	if opts.Follow {
-		// 	return io.NopCloser(strings.NewReader("Follow is not yet supported by HPK.\n")), nil
-		v.Logger.Info("[K8s] WARNING -- Log with \"follow\" is not yet supported by HPK")
-
-		/*
-			seek := tail.SeekInfo{
-				Offset: 0,
-				Whence: 0,
-			}
-
-			// whence 0=origin, 2=end
-			if opts.Tail >= 0 {
-				seek.Whence = 2
-			}
+	if opts.Follow && !opts.Previous {
+		// the container has stopped once its exit code is written.
+		exited := func() bool {
+			_, err := os.Stat(containerPath.ExitCodePath())
+			return err == nil
+		}
 
-			tailConfig := tail.Config{
-				MustExist: true,
-				Poll:      true,
-				Follow:    opts.Follow,
-				Location:  &seek,
-				DefaultLogger:    tail.DefaultLogger,
-				ReOpen:    opts.Follow,
-			}
+		pr, pw := io.Pipe()
 
-			// tail the file and stream it to a pipe (that ends to a network socket).
-			t, err := tail.TailFile(logfilePath, tailConfig)
+		go func() {
+			err := container.FollowLogs(ctx, logfilePath, pw, logOpts, exited)
 			if err != nil {
-				return nil, errors.Wrapf(err, "unable to stream log file")
+				logger.Error(err, "[K8s] Log streaming has failed", "container", containerName)
 			}
 
-			pr, pw := io.Pipe()
-
-			go func() {
-				defer pw.Close()
-
-				var line *tail.Line
-				var ok bool
-
-				for {
-					select {
-					case <-ctx.Done():
-						// the consumer has cancelled
-						t.Kill(errors.New("hangup by client"))
-						return
-					case line, ok = <-t.Lines:
-						if !ok {
-							// channel was closed
-							return
-						}
-					}
+			pw.CloseWithError(err)
+		}()
 
-					n, err := pw.Write([]byte(line.Text))
-					if err != nil {
-						panic("this should never happen:" + err.Error())
-					}
-
-					// Unfortunately this pience of code does not seem to work
-					// because virtual kubelet does not support streaming.
-					// Nonethess, it stays here for further investigation.
-				}
-			}()
-
-			return io.NopCloser(pr), nil
-		*/
+		return pr, nil
 	}
 
 	/*---------------------------------------------------
 	 * Log Batch (Without Follow)
 	 *---------------------------------------------------*/
-	if opts.Tail == 0 {
-		// return everything
-		logs, err := os.Open(logfilePath)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				// return an empty response instead of an error
-				return io.NopCloser(bytes.NewReader([]byte{})), nil
-			}
+	pr, pw := io.Pipe()
 
-			return nil, fmt.Errorf("unable to batch logs: %w", err)
-		}
+	// decode the CRI-formatted lines while streaming them to the client.
+	// If there are no logs yet, the response is empty instead of an error.
+	go func() {
+		pw.CloseWithError(container.ReadLogFiles(logfilePath, pw, logOpts))
+	}()
 
-		return logs, nil
-	}
+	return pr, nil
+}
 
-	if opts.Tail > 0 {
-		logs, err := container.GetTailLog(logfilePath, opts.Tail)
-		if err != nil {
-			return nil, fmt.Errorf("unable to batch logs: %w", err)
-		}
+// RunInContainer executes a command in a container in the pod, copying data
+// between in/out/err and the container's stdin/stdout/stderr.
+func (v *VirtualK8S) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach vkapi.AttachIO) error {
+	podKey := client.ObjectKey{Namespace: namespace, Name: podName}
+	logger := v.Logger.WithValues("obj", podKey)
 
-		results := bytes.NewBuffer(nil)
+	/*---------------------------------------------------
+	 * Preamble used for Request tracing on the logs
+	 *---------------------------------------------------*/
+	logger.Info("[K8s] -> RunInContainer", "container", containerName)
+	defer logger.Info("[K8s] <- RunInContainer", "container", containerName)
 
-		for _, nll := range logs {
-			results.WriteString(nll + "\n")
+	defer func() {
+		if attach.Stdout() != nil {
+			attach.Stdout().Close()
+		}
+		if attach.Stderr() != nil {
+			attach.Stderr().Close()
 		}
+	}()
 
-		return io.NopCloser(bytes.NewReader(results.Bytes())), nil
+	/*---------------------------------------------------
+	 * Run the command through the control socket of the pod
+	 *---------------------------------------------------*/
+	req := control.Request{
+		Operation: control.OperationExec,
+		Container: containerName,
+		Command:   cmd,
+		Stdin:     attach.Stdin() != nil,
+		Stdout:    attach.Stdout() != nil,
+		Stderr:    attach.Stderr() != nil,
+		TTY:       attach.TTY(),
 	}
 
-	// return an empty response instead of an error
-	return io.NopCloser(bytes.NewReader([]byte{})), nil
+	return control.Run(ctx, compute.HPK.Pod(podKey).ControlSocketPath(), req, attachStreams(ctx, attach))
 }
 
-// RunInContainer executes a command in a container in the pod, copying data
-// between in/out/err and the container's stdin/stdout/stderr.
-func (v *VirtualK8S) RunInContainer(ctx context.Context, namespace, podName, containerName string, cmd []string, attach vkapi.AttachIO) error {
+// AttachToContainer attaches to the stdin (or tty) and the output of a running container, through the control socket
+// of the pod. The attachment ends once the client detaches or the container exits.
+func (v *VirtualK8S) AttachToContainer(ctx context.Context, namespace, podName, containerName string, attach vkapi.AttachIO) error {
 	podKey := client.ObjectKey{Namespace: namespace, Name: podName}
 	logger := v.Logger.WithValues("obj", podKey)
 
 	/*---------------------------------------------------
 	 * Preamble used for Request tracing on the logs
 	 *---------------------------------------------------*/
-	logger.Info("[K8s] -> RunInContainer", "container", containerName)
-	defer logger.Info("[K8s] <- RunInContainer", "container", containerName)
+	logger.Info("[K8s] -> AttachToContainer", "container", containerName)
+	defer logger.Info("[K8s] <- AttachToContainer", "container", containerName)
 
 	defer func() {
 		if attach.Stdout() != nil {
@@ -615,47 +760,61 @@ func (v *VirtualK8S) RunInContainer(ctx context.Context, namespace, podName, con
 		}
 	}()
 
-	req := compute.K8SClientset.RESTClient().
-		Post().
-		Namespace(namespace).
-		Resource("pods").
-		Name(podName).
-		SubResource("exec").
-		Timeout(0).
-		VersionedParams(&corev1.PodExecOptions{
-			Container: containerName,
-			Command:   cmd,
-			Stdin:     attach.Stdin() != nil,
-			Stdout:    attach.Stdout() != nil,
-			Stderr:    attach.Stderr() != nil,
-			TTY:       attach.TTY(),
-		}, scheme.ParameterCodec)
-
-	exec, err := remotecommand.NewSPDYExecutor(v.InitConfig.RestConfig, "POST", req.URL())
-	if err != nil {
-		return fmt.Errorf("could not make remote command: %w", err)
+	req := control.Request{
+		Operation: control.OperationAttach,
+		Container: containerName,
+		Stdin:     attach.Stdin() != nil,
+		Stdout:    attach.Stdout() != nil,
+		Stderr:    attach.Stderr() != nil,
+		TTY:       attach.TTY(),
 	}
 
-	return exec.Stream(remotecommand.StreamOptions{
-		Stdin:             attach.Stdin(),
-		Stdout:            attach.Stdout(),
-		Stderr:            attach.Stderr(),
-		Tty:               attach.TTY(),
-		TerminalSizeQueue: &termSize{attach: attach},
-	})
+	return control.Run(ctx, compute.HPK.Pod(podKey).ControlSocketPath(), req, attachStreams(ctx, attach))
 }
 
-// termSize helps exec termSize
-type termSize struct {
-	attach vkapi.AttachIO
-}
+// attachStreams adapts the streams of an exec (or attach) request to the streams of a control session.
+func attachStreams(ctx context.Context, attach vkapi.AttachIO) control.Streams {
+	streams := control.Streams{}
+
+	// avoid typed nil interfaces, which would be forwarded as streams.
+	if attach.Stdin() != nil {
+		streams.Stdin = attach.Stdin()
+	}
 
-// Next returns the new terminal size after the terminal has been resized. It returns nil when
-// monitoring has been stopped.
-func (t *termSize) Next() *remotecommand.TerminalSize {
-	resize := <-t.attach.Resize()
-	return &remotecommand.TerminalSize{
-		Height: resize.Height,
-		Width:  resize.Width,
+	if attach.Stdout() != nil {
+		streams.Stdout = attach.Stdout()
 	}
+
+	if attach.Stderr() != nil {
+		streams.Stderr = attach.Stderr()
+	}
+
+	if attach.TTY() && attach.Resize() != nil {
+		resize := make(chan control.TerminalSize)
+
+		go func() {
+			defer close(resize)
+
+			for {
+				select {
+				case size, ok := <-attach.Resize():
+					if !ok {
+						return
+					}
+
+					select {
+					case resize <- control.TerminalSize{Width: size.Width, Height: size.Height}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+
+		streams.Resize = resize
+	}
+
+	return streams
 }
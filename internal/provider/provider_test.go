@@ -0,0 +1,449 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/endpoint"
+	PodHandler "hpk/internal/compute/podhandler"
+	"hpk/internal/compute/podstore"
+	"hpk/internal/compute/slurm"
+	"hpk/internal/compute/usage"
+	"hpk/pkg/container"
+	"hpk/pkg/filenotify"
+
+	kwhmodel "github.com/slok/kubewebhook/v2/pkg/model"
+	vkapi "github.com/virtual-kubelet/virtual-kubelet/node/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsv1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// pipeStream joins the two halves of an in-memory stream.
+type pipeStream struct {
+	io.Reader
+	io.WriteCloser
+}
+
+func TestProxyStream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	// the server echoes its input, until the end of input.
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	clientReader, proxyWriter := io.Pipe()
+	proxyReader, clientWriter := io.Pipe()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- proxyStream(context.Background(), pipeStream{Reader: proxyReader, WriteCloser: proxyWriter}, listener.Addr().String())
+	}()
+
+	go func() {
+		_, _ = clientWriter.Write([]byte("ping"))
+		_ = clientWriter.Close()
+	}()
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(clientReader, reply); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	if string(reply) != "ping" {
+		t.Errorf("got %q, want %q", reply, "ping")
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("proxy: %v", err)
+	}
+}
+
+func TestLookupPodIP(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	podKey := client.ObjectKey{Namespace: "default", Name: "test"}
+	podPath := compute.HPK.Pod(podKey)
+
+	if _, err := lookupPodIP(podKey); err == nil {
+		t.Errorf("expected error for pod without IP")
+	}
+
+	if err := os.MkdirAll(podPath.ControlFileDir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	if err := os.WriteFile(podPath.IPAddressPath(), []byte("10.0.0.5 10.1.0.5"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	ip, err := lookupPodIP(podKey)
+	if err != nil || ip != "10.0.0.5" {
+		t.Errorf("got %q, %v, want 10.0.0.5", ip, err)
+	}
+}
+
+func TestPodStats(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "uid"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "main"}, {Name: "stale"}},
+			Volumes:    []corev1.Volume{{Name: "data"}},
+		},
+	}
+
+	podPath := compute.HPK.Pod(client.ObjectKeyFromObject(pod))
+
+	for _, dir := range []string{podPath.JobDir(), podPath.LogDir(), filepath.Join(podPath.VolumeDir(), "data")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+	}
+
+	now := time.Now()
+
+	if err := usage.Write(podPath.Container("main").UsagePath(), usage.ContainerUsage{
+		Timestamp: now, UsageNanoCores: 500, UsageCoreNanoSeconds: 1000, WorkingSetBytes: 2048,
+	}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := usage.Write(podPath.Container("stale").UsagePath(), usage.ContainerUsage{
+		Timestamp: now.Add(-time.Hour), UsageNanoCores: 1,
+	}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(podPath.VolumeDir(), "data", "file"), make([]byte, 100), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	v := &VirtualK8S{}
+
+	stats := v.podStats(pod, now)
+
+	if len(stats.Containers) != 1 || stats.Containers[0].Name != "main" {
+		t.Fatalf("expected only the fresh container, got %+v", stats.Containers)
+	}
+
+	if *stats.CPU.UsageNanoCores != 500 || *stats.Memory.WorkingSetBytes != 2048 {
+		t.Errorf("unexpected pod usage %+v %+v", stats.CPU, stats.Memory)
+	}
+
+	if len(stats.VolumeStats) != 1 || *stats.VolumeStats[0].UsedBytes != 100 {
+		t.Errorf("unexpected volume stats %+v", stats.VolumeStats)
+	}
+
+	node := v.nodeStats([]statsv1alpha1.PodStats{stats}, now)
+	if *node.CPU.UsageNanoCores != 500 || node.Fs == nil {
+		t.Errorf("unexpected node stats %+v", node)
+	}
+}
+
+func TestGetContainerLogs(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+
+	podKey := client.ObjectKey{Namespace: "default", Name: "test"}
+	containerPath := compute.HPK.Pod(podKey).Container("main")
+
+	if err := os.MkdirAll(compute.HPK.Pod(podKey).LogDir(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	now := time.Now()
+	line := func(at time.Time, msg string) string {
+		return at.Format(container.LogTimeFormat) + " stdout F " + msg + "\n"
+	}
+
+	if err := os.WriteFile(containerPath.LogsPath(), []byte(line(now.Add(-time.Hour), "old")+line(now, "new")), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := os.WriteFile(containerPath.PreviousLogsPath(), []byte(line(now.Add(-2*time.Hour), "previous")), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	v := &VirtualK8S{Logger: compute.DefaultLogger}
+
+	for _, tc := range []struct {
+		opts     vkapi.ContainerLogOpts
+		expected string
+	}{
+		{vkapi.ContainerLogOpts{}, "old\nnew\n"},
+		{vkapi.ContainerLogOpts{SinceSeconds: 600}, "new\n"},
+		{vkapi.ContainerLogOpts{SinceTime: now.Add(-time.Minute)}, "new\n"},
+		{vkapi.ContainerLogOpts{LimitBytes: 2}, "ol"},
+		{vkapi.ContainerLogOpts{Previous: true}, "previous\n"},
+		{vkapi.ContainerLogOpts{Previous: true, Follow: true}, "previous\n"},
+	} {
+		logs, err := v.GetContainerLogs(context.Background(), podKey.Namespace, podKey.Name, "main", tc.opts)
+		if err != nil {
+			t.Fatalf("%+v: %v", tc.opts, err)
+		}
+
+		data, err := io.ReadAll(logs)
+		logs.Close()
+
+		if err != nil || string(data) != tc.expected {
+			t.Errorf("%+v: got %q (%v), want %q", tc.opts, data, err, tc.expected)
+		}
+	}
+}
+
+func TestRecoverPods(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+	PodHandler.Pods = podstore.New()
+
+	pods := []*corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sysfailed"}, Status: corev1.PodStatus{Phase: corev1.PodPending}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "succeeded"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+	}
+
+	for _, pod := range pods {
+		podPath := compute.HPK.Pod(client.ObjectKeyFromObject(pod))
+
+		for _, dir := range []string{podPath.JobDir(), podPath.ControlFileDir()} {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatalf("mkdir: %v", err)
+			}
+		}
+
+		if err := PodHandler.SavePodToFile(context.Background(), pod); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+
+	if err := os.WriteFile(compute.HPK.Pod(client.ObjectKeyFromObject(pods[0])).SysErrorFilePath(), []byte("sbatch: error"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// a pod directory without a pod description is not recovered.
+	if err := os.MkdirAll(compute.HPK.Pod(client.ObjectKey{Namespace: "default", Name: "corrupted"}).String(), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	/*-- Watchers are set on the control files --*/
+	watcher := filenotify.NewPollingWatcher(time.Hour)
+	defer watcher.Close()
+
+	if err := restoreWatchers(watcher); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	for _, pod := range pods {
+		if err := watcher.Add(compute.HPK.Pod(client.ObjectKeyFromObject(pod)).ControlFileDir()); !errors.Is(err, filenotify.ErrWatchExists) {
+			t.Errorf("%s: expected watcher on control files, got %v", pod.Name, err)
+		}
+	}
+
+	/*-- Every pod is reported, regardless of its phase --*/
+	v := &VirtualK8S{Logger: compute.DefaultLogger}
+
+	listed, err := v.GetPods(context.Background())
+	if err != nil || len(listed) != len(pods) {
+		t.Fatalf("expected %d pods, got %d (%v)", len(pods), len(listed), err)
+	}
+
+	/*-- The status is recomputed and notified --*/
+	phases := map[string]corev1.PodPhase{}
+
+	v.recoverPods(context.Background(), "", func(pod *corev1.Pod) {
+		phases[pod.Name] = pod.Status.Phase
+	})
+
+	expected := map[string]corev1.PodPhase{"sysfailed": corev1.PodFailed, "succeeded": corev1.PodSucceeded}
+	if !reflect.DeepEqual(phases, expected) {
+		t.Errorf("got phases %v, want %v", phases, expected)
+	}
+}
+
+func conditionOf(conditions []corev1.NodeCondition, conditionType corev1.NodeConditionType) corev1.NodeCondition {
+	for _, condition := range conditions {
+		if condition.Type == conditionType {
+			return condition
+		}
+	}
+
+	return corev1.NodeCondition{}
+}
+
+func TestNodeConditions(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+	if err := os.MkdirAll(compute.HPK.String(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &VirtualK8S{InitConfig: InitConfig{DiskPressureThreshold: 0}}
+
+	conditions := v.NodeConditions(context.Background())
+
+	if ready := conditionOf(conditions, corev1.NodeReady); ready.Status != corev1.ConditionTrue {
+		t.Errorf("got Ready %s (%s), want True", ready.Status, ready.Message)
+	}
+
+	if disk := conditionOf(conditions, corev1.NodeDiskPressure); disk.Status != corev1.ConditionFalse {
+		t.Errorf("got DiskPressure %s (%s), want False", disk.Status, disk.Message)
+	}
+
+	/*-- A threshold above 100% is always crossed --*/
+	v.DiskPressureThreshold = 1.1
+
+	if disk := conditionOf(v.NodeConditions(context.Background()), corev1.NodeDiskPressure); disk.Status != corev1.ConditionTrue {
+		t.Errorf("got DiskPressure %s, want True", disk.Status)
+	}
+
+	/*-- Slurm does not respond --*/
+	compute.Environment.RunSlurm = true
+	statsCmd := slurm.Slurm.StatsCmd
+	slurm.Slurm.StatsCmd = "false"
+
+	defer func() {
+		compute.Environment.RunSlurm = false
+		slurm.Slurm.StatsCmd = statsCmd
+	}()
+
+	later := v.NodeConditions(context.Background())
+
+	if ready := conditionOf(later, corev1.NodeReady); ready.Status != corev1.ConditionFalse {
+		t.Errorf("got Ready %s, want False", ready.Status)
+	}
+
+	/*-- Transition times change only along with the status --*/
+	past := metav1.NewTime(time.Now().Add(-time.Hour))
+	for i := range conditions {
+		conditions[i].LastTransitionTime = past
+	}
+
+	merged := mergeConditions(conditions, later)
+
+	if ready := conditionOf(merged, corev1.NodeReady); ready.LastTransitionTime.Equal(&past) {
+		t.Errorf("Ready has changed, but kept its transition time")
+	}
+
+	if memory := conditionOf(merged, corev1.NodeMemoryPressure); !memory.LastTransitionTime.Equal(&past) {
+		t.Errorf("MemoryPressure has not changed, but got a new transition time")
+	}
+}
+
+func TestNotifyNodeStatus(t *testing.T) {
+	compute.HPK = endpoint.HPK(t.TempDir())
+	if err := os.MkdirAll(compute.HPK.String(), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &VirtualK8S{
+		InitConfig: InitConfig{NodeStatusUpdateInterval: time.Millisecond},
+		nodes:      make(map[string]*VirtualNode),
+	}
+
+	n := v.NewVirtualNode(context.Background(), "node-status", slurm.Partition{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan *corev1.Node, 1)
+
+	n.NotifyNodeStatus(ctx, func(node *corev1.Node) {
+		select {
+		case updates <- node:
+		default:
+		}
+	})
+
+	/*-- The node is read while it is being refreshed --*/
+	for i := 0; i < 3; i++ {
+		select {
+		case <-updates:
+		case <-time.After(10 * time.Second):
+			t.Fatal("the status of the node is not refreshed")
+		}
+
+		if node := n.Node(); node.Name != "node-status" || node.Status.Phase == "" {
+			t.Errorf("got %+v", node)
+		}
+	}
+}
+
+func TestMutatePVC(t *testing.T) {
+	review := &kwhmodel.AdmissionReview{Operation: kwhmodel.OperationCreate}
+	mutate := MutatePVC("hpk-kubelet")
+
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "data"}}
+
+	result, err := mutate(context.Background(), review, pvc)
+	if err != nil || result.MutatedObject == nil || pvc.Annotations[SelectedNodeAnnotation] != "hpk-kubelet" {
+		t.Fatalf("got %+v (%v), want a PVC bound to the node", pvc.Annotations, err)
+	}
+
+	/*-- PVCs that are already bound keep their node --*/
+	pvc.Annotations[SelectedNodeAnnotation] = "worker-1"
+
+	result, err = mutate(context.Background(), review, pvc)
+	if err != nil || result.MutatedObject != nil || pvc.Annotations[SelectedNodeAnnotation] != "worker-1" {
+		t.Errorf("got %+v (%v), want the PVC bound to worker-1", pvc.Annotations, err)
+	}
+}
+
+func TestValidatePod(t *testing.T) {
+	review := &kwhmodel.AdmissionReview{Operation: kwhmodel.OperationCreate}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "job"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "main", Image: "busybox"}},
+			Volumes: []corev1.Volume{
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+
+	result, err := ValidatePod(context.Background(), review, pod)
+	if err != nil || !result.Valid {
+		t.Fatalf("got %+v (%v), want a valid pod", result, err)
+	}
+
+	/*-- Volumes that cannot be mounted and invalid Slurm options are reported together --*/
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         "remote",
+		VolumeSource: corev1.VolumeSource{NFS: &corev1.NFSVolumeSource{Server: "nfs", Path: "/"}},
+	})
+	pod.Annotations = map[string]string{slurm.AnnotationTimeLimit: "forever"}
+
+	result, err = ValidatePod(context.Background(), review, pod)
+	if err != nil || result.Valid {
+		t.Fatalf("got %+v (%v), want an invalid pod", result, err)
+	}
+
+	if !strings.Contains(result.Message, ".Spec.Volumes[1] (remote)") || !strings.Contains(result.Message, slurm.AnnotationTimeLimit) {
+		t.Errorf("got message %q", result.Message)
+	}
+
+	/*-- Existing pods are not validated --*/
+	if result, _ := ValidatePod(context.Background(), &kwhmodel.AdmissionReview{Operation: kwhmodel.OperationUpdate}, pod); !result.Valid {
+		t.Errorf("updates should be allowed")
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/endpoint"
+	"hpk/internal/compute/metrics"
+	PodHandler "hpk/internal/compute/podhandler"
+	"hpk/pkg/filenotify"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+/************************************************************
+
+		Recover the Pods of a previous hpk-kubelet instance
+
+************************************************************/
+
+// restoreWatchers watches the control files of the existing pods, as CreatePod does for new pods.
+func restoreWatchers(watcher filenotify.FileWatcher) error {
+	return compute.HPK.WalkPodDirectories(func(path endpoint.PodPath) error {
+		if _, err := os.Stat(path.ControlFileDir()); err != nil {
+			compute.DefaultLogger.Info("Pod has no control files to watch", "path", path, "err", err)
+
+			return nil
+		}
+
+		if err := watcher.Add(path.ControlFileDir()); err != nil && !errors.Is(err, filenotify.ErrWatchExists) {
+			return fmt.Errorf("failed to watch '%s': %w", path.ControlFileDir(), err)
+		}
+
+		return nil
+	})
+}
+
+// recoverPods recomputes the status of the existing pods that are scheduled to the node from their control
+// files, and notifies the pod controller. The control files that have been written while hpk-kubelet was down
+// have generated no events, so without this pass their pods would be stuck at the last status before the restart.
+func (v *VirtualK8S) recoverPods(ctx context.Context, nodeName string, notify func(*corev1.Pod)) {
+	for _, listed := range PodHandler.Pods.List() {
+		if listed.Spec.NodeName != nodeName {
+			continue
+		}
+
+		podKey := client.ObjectKeyFromObject(listed)
+
+		var (
+			pod           *corev1.Pod
+			previousPhase corev1.PodPhase
+		)
+
+		// the recovered status is stored, so that the events that follow are reconciled against it.
+		if err := PodHandler.UpdatePodInFile(ctx, podKey, func(local *corev1.Pod) error {
+			previousPhase = local.Status.Phase
+
+			if _, err := os.Stat(compute.HPK.Pod(podKey).SysErrorFilePath()); err == nil {
+				/*-- Sbatch failed while hpk-kubelet was down --*/
+				if !isTerminal(previousPhase) {
+					compute.PodError(local, "SYSERROR", "Pod creation has failed")
+				}
+			} else {
+				PodHandler.UpdateStatusFromRuntime(local)
+			}
+
+			pod = local
+
+			return nil
+		}); errors.Is(err, fs.ErrNotExist) {
+			// the pod has been deleted in the meantime.
+			continue
+		} else if err != nil {
+			compute.SystemPanic(err, "failed to save pod '%s'", podKey)
+
+			continue
+		}
+
+		if !isTerminal(previousPhase) && isTerminal(pod.Status.Phase) {
+			metrics.PodTerminated(podKey.Namespace, pod.Status.Phase == corev1.PodSucceeded)
+		}
+
+		v.Logger.Info(" * Pod has been recovered",
+			"obj", podKey,
+			"previousPhase", previousPhase,
+			"phase", pod.Status.Phase,
+		)
+
+		notify(pod)
+	}
+}
+
+func isTerminal(phase corev1.PodPhase) bool {
+	return phase == corev1.PodFailed || phase == corev1.PodSucceeded
+}
@@ -0,0 +1,318 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"hpk/internal/compute"
+	"hpk/internal/compute/usage"
+
+	"golang.org/x/sys/unix"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	statsv1alpha1 "k8s.io/kubelet/pkg/apis/stats/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// usageStaleness is the age after which the usage of a container is considered outdated. This happens
+	// when the pause container has exited, or the Slurm node is unreachable.
+	usageStaleness = 1 * time.Minute
+
+	// dirSizeTTL bounds how often the directories of volumes are walked, as they usually reside on shared filesystems.
+	dirSizeTTL = 1 * time.Minute
+)
+
+// podStats returns the statistics of a pod, as sampled by its pause container.
+// Containers without a recent sample are omitted.
+func (v *VirtualK8S) podStats(pod *corev1.Pod, now time.Time) statsv1alpha1.PodStats {
+	podPath := compute.HPK.Pod(client.ObjectKeyFromObject(pod))
+
+	stats := statsv1alpha1.PodStats{
+		PodRef: statsv1alpha1.PodReference{
+			Name:      pod.GetName(),
+			Namespace: pod.GetNamespace(),
+			UID:       string(pod.GetUID()),
+		},
+		Containers: []statsv1alpha1.ContainerStats{},
+	}
+
+	if pod.Status.StartTime != nil {
+		stats.StartTime = *pod.Status.StartTime
+	}
+
+	var (
+		podCPU     statsv1alpha1.CPUStats
+		podMemory  statsv1alpha1.MemoryStats
+		sampled    bool
+		ephemeral  uint64
+		logsInodes uint64
+	)
+
+	/*---------------------------------------------------
+	 * Containers
+	 *---------------------------------------------------*/
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		containerPath := podPath.Container(container.Name)
+
+		sample, err := usage.Read(containerPath.UsagePath())
+		if err != nil || now.Sub(sample.Timestamp) > usageStaleness {
+			continue
+		}
+
+		timestamp := metav1.NewTime(sample.Timestamp)
+
+		containerStats := statsv1alpha1.ContainerStats{
+			Name:      container.Name,
+			StartTime: metav1.NewTime(sample.StartTime),
+			CPU: &statsv1alpha1.CPUStats{
+				Time:                 timestamp,
+				UsageNanoCores:       &sample.UsageNanoCores,
+				UsageCoreNanoSeconds: &sample.UsageCoreNanoSeconds,
+			},
+			Memory: &statsv1alpha1.MemoryStats{
+				Time:            timestamp,
+				UsageBytes:      &sample.UsageBytes,
+				WorkingSetBytes: &sample.WorkingSetBytes,
+				RSSBytes:        &sample.RSSBytes,
+			},
+		}
+
+		if sample.StartTime.IsZero() {
+			containerStats.StartTime = stats.StartTime
+		}
+
+		if info, err := os.Stat(containerPath.LogsPath()); err == nil {
+			logsBytes := uint64(info.Size())
+
+			containerStats.Logs = &statsv1alpha1.FsStats{Time: timestamp, UsedBytes: &logsBytes}
+
+			ephemeral += logsBytes
+			logsInodes++
+		}
+
+		stats.Containers = append(stats.Containers, containerStats)
+
+		sampled = true
+
+		podCPU.Time = timestamp
+		podCPU.UsageNanoCores = addUint64(podCPU.UsageNanoCores, sample.UsageNanoCores)
+		podCPU.UsageCoreNanoSeconds = addUint64(podCPU.UsageCoreNanoSeconds, sample.UsageCoreNanoSeconds)
+
+		podMemory.Time = timestamp
+		podMemory.UsageBytes = addUint64(podMemory.UsageBytes, sample.UsageBytes)
+		podMemory.WorkingSetBytes = addUint64(podMemory.WorkingSetBytes, sample.WorkingSetBytes)
+		podMemory.RSSBytes = addUint64(podMemory.RSSBytes, sample.RSSBytes)
+	}
+
+	if sampled {
+		stats.CPU = &podCPU
+		stats.Memory = &podMemory
+	}
+
+	/*---------------------------------------------------
+	 * Volumes
+	 *---------------------------------------------------*/
+	for _, vol := range pod.Spec.Volumes {
+		usedBytes, inodes, ok := v.dirSizes.get(filepath.Join(podPath.VolumeDir(), vol.Name), now)
+		if !ok {
+			continue
+		}
+
+		volumeStats := statsv1alpha1.VolumeStats{
+			Name: vol.Name,
+			FsStats: statsv1alpha1.FsStats{
+				Time:       metav1.NewTime(now),
+				UsedBytes:  &usedBytes,
+				InodesUsed: &inodes,
+			},
+		}
+
+		if vol.PersistentVolumeClaim != nil {
+			volumeStats.PVCRef = &statsv1alpha1.PVCReference{
+				Name:      vol.PersistentVolumeClaim.ClaimName,
+				Namespace: pod.GetNamespace(),
+			}
+		} else {
+			// volumes that are not backed by claims are local to the pod.
+			ephemeral += usedBytes
+			logsInodes += inodes
+		}
+
+		stats.VolumeStats = append(stats.VolumeStats, volumeStats)
+	}
+
+	stats.EphemeralStorage = &statsv1alpha1.FsStats{
+		Time:       metav1.NewTime(now),
+		UsedBytes:  &ephemeral,
+		InodesUsed: &logsInodes,
+	}
+
+	return stats
+}
+
+// nodeStats returns the statistics of the virtual node. Since the node stands for the whole Slurm cluster,
+// its usage is the sum of the pods, and its filesystem is the working directory of HPK.
+func (v *VirtualK8S) nodeStats(pods []statsv1alpha1.PodStats, now time.Time) statsv1alpha1.NodeStats {
+	stats := statsv1alpha1.NodeStats{
+		NodeName:  v.NodeName,
+		StartTime: metav1.NewTime(v.startTime),
+	}
+
+	timestamp := metav1.NewTime(now)
+
+	var (
+		cpu    = statsv1alpha1.CPUStats{Time: timestamp}
+		memory = statsv1alpha1.MemoryStats{Time: timestamp}
+	)
+
+	for _, pod := range pods {
+		if pod.CPU != nil {
+			cpu.UsageNanoCores = addUint64(cpu.UsageNanoCores, derefUint64(pod.CPU.UsageNanoCores))
+			cpu.UsageCoreNanoSeconds = addUint64(cpu.UsageCoreNanoSeconds, derefUint64(pod.CPU.UsageCoreNanoSeconds))
+		}
+
+		if pod.Memory != nil {
+			memory.UsageBytes = addUint64(memory.UsageBytes, derefUint64(pod.Memory.UsageBytes))
+			memory.WorkingSetBytes = addUint64(memory.WorkingSetBytes, derefUint64(pod.Memory.WorkingSetBytes))
+			memory.RSSBytes = addUint64(memory.RSSBytes, derefUint64(pod.Memory.RSSBytes))
+		}
+	}
+
+	stats.CPU = &cpu
+	stats.Memory = &memory
+
+	if fsStats, err := filesystemStats(compute.HPK.String(), now); err == nil {
+		stats.Fs = fsStats
+
+		imageFs := *fsStats
+
+		if usedBytes, inodes, ok := v.dirSizes.get(compute.HPK.ImageDir(), now); ok {
+			imageFs.UsedBytes = &usedBytes
+			imageFs.InodesUsed = &inodes
+		}
+
+		stats.Runtime = &statsv1alpha1.RuntimeStats{ImageFs: &imageFs}
+	}
+
+	return stats
+}
+
+// filesystemStats returns the capacity and the usage of the filesystem where path resides.
+func filesystemStats(path string, now time.Time) (*statsv1alpha1.FsStats, error) {
+	var statfs unix.Statfs_t
+
+	if err := unix.Statfs(path, &statfs); err != nil {
+		return nil, err
+	}
+
+	blockSize := uint64(statfs.Bsize)
+
+	capacity := statfs.Blocks * blockSize
+	available := statfs.Bavail * blockSize
+	used := (statfs.Blocks - statfs.Bfree) * blockSize
+	inodes := statfs.Files
+	inodesFree := statfs.Ffree
+	inodesUsed := inodes - inodesFree
+
+	return &statsv1alpha1.FsStats{
+		Time:           metav1.NewTime(now),
+		AvailableBytes: &available,
+		CapacityBytes:  &capacity,
+		UsedBytes:      &used,
+		Inodes:         &inodes,
+		InodesFree:     &inodesFree,
+		InodesUsed:     &inodesUsed,
+	}, nil
+}
+
+func addUint64(sum *uint64, value uint64) *uint64 {
+	if sum == nil {
+		return &value
+	}
+
+	total := *sum + value
+
+	return &total
+}
+
+func derefUint64(v *uint64) uint64 {
+	if v == nil {
+		return 0
+	}
+
+	return *v
+}
+
+/*---------------------------------------------------
+ * Directory Sizes
+ *---------------------------------------------------*/
+
+// dirSizeCache memoizes the sizes of directories, so that frequent scrapes do not walk the shared filesystem.
+type dirSizeCache struct {
+	mu      sync.Mutex
+	entries map[string]dirSize
+}
+
+type dirSize struct {
+	usedBytes uint64
+	inodes    uint64
+	measured  time.Time
+}
+
+// get returns the size of a directory. ok is false if the directory does not exist.
+func (c *dirSizeCache) get(path string, now time.Time) (usedBytes uint64, inodes uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, exists := c.entries[path]; exists && now.Sub(entry.measured) < dirSizeTTL {
+		return entry.usedBytes, entry.inodes, true
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		delete(c.entries, path)
+
+		return 0, 0, false
+	}
+
+	usedBytes, inodes, err := usage.DirSize(path)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]dirSize)
+	}
+
+	c.entries[path] = dirSize{usedBytes: usedBytes, inodes: inodes, measured: now}
+
+	return usedBytes, inodes, true
+}
+
+// prune drops the entries of directories that have not been measured for a while, such as those of deleted pods.
+func (c *dirSizeCache) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for path, entry := range c.entries {
+		if now.Sub(entry.measured) > 2*dirSizeTTL {
+			delete(c.entries, path)
+		}
+	}
+}
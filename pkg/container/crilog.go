@@ -0,0 +1,177 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Stdout and Stderr are the devices of the log lines.
+	Stdout = "stdout"
+	Stderr = "stderr"
+
+	// MaxLogLineSize is the size after which a line is split into partial lines, as in containerd.
+	MaxLogLineSize = 16 * 1024
+)
+
+// LogWriter writes the output streams of a container to a log file, in the CRI logging format:
+//
+//	<RFC3339Nano timestamp> <stdout|stderr> <P|F> <message>
+//
+// The lines of the different streams are serialized, so that they can share the same file.
+type LogWriter struct {
+	mu  sync.Mutex
+	out io.Writer
+
+	// now is overridden by tests.
+	now func() time.Time
+}
+
+func NewLogWriter(out io.Writer) *LogWriter {
+	return &LogWriter{out: out, now: time.Now}
+}
+
+// Stream returns a writer for the given device (stdout or stderr). The writer must be closed
+// once the stream has ended, in order to flush any incomplete line.
+func (w *LogWriter) Stream(device string) io.WriteCloser {
+	return &streamWriter{parent: w, device: device}
+}
+
+func (w *LogWriter) writeLine(device string, logType string, msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err := fmt.Fprintf(w.out, "%s %s %s %s\n", w.now().Format(LogTimeFormat), device, logType, msg)
+
+	return err
+}
+
+type streamWriter struct {
+	parent *LogWriter
+	device string
+	buf    []byte
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+
+	for {
+		/*-- Complete lines --*/
+		if i := bytes.IndexByte(s.buf, '\n'); i >= 0 {
+			if err := s.flush(s.buf[:i]); err != nil {
+				return 0, err
+			}
+
+			s.buf = s.buf[i+1:]
+
+			continue
+		}
+
+		/*-- Lines that exceed the maximum size are split into partial lines --*/
+		if len(s.buf) >= MaxLogLineSize {
+			if err := s.parent.writeLine(s.device, PartialLogType, s.buf[:MaxLogLineSize]); err != nil {
+				return 0, err
+			}
+
+			s.buf = s.buf[MaxLogLineSize:]
+
+			continue
+		}
+
+		break
+	}
+
+	// do not keep the underlying array of the consumed lines.
+	s.buf = append([]byte(nil), s.buf...)
+
+	return len(p), nil
+}
+
+// flush writes a complete line, which may be split into partial lines.
+func (s *streamWriter) flush(line []byte) error {
+	for len(line) > MaxLogLineSize {
+		if err := s.parent.writeLine(s.device, PartialLogType, line[:MaxLogLineSize]); err != nil {
+			return err
+		}
+
+		line = line[MaxLogLineSize:]
+	}
+
+	return s.parent.writeLine(s.device, FullLogType, line)
+}
+
+// Close writes the last line of the stream, even if it is not terminated by a newline.
+func (s *streamWriter) Close() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	err := s.flush(s.buf)
+	s.buf = nil
+
+	return err
+}
+
+// ReadLogs decodes the CRI-formatted log lines from r, and writes the messages of the container to w.
+// Lines that are not in the CRI format (e.g, logs written by the legacy scripts) are copied verbatim.
+func ReadLogs(r io.Reader, w io.Writer, opts *LogOptions) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if err := WriteLogLine(w, strings.TrimSuffix(line, "\n"), opts); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// WriteLogLine decodes a single log line, and writes its message to w, with a timestamp if requested.
+func WriteLogLine(w io.Writer, rawLine string, opts *LogOptions) error {
+	logLine, err := NewLogLine(rawLine)
+	if err != nil {
+		_, err := fmt.Fprintln(w, rawLine)
+		return err
+	}
+
+	if !logLine.Since(opts.Since) || !logLine.Until(opts.Until) {
+		return nil
+	}
+
+	if logLine.Partial() {
+		_, err = fmt.Fprint(w, logLine.String(opts))
+	} else {
+		_, err = fmt.Fprintln(w, logLine.String(opts))
+	}
+
+	return err
+}
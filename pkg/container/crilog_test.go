@@ -0,0 +1,93 @@
+package container
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogWriterRoundTrip(t *testing.T) {
+	var file bytes.Buffer
+
+	ts := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	w := NewLogWriter(&file)
+	w.now = func() time.Time { return ts }
+
+	stdout, stderr := w.Stream(Stdout), w.Stream(Stderr)
+
+	_, _ = stdout.Write([]byte("hello "))
+	_, _ = stdout.Write([]byte("world\nsecond"))
+	_, _ = stderr.Write([]byte("oops\n"))
+	_ = stdout.Close()
+	_ = stderr.Close()
+
+	expectedFile := "" +
+		"2023-05-01T10:00:00.000000000Z stdout F hello world\n" +
+		"2023-05-01T10:00:00.000000000Z stderr F oops\n" +
+		"2023-05-01T10:00:00.000000000Z stdout F second\n"
+
+	if file.String() != expectedFile {
+		t.Fatalf("unexpected log file:\n%s\nwant:\n%s", file.String(), expectedFile)
+	}
+
+	/*-- Plain output --*/
+	var out strings.Builder
+	if err := ReadLogs(strings.NewReader(file.String()), &out, &LogOptions{}); err != nil {
+		t.Fatalf("read logs: %v", err)
+	}
+
+	if want := "hello world\noops\nsecond\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	/*-- Timestamped output --*/
+	out.Reset()
+	if err := ReadLogs(strings.NewReader(file.String()), &out, &LogOptions{Timestamps: true}); err != nil {
+		t.Fatalf("read logs: %v", err)
+	}
+
+	if !strings.HasPrefix(out.String(), "2023-05-01T10:00:00.000000000Z hello world\n") {
+		t.Errorf("expected timestamped output, got %q", out.String())
+	}
+}
+
+func TestLogWriterPartialLines(t *testing.T) {
+	var file bytes.Buffer
+
+	stdout := NewLogWriter(&file).Stream(Stdout)
+
+	long := strings.Repeat("a", MaxLogLineSize+10)
+	_, _ = stdout.Write([]byte(long + "\n"))
+	_ = stdout.Close()
+
+	lines := strings.Split(strings.TrimSuffix(file.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+
+	if !strings.Contains(lines[0], " stdout P ") || !strings.Contains(lines[1], " stdout F ") {
+		t.Errorf("unexpected log types: %q, %q", lines[0][:40], lines[1][:40])
+	}
+
+	var out strings.Builder
+	if err := ReadLogs(strings.NewReader(file.String()), &out, &LogOptions{}); err != nil {
+		t.Fatalf("read logs: %v", err)
+	}
+
+	if out.String() != long+"\n" {
+		t.Errorf("partial lines were not joined")
+	}
+}
+
+func TestReadLogsLegacyFormat(t *testing.T) {
+	var out strings.Builder
+	if err := ReadLogs(strings.NewReader("plain line\nanother"), &out, &LogOptions{}); err != nil {
+		t.Fatalf("read logs: %v", err)
+	}
+
+	if want := "plain line\nanother\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
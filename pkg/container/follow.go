@@ -0,0 +1,340 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// FollowPollInterval is how often a followed log file is checked for new data.
+// The log files live on a shared filesystem, so inotify cannot be trusted to see the writes of remote nodes.
+var FollowPollInterval = 500 * time.Millisecond
+
+// FollowLogs streams the log file at path to w, and keeps streaming new lines as they are appended.
+//
+// The existing lines, including those of the rotated files, are selected as in ReadLogFiles. The follower
+// survives the rotation and the truncation of the file. It returns once ctx is cancelled, once LimitBytes
+// have been written, or once exited reports true and the remaining data of the file have been drained.
+func FollowLogs(ctx context.Context, path string, w io.Writer, opts *LogOptions, exited func() bool) error {
+	f := &follower{path: path, w: limitWriter(w, opts.LimitBytes), opts: opts, exited: exited}
+
+	/*-- Wait for the log file to appear --*/
+	for {
+		file, err := os.Open(path)
+		if err == nil {
+			f.file = file
+			break
+		}
+
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		if exited() {
+			return nil
+		}
+
+		if !f.sleep(ctx) {
+			return nil
+		}
+	}
+
+	defer func() {
+		f.file.Close()
+	}()
+
+	/*-- Stream the existing data --*/
+	if err := f.copyExisting(); err != nil {
+		return ignoreLimit(err)
+	}
+
+	f.reader = bufio.NewReader(f.file)
+
+	return ignoreLimit(f.follow(ctx))
+}
+
+type follower struct {
+	path   string
+	w      io.Writer
+	opts   *LogOptions
+	exited func() bool
+
+	file   *os.File
+	reader *bufio.Reader
+	offset int64
+
+	// pending holds an incomplete line, until its newline is written.
+	pending string
+}
+
+func (f *follower) sleep(ctx context.Context) bool {
+	timer := time.NewTimer(FollowPollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// copyExisting streams the selected lines of the rotated files, and positions the current file
+// at the first selected line that is not yet streamed.
+func (f *follower) copyExisting() error {
+	var rotatedPaths []string
+
+	for _, path := range LogFiles(f.path) {
+		if path != f.path {
+			rotatedPaths = append(rotatedPaths, path)
+		}
+	}
+
+	rotated, err := openLogFiles(rotatedPaths)
+	if err != nil {
+		return err
+	}
+
+	defer closeLogFiles(rotated)
+
+	segments, err := selectLogSegments(append(rotated, f.file), f.opts)
+	if err != nil {
+		return err
+	}
+
+	// the current file is the newest one, so it is always the last segment.
+	current := segments[len(segments)-1]
+
+	if err := writeLogSegments(segments[:len(segments)-1], f.w, f.opts); err != nil {
+		return err
+	}
+
+	if _, err := f.file.Seek(current.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	f.offset = current.offset
+
+	return nil
+}
+
+func (f *follower) follow(ctx context.Context) error {
+	// the exit status is checked before draining the file, so that the lines written just before
+	// the exit of the container are not lost.
+	exited := f.exited()
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		line, err := f.reader.ReadString('\n')
+		f.offset += int64(len(line))
+
+		if err == nil {
+			f.pending += line
+
+			if err := f.flushPending(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		f.pending += line
+
+		/*-- Reached the end of the file --*/
+		reopened, err := f.reopenIfChanged()
+		if err != nil {
+			return err
+		}
+
+		if reopened {
+			continue
+		}
+
+		if exited {
+			return f.flushPending()
+		}
+
+		if !f.sleep(ctx) {
+			return nil
+		}
+
+		exited = f.exited()
+	}
+}
+
+// reopenIfChanged handles the rotation and the truncation of the log file. It returns true
+// if the follower must continue from a different position.
+func (f *follower) reopenIfChanged() (bool, error) {
+	current, err := f.file.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	latest, err := os.Stat(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// the file has been rotated, and the new one is not created yet.
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if !os.SameFile(current, latest) {
+		/*-- Rotated: drain what was written to the old file before the rotation, and continue with the new one --*/
+		if err := f.drain(); err != nil {
+			return false, err
+		}
+
+		file, err := os.Open(f.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return false, nil
+			}
+
+			return false, err
+		}
+
+		f.file.Close()
+		f.file = file
+		f.reader.Reset(file)
+		f.offset = 0
+
+		return true, nil
+	}
+
+	if latest.Size() < f.offset {
+		/*-- Truncated: start over --*/
+		if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+
+		f.reader.Reset(f.file)
+		f.offset = 0
+		f.pending = ""
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// drain streams the remaining data of the current file, including any incomplete line.
+func (f *follower) drain() error {
+	for {
+		line, err := f.reader.ReadString('\n')
+		f.pending += line
+
+		if err == nil {
+			if err := f.flushPending(); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if errors.Is(err, io.EOF) {
+			return f.flushPending()
+		}
+
+		return err
+	}
+}
+
+func (f *follower) flushPending() error {
+	if f.pending == "" {
+		return nil
+	}
+
+	err := WriteLogLine(f.w, strings.TrimSuffix(f.pending, "\n"), f.opts)
+	f.pending = ""
+
+	return err
+}
+
+// TailOffset returns the offset of the file from which the last n lines begin.
+func TailOffset(file *os.File, n int) (int64, error) {
+	offset, _, err := tailOffset(file, n)
+
+	return offset, err
+}
+
+// tailOffset returns the offset from which the last n lines begin, along with the number of lines found,
+// which is less than n if the file is shorter.
+func tailOffset(file *os.File, n int) (offset int64, found int, err error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	offset = info.Size()
+	if offset == 0 || n <= 0 {
+		return offset, 0, nil
+	}
+
+	buf := make([]byte, os.Getpagesize())
+
+	// a trailing newline terminates the last line, and does not start a new one.
+	lines := 0
+
+	if _, err := file.ReadAt(buf[:1], offset-1); err != nil {
+		return 0, 0, err
+	}
+
+	if buf[0] == '\n' {
+		lines = -1
+	}
+
+	for offset > 0 {
+		size := int64(len(buf))
+		if offset < size {
+			size = offset
+		}
+
+		offset -= size
+
+		if _, err := file.ReadAt(buf[:size], offset); err != nil && !errors.Is(err, io.EOF) {
+			return 0, 0, err
+		}
+
+		for i := size - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+
+			lines++
+
+			if lines == n {
+				return offset + i + 1, n, nil
+			}
+		}
+	}
+
+	// the first line of the file is not preceded by a newline.
+	return 0, lines + 1, nil
+}
@@ -0,0 +1,181 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a buffer that can be read while the follower writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func appendFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Close()
+}
+
+func waitFor(t *testing.T, out *syncBuffer, expected string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for out.String() != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %q, want %q", out.String(), expected)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	FollowPollInterval = 5 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "c.logs")
+
+	var exited atomic.Bool
+
+	out := &syncBuffer{}
+	done := make(chan error)
+
+	go func() {
+		done <- FollowLogs(context.Background(), path, out, &LogOptions{}, exited.Load)
+	}()
+
+	/*-- The file does not exist yet --*/
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, "one\n")
+	waitFor(t, out, "one\n")
+
+	/*-- Incomplete lines are held back until they are complete --*/
+	appendFile(t, path, "tw")
+	appendFile(t, path, "o\n")
+	waitFor(t, out, "one\ntwo\n")
+
+	/*-- Rotation --*/
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	appendFile(t, path+".1", "three\n")
+	appendFile(t, path, "four\n")
+	waitFor(t, out, "one\ntwo\nthree\nfour\n")
+
+	/*-- Truncation --*/
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	appendFile(t, path, "five\n")
+	waitFor(t, out, "one\ntwo\nthree\nfour\nfive\n")
+
+	/*-- Lines written before the exit are drained --*/
+	appendFile(t, path, "six\n")
+	exited.Store(true)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower did not stop after exit")
+	}
+
+	if got, want := out.String(), "one\ntwo\nthree\nfour\nfive\nsix\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFollowLogsCancel(t *testing.T) {
+	FollowPollInterval = 5 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "c.logs")
+	appendFile(t, path, "a\nb\nc\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	out := &syncBuffer{}
+	done := make(chan error)
+
+	go func() {
+		done <- FollowLogs(ctx, path, out, &LogOptions{Tail: 2}, func() bool { return false })
+	}()
+
+	waitFor(t, out, "b\nc\n")
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower did not stop after cancellation")
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		content string
+		lines   int
+		offset  int64
+	}{
+		{"a\nb\nc\n", 1, 4},
+		{"a\nb\nc\n", 2, 2},
+		{"a\nb\nc\n", 5, 0},
+		{"a\nb\nc", 1, 4},
+		{"", 3, 0},
+	} {
+		path := filepath.Join(dir, "logs")
+		if err := os.WriteFile(path, []byte(tc.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		offset, err := TailOffset(f, tc.lines)
+		f.Close()
+
+		if err != nil || offset != tc.offset {
+			t.Errorf("%q (%d lines): got %d (%v), want %d", tc.content, tc.lines, offset, err, tc.offset)
+		}
+	}
+}
@@ -58,6 +58,22 @@ func ExpandContainerCommandOnlyStatic(containerCommand []string, envs []corev1.E
 	return command
 }
 
+// ExpandContainerCommandAndArgs expands the command and the args of a container with the given
+// environment, which is expected to be fully resolved.
+func ExpandContainerCommandAndArgs(container *corev1.Container, envs []corev1.EnvVar) (command []string, args []string) {
+	mapping := expansion.MappingFuncFor(v1EnvVarsToMap(envs))
+
+	for _, cmd := range container.Command {
+		command = append(command, expansion.Expand(cmd, mapping))
+	}
+
+	for _, arg := range container.Args {
+		args = append(args, expansion.Expand(arg, mapping))
+	}
+
+	return command, args
+}
+
 // ExpandContainerVolumeMounts expands the subpath of the given VolumeMount by replacing variable references with the values of given EnvVar.
 func ExpandContainerVolumeMounts(mount corev1.VolumeMount, envs []corev1.EnvVar) (string, error) {
 
@@ -0,0 +1,253 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// errLimitReached is returned by the limitWriter once LogOptions.LimitBytes have been written.
+var errLimitReached = errors.New("log limit reached")
+
+// ReadLogFiles streams the log file at path, along with its rotated files, to w. The lines are selected by
+// the Tail, Since and Until options, and the output is truncated at LimitBytes.
+//
+// Only the files (and the parts of them) that contain the selected lines are read, so that
+// requests like `kubectl logs --since=10m` do not scan the entire log.
+func ReadLogFiles(path string, w io.Writer, opts *LogOptions) error {
+	files, err := openLogFiles(LogFiles(path))
+	if err != nil {
+		return err
+	}
+
+	defer closeLogFiles(files)
+
+	segments, err := selectLogSegments(files, opts)
+	if err != nil {
+		return err
+	}
+
+	return ignoreLimit(writeLogSegments(segments, limitWriter(w, opts.LimitBytes), opts))
+}
+
+// logSegment is the part of a log file that starts at offset.
+type logSegment struct {
+	file   *os.File
+	offset int64
+}
+
+func openLogFiles(paths []string) ([]*os.File, error) {
+	files := make([]*os.File, 0, len(paths))
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				// the file has been rotated in the meantime.
+				continue
+			}
+
+			closeLogFiles(files)
+
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func closeLogFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
+
+// selectLogSegments walks the files (given from the oldest to the newest) backwards, and returns the
+// segments that hold the lines selected by the Tail and Since options, from the oldest to the newest.
+func selectLogSegments(files []*os.File, opts *LogOptions) ([]logSegment, error) {
+	var segments []logSegment
+
+	remaining := int(opts.Tail)
+
+	for i := len(files) - 1; i >= 0; i-- {
+		var start int64
+
+		complete := false
+
+		/*-- Tail --*/
+		if opts.Tail > 0 {
+			offset, found, err := tailOffset(files[i], remaining)
+			if err != nil {
+				return nil, err
+			}
+
+			remaining -= found
+			start = offset
+
+			if remaining <= 0 {
+				complete = true
+			}
+		}
+
+		/*-- Since --*/
+		if !opts.Since.IsZero() {
+			offset, found, err := SinceOffset(files[i], opts.Since)
+			if err != nil {
+				return nil, err
+			}
+
+			if offset > start {
+				start = offset
+			}
+
+			if found {
+				complete = true
+			}
+		}
+
+		segments = append([]logSegment{{file: files[i], offset: start}}, segments...)
+
+		if complete {
+			break
+		}
+	}
+
+	return segments, nil
+}
+
+func writeLogSegments(segments []logSegment, w io.Writer, opts *LogOptions) error {
+	for _, segment := range segments {
+		if _, err := segment.file.Seek(segment.offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := ReadLogs(segment.file, w, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SinceOffset returns the offset of the file from which the lines logged at, or after, since begin.
+// The file is read backwards, until a line that was logged before since is found. If there is no such line,
+// the offset is zero and found is false. Lines that are not in the CRI format are skipped.
+func SinceOffset(file *os.File, since time.Time) (offset int64, found bool, err error) {
+	rr, err := NewReverseReader(file)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// before is true for the lines that precede since.
+	before := func(line string) bool {
+		logLine, err := NewLogLine(strings.TrimSuffix(line, "\n"))
+
+		return err == nil && logLine.Time.Before(since)
+	}
+
+	// leftover is the beginning of the file, up to the first line that has been checked.
+	var leftover string
+
+	for {
+		chunkOffset := rr.offset
+
+		chunk, err := rr.Read()
+		if errors.Is(err, io.EOF) {
+			/*-- The first line of the file --*/
+			if before(leftover) {
+				return int64(len(leftover)), true, nil
+			}
+
+			return 0, false, nil
+		}
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		text := chunk + leftover
+
+		// the text up to the first newline belongs to a line that starts in a previous chunk.
+		first := strings.IndexByte(text, '\n')
+		if first < 0 {
+			leftover = text
+			continue
+		}
+
+		leftover = text[:first+1]
+
+		/*-- Check the complete lines of the chunk, from the last to the first --*/
+		end := len(text)
+
+		for end > first+1 {
+			start := strings.LastIndexByte(text[:end-1], '\n') + 1
+
+			if before(text[start:end]) {
+				return chunkOffset + int64(end), true, nil
+			}
+
+			end = start
+		}
+	}
+}
+
+// limitWriter returns a writer that writes up to limit bytes to w. A limit of zero means no limit.
+func limitWriter(w io.Writer, limit int64) io.Writer {
+	if limit <= 0 {
+		return w
+	}
+
+	return &limitedWriter{w: w, remaining: limit}
+}
+
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, errLimitReached
+	}
+
+	truncated := int64(len(p)) > l.remaining
+	if truncated {
+		p = p[:l.remaining]
+	}
+
+	n, err := l.w.Write(p)
+	l.remaining -= int64(n)
+
+	if err == nil && truncated {
+		err = errLimitReached
+	}
+
+	return n, err
+}
+
+// ignoreLimit hides the error of reaching the byte limit, as it is the expected end of the stream.
+func ignoreLimit(err error) error {
+	if errors.Is(err, errLimitReached) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,166 @@
+package container
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixtureLogs is a rotated log, from the oldest to the newest file:
+//
+//	c.logs.2: legacy-0, line-1 (10:00), line-2 (10:01)
+//	c.logs.1: line-3 (10:02), part- (10:03, partial), ial-4 (10:03:00.5)
+//	c.logs:   line-5 (10:04), line-6 (10:05)
+var fixtureLogs = filepath.Join("testdata", "rotated", "c.logs")
+
+func at(clock string) time.Time {
+	t, err := time.Parse(time.RFC3339, "2023-05-01T"+clock+"Z")
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func TestReadLogFiles(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     LogOptions
+		expected string
+	}{
+		{
+			name:     "all",
+			opts:     LogOptions{},
+			expected: "legacy-0\nline-1\nline-2\nline-3\npart-ial-4\nline-5\nline-6\n",
+		},
+		{
+			name:     "tail within the current file",
+			opts:     LogOptions{Tail: 2},
+			expected: "line-5\nline-6\n",
+		},
+		{
+			name:     "tail across rotated files",
+			opts:     LogOptions{Tail: 4},
+			expected: "part-ial-4\nline-5\nline-6\n",
+		},
+		{
+			name:     "tail longer than the logs",
+			opts:     LogOptions{Tail: 100},
+			expected: "legacy-0\nline-1\nline-2\nline-3\npart-ial-4\nline-5\nline-6\n",
+		},
+		{
+			name:     "since",
+			opts:     LogOptions{Since: at("10:02:30")},
+			expected: "part-ial-4\nline-5\nline-6\n",
+		},
+		{
+			name:     "since is inclusive",
+			opts:     LogOptions{Since: at("10:01:00")},
+			expected: "line-2\nline-3\npart-ial-4\nline-5\nline-6\n",
+		},
+		{
+			name:     "since after the last line",
+			opts:     LogOptions{Since: at("11:00:00")},
+			expected: "",
+		},
+		{
+			name:     "since with tail",
+			opts:     LogOptions{Since: at("10:00:30"), Tail: 2},
+			expected: "line-5\nline-6\n",
+		},
+		{
+			name:     "limit bytes",
+			opts:     LogOptions{LimitBytes: 10},
+			expected: "legacy-0\nl",
+		},
+		{
+			name:     "timestamps",
+			opts:     LogOptions{Tail: 1, Timestamps: true},
+			expected: "2023-05-01T10:05:00.000000000Z line-6\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+
+			opts := tt.opts
+			if err := ReadLogFiles(fixtureLogs, &out, &opts); err != nil {
+				t.Fatalf("read: %v", err)
+			}
+
+			if got := out.String(); got != tt.expected {
+				t.Errorf("got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadLogFilesMissing(t *testing.T) {
+	var out bytes.Buffer
+
+	if err := ReadLogFiles(filepath.Join(t.TempDir(), "c.logs"), &out, &LogOptions{}); err != nil || out.Len() != 0 {
+		t.Errorf("expected empty logs, got %q (%v)", out.String(), err)
+	}
+}
+
+func TestSinceOffset(t *testing.T) {
+	// lines longer than a page exercise lines that span multiple chunks of the reverse reader.
+	long := string(bytes.Repeat([]byte("x"), 2*os.Getpagesize()))
+
+	content := "2023-05-01T10:00:00.000000000Z stdout F " + long + "\n" +
+		"not a cri line\n" +
+		"2023-05-01T10:01:00.000000000Z stdout F " + long + "\n" +
+		"2023-05-01T10:02:00.000000000Z stdout F short\n"
+
+	path := filepath.Join(t.TempDir(), "c.logs")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	firstLine := int64(len("2023-05-01T10:00:00.000000000Z stdout F " + long + "\n"))
+	secondLine := firstLine + int64(len("not a cri line\n"))
+	thirdLine := secondLine + int64(len("2023-05-01T10:01:00.000000000Z stdout F "+long+"\n"))
+
+	for _, tc := range []struct {
+		since  time.Time
+		offset int64
+		found  bool
+	}{
+		{at("09:00:00"), 0, false},
+		{at("10:00:00"), 0, false},
+		{at("10:00:30"), firstLine, true},
+		{at("10:01:30"), thirdLine, true},
+		{at("10:03:00"), int64(len(content)), true},
+	} {
+		offset, found, err := SinceOffset(f, tc.since)
+		if err != nil || offset != tc.offset || found != tc.found {
+			t.Errorf("since %v: got (%d, %v, %v), want (%d, %v)", tc.since, offset, found, err, tc.offset, tc.found)
+		}
+	}
+}
+
+func TestGetTailLog(t *testing.T) {
+	lines, err := GetTailLog(fixtureLogs+".1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"2023-05-01T10:03:00.000000000Z stdout P part-",
+		"2023-05-01T10:03:00.500000000Z stdout F ial-4",
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("got %q, want %q", lines, expected)
+	}
+}
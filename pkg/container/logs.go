@@ -54,6 +54,7 @@ type LogOptions struct {
 	Since      time.Time
 	Until      time.Time
 	Tail       int64
+	LimitBytes int64
 	Timestamps bool
 	Colors     bool
 	Multi      bool
@@ -72,61 +73,35 @@ type LogLine struct {
 	ColorID      int64
 }
 
+// GetTailLog returns the last tail lines of the log file at path, from the oldest to the newest.
 func GetTailLog(path string, tail int) ([]string, error) {
-	var (
-		nllCounter int
-		leftover   string
-		tailLog    []string
-	)
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	rr, err := NewReverseReader(f)
+
+	defer f.Close()
+
+	offset, err := TailOffset(f, tail)
 	if err != nil {
 		return nil, err
 	}
 
-	inputs := make(chan []string)
-	go func() {
-		for {
-			s, err := rr.Read()
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					inputs <- []string{leftover}
-				} else {
-					logrus.Error(err)
-				}
-				close(inputs)
-				if err := f.Close(); err != nil {
-					logrus.Error(err)
-				}
-				break
-			}
-			line := strings.Split(s+leftover, "\n")
-			if len(line) > 1 {
-				inputs <- line[1:]
-			}
-			leftover = line[0]
-		}
-	}()
-
-	for i := range inputs {
-		// the incoming array is FIFO; we want FIFO so
-		// reverse the slice read order
-		for j := len(i) - 1; j >= 0; j-- {
-			// lines that are "" are junk
-			if len(i[j]) < 1 {
-				continue
-			}
-			// read the content in reverse and add each nll until we have the same
-			// number of F type messages as the desired tail
-			tailLog = append(tailLog, i[j])
-			nllCounter++
-		}
-		// if we have enough log lines, we can hang up
-		if nllCounter >= tail {
-			break
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	var tailLog []string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// lines that are "" are junk
+		if len(line) > 0 {
+			tailLog = append(tailLog, line)
 		}
 	}
 
@@ -180,9 +155,9 @@ func (l *LogLine) String(options *LogOptions) string {
 	return out
 }
 
-// Since returns a bool as to whether a log line occurred after a given time
+// Since returns a bool as to whether a log line occurred at, or after, a given time
 func (l *LogLine) Since(since time.Time) bool {
-	return l.Time.After(since) || since.IsZero()
+	return !l.Time.Before(since) || since.IsZero()
 }
 
 // Until returns a bool as to whether a log line occurred before a given time
@@ -0,0 +1,176 @@
+// Copyright © 2023 FORTH-ICS
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package container
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RotatingFile is a log file that is rotated once it exceeds a maximum size, in the same way as the
+// containerLogMaxSize and containerLogMaxFiles of the kubelet. The rotated files are named
+// <path>.1 (the most recent) to <path>.<maxFiles-1> (the oldest).
+type RotatingFile struct {
+	mu sync.Mutex
+
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+// CreateRotatingFile creates (or truncates) the log file at the given path. If maxSize is not positive,
+// the file is never rotated. maxFiles is the total number of files, including the current one.
+func CreateRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		file:     file,
+	}, nil
+}
+
+// Write appends p to the log file. The file is rotated before the write, if the write would exceed the maximum size.
+// Callers are expected to write whole lines, so that lines are not split across files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// rotate shifts the rotated files by one, drops the oldest, and starts a new current file.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	if f.maxFiles > 1 {
+		for i := f.maxFiles - 2; i >= 1; i-- {
+			if err := os.Rename(rotatedPath(f.path, i), rotatedPath(f.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if err := os.Rename(f.path, rotatedPath(f.path, 1)); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.Create(f.path)
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	f.size = 0
+
+	return nil
+}
+
+func rotatedPath(path string, index int) string {
+	return fmt.Sprintf("%s.%d", path, index)
+}
+
+// LogFiles returns the existing files of a rotated log, from the oldest to the current one.
+func LogFiles(path string) []string {
+	matches, _ := filepath.Glob(path + ".*")
+
+	type rotated struct {
+		path  string
+		index int
+	}
+
+	var files []rotated
+
+	for _, match := range matches {
+		index, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil || index <= 0 {
+			continue
+		}
+
+		files = append(files, rotated{path: match, index: index})
+	}
+
+	// the oldest file has the highest index.
+	sort.Slice(files, func(i, j int) bool { return files[i].index > files[j].index })
+
+	paths := make([]string, 0, len(files)+1)
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// ArchiveLogFile keeps the log files of a terminated container instance at previousPath, along with its rotated
+// files, so that they can be served by `kubectl logs --previous`. The files of the instance before it are removed.
+func ArchiveLogFile(path string, previousPath string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.Size() == 0) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, previous := range LogFiles(previousPath) {
+		if err := os.Remove(previous); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// the rotated files keep their index, so that they are read in the same order.
+	for _, rotated := range LogFiles(path) {
+		if err := os.Rename(rotated, previousPath+strings.TrimPrefix(rotated, path)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package container
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.logs")
+
+	f, err := CreateRotatingFile(path, 10, 3)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	for _, line := range []string{"line-1\n", "line-2\n", "line-3\n", "line-4\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// every line exceeds the half of the maximum size, so each file holds a single line,
+	// and the oldest line has been dropped.
+	expected := map[string]string{
+		path + ".2": "line-2\n",
+		path + ".1": "line-3\n",
+		path:        "line-4\n",
+	}
+
+	for file, content := range expected {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+
+		if string(data) != content {
+			t.Errorf("file %s: got %q, want %q", file, data, content)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no more than 3 files")
+	}
+
+	if got, want := LogFiles(path), []string{path + ".2", path + ".1", path}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
+
+func TestArchiveLogFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "c.logs")
+	previous := filepath.Join(dir, "c.previous.logs")
+
+	for file, content := range map[string]string{
+		path:            "current\n",
+		path + ".1":     "rotated\n",
+		previous + ".2": "stale\n",
+	} {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	if err := ArchiveLogFile(path, previous); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+
+	data, err := os.ReadFile(previous)
+	if err != nil || string(data) != "current\n" {
+		t.Errorf("unexpected previous log: %q, %v", data, err)
+	}
+
+	if files := LogFiles(path); len(files) != 0 {
+		t.Errorf("expected no log files after archiving, got %v", files)
+	}
+
+	/*-- The rotated files are archived too, and replace those of the older instance --*/
+	if got, want := LogFiles(previous), []string{previous + ".1", previous}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got previous files %v, want %v", got, want)
+	}
+
+	if data, err := os.ReadFile(previous + ".1"); err != nil || string(data) != "rotated\n" {
+		t.Errorf("unexpected rotated previous log: %q, %v", data, err)
+	}
+
+	/*-- Archiving a missing log is a no-op --*/
+	if err := ArchiveLogFile(path, previous); err != nil {
+		t.Errorf("archive missing log: %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package crdtools
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSetPodStatusConditionKeepsTransitionTime(t *testing.T) {
+	since := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+
+	conditions := []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse, LastTransitionTime: since}}
+
+	/*-- An unchanged status keeps the time of the last transition --*/
+	SetPodStatusCondition(&conditions, corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionFalse, Message: "again"})
+
+	if !conditions[0].LastTransitionTime.Equal(&since) || conditions[0].Message != "again" {
+		t.Errorf("got %+v, want the transition time %v", conditions[0], since)
+	}
+
+	/*-- A changed status records a new transition --*/
+	SetPodStatusCondition(&conditions, corev1.PodCondition{Type: corev1.PodReady, Status: corev1.ConditionTrue})
+
+	if !conditions[0].LastTransitionTime.After(since.Time) {
+		t.Errorf("got transition time %v, want a time after %v", conditions[0].LastTransitionTime, since)
+	}
+
+	/*-- A new condition is stamped with the current time --*/
+	SetPodStatusCondition(&conditions, corev1.PodCondition{Type: corev1.ContainersReady, Status: corev1.ConditionTrue})
+
+	if len(conditions) != 2 || conditions[1].LastTransitionTime.IsZero() {
+		t.Errorf("got %+v", conditions)
+	}
+}
@@ -23,6 +23,7 @@ package process
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -37,6 +38,32 @@ func Execute(command string, arguments ...string) (out []byte, err error) {
 	return ExecuteInDir("", command, arguments...)
 }
 
+// ExecuteContext runs system command and returns whole output also in case of error.
+// The command is killed if the context is done before it completes.
+func ExecuteContext(ctx context.Context, command string, arguments ...string) (out []byte, err error) {
+	cmd := exec.CommandContext(ctx, command, arguments...)
+
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, GoEnviron...)
+
+	buffer := new(bytes.Buffer)
+	cmd.Stdout = buffer
+	cmd.Stderr = buffer
+	if err = cmd.Start(); err != nil {
+		return buffer.Bytes(), fmt.Errorf("could not start process: %w", err)
+	}
+
+	if err = cmd.Wait(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return buffer.Bytes(), fmt.Errorf("process error: %w", ctxErr)
+		}
+
+		return buffer.Bytes(), fmt.Errorf("process error: %w\noutput: %s", err, buffer.String())
+	}
+
+	return buffer.Bytes(), nil
+}
+
 // ExecuteInDir runs system command and returns whole output also in case of error in a specific directory
 func ExecuteInDir(dir string, command string, arguments ...string) (out []byte, err error) {
 	cmd := exec.Command(command, arguments...)